@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"html/template"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// asset is one file under static/, held in memory along with the
+// content-hashed name it is also served under.
+type asset struct {
+	name   string // e.g. "script.js"
+	hashed string // e.g. "script.1a2b3c4d5e.js"
+	etag   string
+	data   []byte
+	gz, br []byte // precompressed variants, nil when not worthwhile
+}
+
+// assetManifest indexes the static files by both their plain and hashed
+// names. Hashed URLs change whenever the content does, so they can be
+// cached forever; plain URLs keep working but must be revalidated.
+type assetManifest struct {
+	byName   map[string]*asset
+	byHashed map[string]*asset
+}
+
+// assets is built from the embedded static files at startup.
+var assets = mustBuildManifest(embeddedFS)
+
+func mustBuildManifest(fsys fs.FS) *assetManifest {
+	m, err := buildManifest(fsys)
+	if err != nil {
+		panic("failed to build asset manifest: " + err.Error())
+	}
+	return m
+}
+
+// cssURL matches url(...) references in stylesheets, with or without quotes.
+var cssURL = regexp.MustCompile(`url\(\s*(['"]?)([^'")]+)(['"]?)\s*\)`)
+
+// buildManifest reads every file under static/ in fsys. Stylesheets are
+// processed last so their url(...) references to other static files can be
+// rewritten to hashed names before the stylesheet itself is hashed.
+func buildManifest(fsys fs.FS) (*assetManifest, error) {
+	m := &assetManifest{byName: map[string]*asset{}, byHashed: map[string]*asset{}}
+	var names []string
+	err := fs.WalkDir(fsys, "static", func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		names = append(names, strings.TrimPrefix(p, "static/"))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(names, func(i, j int) bool {
+		return path.Ext(names[i]) != ".css" && path.Ext(names[j]) == ".css"
+	})
+
+	for _, name := range names {
+		data, err := fs.ReadFile(fsys, "static/"+name)
+		if err != nil {
+			return nil, err
+		}
+		if path.Ext(name) == ".css" {
+			data = m.rewriteCSS(name, data)
+		}
+		sum := sha256.Sum256(data)
+		hash := hex.EncodeToString(sum[:5])
+		ext := path.Ext(name)
+		a := &asset{
+			name:   name,
+			hashed: strings.TrimSuffix(name, ext) + "." + hash + ext,
+			etag:   `"` + hash + `"`,
+			data:   data,
+		}
+		if isCompressible(mime.TypeByExtension(ext)) {
+			a.gz, a.br = precompress(data)
+		}
+		m.byName[a.name] = a
+		m.byHashed[a.hashed] = a
+	}
+	return m, nil
+}
+
+// rewriteCSS points relative url(...) references at hashed names.
+func (m *assetManifest) rewriteCSS(name string, data []byte) []byte {
+	dir := path.Dir(name)
+	return cssURL.ReplaceAllFunc(data, func(match []byte) []byte {
+		sub := cssURL.FindSubmatch(match)
+		ref := string(sub[2])
+		if strings.Contains(ref, ":") || strings.HasPrefix(ref, "/") {
+			return match // data: URIs, absolute URLs
+		}
+		target, ok := m.byName[path.Join(dir, ref)]
+		if !ok {
+			return match
+		}
+		rel := path.Join(path.Dir(ref), path.Base(target.hashed))
+		return bytes.Join([][]byte{[]byte("url("), sub[1], []byte(rel), sub[3], []byte(")")}, nil)
+	})
+}
+
+// assetURL returns the URL to reference a static file by: the hashed name
+// in production, or the plain name in dev mode (where files are read from
+// disk and may change at any time). Unknown names are returned unhashed so
+// a typo shows up as a 404 rather than a template error.
+func assetURL(name string) string {
+	if !cfg.Dev {
+		if a, ok := assets.byName[name]; ok {
+			return "/static/" + a.hashed
+		}
+	}
+	return "/static/" + name
+}
+
+// templateFuncs are available to every template.
+var templateFuncs = template.FuncMap{
+	"asset": assetURL,
+}
+
+// staticHandler serves /static/ (with the prefix already stripped) from the
+// manifest. Hashed names are cached for cfg.StaticMaxAge as immutable;
+// plain names get an ETag and must be revalidated, so a deploy is picked
+// up immediately. Text assets are served from the gzip or brotli variant
+// built at startup when the client accepts one. In dev mode files come
+// straight from disk, uncached.
+func staticHandler() http.Handler {
+	immutable := "public, max-age=" + strconv.Itoa(int(cfg.StaticMaxAge.Seconds())) + ", immutable"
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if cfg.Dev {
+			static, err := fs.Sub(siteFS, "static")
+			if err != nil {
+				http.NotFound(w, r)
+				return
+			}
+			w.Header().Set("Cache-Control", "no-cache")
+			http.FileServerFS(static).ServeHTTP(w, r)
+			return
+		}
+
+		name := strings.TrimPrefix(r.URL.Path, "/")
+		a, hashed := assets.byHashed[name]
+		if hashed {
+			w.Header().Set("Cache-Control", immutable)
+		} else if a = assets.byName[name]; a != nil {
+			w.Header().Set("Cache-Control", "public, max-age=0, must-revalidate")
+		} else {
+			http.NotFound(w, r)
+			return
+		}
+		data, etag := a.data, a.etag
+		if a.gz != nil || a.br != nil {
+			w.Header().Add("Vary", "Accept-Encoding")
+			// Only variants that exist are negotiated, so a client that
+			// refuses one never gets it in place of another.
+			var offered []string
+			if a.br != nil {
+				offered = append(offered, encodingBrotli)
+			}
+			if a.gz != nil {
+				offered = append(offered, encodingGzip)
+			}
+			enc := negotiateAmong(r.Header.Get("Accept-Encoding"), offered...)
+			switch enc {
+			case encodingBrotli:
+				data = a.br
+			case encodingGzip:
+				data = a.gz
+			}
+			if enc != "" {
+				w.Header().Set("Content-Encoding", enc)
+				etag = strings.TrimSuffix(etag, `"`) + "-" + enc + `"`
+			}
+		}
+		w.Header().Set("ETag", etag)
+		http.ServeContent(w, r, a.name, time.Time{}, bytes.NewReader(data))
+	})
+}
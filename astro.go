@@ -0,0 +1,273 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// Low-precision solar and lunar positions after Jean Meeus, Astronomical
+// Algorithms (2nd ed.), chapters 25 and 47. The lunar series is truncated
+// to its largest terms, which keeps longitude within about 0.01 degrees:
+// phase times to a few minutes, which is plenty for a calendar.
+
+const deg = math.Pi / 180
+
+// julianDay returns the Julian day number of t.
+func julianDay(t time.Time) float64 {
+	return float64(t.UnixMilli())/86400000 + 2440587.5
+}
+
+// julianCenturies returns the Julian centuries of t since J2000.0.
+func julianCenturies(t time.Time) float64 {
+	return (julianDay(t) - 2451545) / 36525
+}
+
+// norm360 reduces an angle in degrees to [0, 360).
+func norm360(a float64) float64 {
+	a = math.Mod(a, 360)
+	if a < 0 {
+		a += 360
+	}
+	return a
+}
+
+// sunPosition returns the Sun's apparent ecliptic longitude in degrees and
+// its distance in kilometres at t.
+func sunPosition(t time.Time) (lon, dist float64) {
+	T := julianCenturies(t)
+	L0 := 280.46646 + 36000.76983*T + 0.0003032*T*T
+	M := 357.52911 + 35999.05029*T - 0.0001537*T*T
+	C := (1.914602-0.004817*T-0.000014*T*T)*math.Sin(M*deg) +
+		(0.019993-0.000101*T)*math.Sin(2*M*deg) +
+		0.000289*math.Sin(3*M*deg)
+	e := 0.016708634 - 0.000042037*T
+	v := M + C
+	R := 1.000001018 * (1 - e*e) / (1 + e*math.Cos(v*deg))
+	omega := 125.04 - 1934.136*T
+	lon = norm360(L0 + C - 0.00569 - 0.00478*math.Sin(omega*deg))
+	return lon, R * 149597870.7
+}
+
+// lunarTerm is one periodic term of the lunar series: multiples of D, M,
+// M' and F and the coefficient of their sine (longitude, latitude) or
+// cosine (distance).
+type lunarTerm struct {
+	d, m, mp, f float64
+	coef        float64
+}
+
+// moonLonTerms are the largest terms of Meeus table 47.A for longitude, in
+// millionths of a degree.
+var moonLonTerms = []lunarTerm{
+	{0, 0, 1, 0, 6288774}, {2, 0, -1, 0, 1274027}, {2, 0, 0, 0, 658314},
+	{0, 0, 2, 0, 213618}, {0, 1, 0, 0, -185116}, {0, 0, 0, 2, -114332},
+	{2, 0, -2, 0, 58793}, {2, -1, -1, 0, 57066}, {2, 0, 1, 0, 53322},
+	{2, -1, 0, 0, 45758}, {0, 1, -1, 0, -40923}, {1, 0, 0, 0, -34720},
+	{0, 1, 1, 0, -30383}, {2, 0, 0, -2, 15327}, {0, 0, 1, 2, -12528},
+	{0, 0, 1, -2, 10980}, {4, 0, -1, 0, 10675}, {0, 0, 3, 0, 10034},
+	{4, 0, -2, 0, 8548}, {2, 1, -1, 0, -7888}, {2, 1, 0, 0, -6766},
+	{1, 0, -1, 0, -5163}, {1, 1, 0, 0, 4987}, {2, -1, 1, 0, 4036},
+	{2, 0, 2, 0, 3994}, {4, 0, 0, 0, 3861}, {2, 0, -3, 0, 3665},
+	{0, 1, -2, 0, -2689}, {2, 0, -1, 2, -2602}, {2, -1, -2, 0, 2390},
+	{1, 0, 1, 0, -2348}, {2, -2, 0, 0, 2236},
+}
+
+// moonDistTerms are the largest terms of Meeus table 47.A for distance, in
+// metres.
+var moonDistTerms = []lunarTerm{
+	{0, 0, 1, 0, -20905355}, {2, 0, -1, 0, -3699111}, {2, 0, 0, 0, -2955968},
+	{0, 0, 2, 0, -569925}, {0, 1, 0, 0, 48888}, {2, 0, -2, 0, 246158},
+	{2, -1, -1, 0, -152138}, {2, 0, 1, 0, -170733}, {2, -1, 0, 0, -204586},
+	{0, 1, -1, 0, -129620}, {1, 0, 0, 0, 108743}, {0, 1, 1, 0, 104755},
+	{2, 0, 0, -2, 10321}, {0, 0, 1, -2, 79661}, {4, 0, -1, 0, -34782},
+	{0, 0, 3, 0, -23210}, {4, 0, -2, 0, -21636}, {2, 1, -1, 0, 24208},
+	{2, 1, 0, 0, 30824}, {1, 0, -1, 0, -8379}, {1, 1, 0, 0, -16675},
+	{2, -1, 1, 0, -12831}, {2, 0, 2, 0, -10445}, {4, 0, 0, 0, -11650},
+	{2, 0, -3, 0, 14403}, {0, 1, -2, 0, -7003}, {2, -1, -2, 0, 10056},
+	{1, 0, 1, 0, 6322}, {2, -2, 0, 0, -9884},
+}
+
+// moonLatTerms are the largest terms of Meeus table 47.B, in millionths of
+// a degree.
+var moonLatTerms = []lunarTerm{
+	{0, 0, 0, 1, 5128122}, {0, 0, 1, 1, 280602}, {0, 0, 1, -1, 277693},
+	{2, 0, 0, -1, 173237}, {2, 0, -1, 1, 55413}, {2, 0, -1, -1, 46271},
+	{2, 0, 0, 1, 32573}, {0, 0, 2, 1, 17198}, {2, 0, 1, -1, 9266},
+	{0, 0, 2, -1, 8822}, {2, -1, 0, -1, 8216}, {2, 0, -2, -1, 4324},
+	{2, 0, 1, 1, 4200}, {2, 1, 0, -1, -3359}, {2, -1, -1, 1, 2463},
+	{2, -1, 0, 1, 2211}, {2, -1, -1, -1, 2065}, {0, 1, -1, -1, -1870},
+	{4, 0, -1, -1, 1828}, {0, 1, 0, 1, -1794},
+}
+
+// moonPosition returns the Moon's geocentric ecliptic longitude and
+// latitude in degrees and its distance in kilometres at t.
+func moonPosition(t time.Time) (lon, lat, dist float64) {
+	T := julianCenturies(t)
+	T2, T3, T4 := T*T, T*T*T, T*T*T*T
+	Lp := 218.3164477 + 481267.88123421*T - 0.0015786*T2 + T3/538841 - T4/65194000
+	D := 297.8501921 + 445267.1114034*T - 0.0018819*T2 + T3/545868 - T4/113065000
+	M := 357.5291092 + 35999.0502909*T - 0.0001536*T2 + T3/24490000
+	Mp := 134.9633964 + 477198.8675055*T + 0.0087414*T2 + T3/69699 - T4/14712000
+	F := 93.2720950 + 483202.0175233*T - 0.0036539*T2 - T3/3526000 + T4/863310000
+	E := 1 - 0.002516*T - 0.0000074*T2
+
+	// sum adds up a series, scaling terms in M by the eccentricity factor.
+	sum := func(terms []lunarTerm, f func(float64) float64) float64 {
+		var s float64
+		for _, k := range terms {
+			v := k.coef * f((k.d*D+k.m*M+k.mp*Mp+k.f*F)*deg)
+			switch math.Abs(k.m) {
+			case 1:
+				v *= E
+			case 2:
+				v *= E * E
+			}
+			s += v
+		}
+		return s
+	}
+
+	A1 := 119.75 + 131.849*T
+	A2 := 53.09 + 479264.290*T
+	A3 := 313.45 + 481266.484*T
+	sl := sum(moonLonTerms, math.Sin) +
+		3958*math.Sin(A1*deg) + 1962*math.Sin((Lp-F)*deg) + 318*math.Sin(A2*deg)
+	sb := sum(moonLatTerms, math.Sin) -
+		2235*math.Sin(Lp*deg) + 382*math.Sin(A3*deg) +
+		175*math.Sin((A1-F)*deg) + 175*math.Sin((A1+F)*deg) +
+		127*math.Sin((Lp-Mp)*deg) - 115*math.Sin((Lp+Mp)*deg)
+	sr := sum(moonDistTerms, math.Cos)
+
+	return norm360(Lp + sl/1e6), sb / 1e6, 385000.56 + sr/1000
+}
+
+// moonElongation returns how far the Moon is east of the Sun in ecliptic
+// longitude at t, in degrees from 0 (new) through 180 (full) to 360.
+func moonElongation(t time.Time) float64 {
+	sun, _ := sunPosition(t)
+	moon, _, _ := moonPosition(t)
+	return norm360(moon - sun)
+}
+
+// Principal phases, in the order the elongation passes them.
+var phaseNames = [4]string{"New moon", "First quarter", "Full moon", "Last quarter"}
+
+// phaseEvent is the instant of a principal phase.
+type phaseEvent struct {
+	Name string
+	Time time.Time
+}
+
+// moonPhases returns the principal phases in [from, to), in order. The
+// elongation grows about 12 degrees a day, so sampling every six hours
+// sees each quarter once; each crossing is then bisected to the second.
+func moonPhases(from, to time.Time) []phaseEvent {
+	const step = 6 * time.Hour
+	quarter := func(t time.Time) int { return int(moonElongation(t) / 90) }
+	var out []phaseEvent
+	t0, q0 := from, quarter(from)
+	for t0.Before(to) {
+		t1 := t0.Add(step)
+		q1 := quarter(t1)
+		if q1 != q0 {
+			lo, hi := t0, t1
+			for hi.Sub(lo) > time.Second {
+				mid := lo.Add(hi.Sub(lo) / 2)
+				if quarter(mid) == q0 {
+					lo = mid
+				} else {
+					hi = mid
+				}
+			}
+			if hi.Before(to) && !hi.Before(from) {
+				out = append(out, phaseEvent{Name: phaseNames[q1], Time: hi.Truncate(time.Second)})
+			}
+		}
+		t0, q0 = t1, q1
+	}
+	return out
+}
+
+// moonIllumination returns the illuminated fraction of the Moon's disc at
+// t, from 0 (new) to 1 (full), after Meeus chapter 48.
+func moonIllumination(t time.Time) float64 {
+	sunLon, R := sunPosition(t)
+	moonLon, moonLat, dist := moonPosition(t)
+	psi := math.Acos(math.Cos(moonLat*deg) * math.Cos((moonLon-sunLon)*deg))
+	i := math.Atan2(R*math.Sin(psi), dist-R*math.Cos(psi))
+	return (1 + math.Cos(i)) / 2
+}
+
+// Phases between the principal ones, by quarter of elongation.
+var intermediatePhases = [4]string{"Waxing crescent", "Waxing gibbous", "Waning gibbous", "Waning crescent"}
+
+// dayPhase names the Moon's phase for the day [start, end): the principal
+// phase that falls in it, if any, and otherwise the phase between them.
+func dayPhase(start, end time.Time) string {
+	if ps := moonPhases(start, end); len(ps) > 0 {
+		return ps[0].Name
+	}
+	mid := start.Add(end.Sub(start) / 2)
+	return intermediatePhases[int(moonElongation(mid)/90)]
+}
+
+// equatorial converts ecliptic longitude and latitude at t to right
+// ascension and declination, all in degrees.
+func equatorial(t time.Time, lon, lat float64) (ra, dec float64) {
+	eps := (23.4392911 - 0.0130042*julianCenturies(t)) * deg
+	l, b := lon*deg, lat*deg
+	ra = math.Atan2(math.Sin(l)*math.Cos(eps)-math.Tan(b)*math.Sin(eps), math.Cos(l))
+	dec = math.Asin(math.Sin(b)*math.Cos(eps) + math.Cos(b)*math.Sin(eps)*math.Sin(l))
+	return norm360(ra / deg), dec / deg
+}
+
+// moonBrightLimb returns the position angle of the midpoint of the Moon's
+// bright limb at t: the direction of the Sun from the Moon, in degrees from
+// celestial north through east (Meeus 48.5).
+func moonBrightLimb(t time.Time) float64 {
+	sunLon, _ := sunPosition(t)
+	moonLon, moonLat, _ := moonPosition(t)
+	a0, d0 := equatorial(t, sunLon, 0)
+	a, d := equatorial(t, moonLon, moonLat)
+	a0, d0, a, d = a0*deg, d0*deg, a*deg, d*deg
+	chi := math.Atan2(math.Cos(d0)*math.Sin(a0-a), math.Sin(d0)*math.Cos(d)-math.Cos(d0)*math.Sin(d)*math.Cos(a0-a))
+	return norm360(chi / deg)
+}
+
+// siderealTime returns the Greenwich mean sidereal time at t in degrees
+// (Meeus 12.4).
+func siderealTime(t time.Time) float64 {
+	T := julianCenturies(t)
+	return norm360(280.46061837 + 360.98564736629*(julianDay(t)-2451545) + 0.000387933*T*T - T*T*T/38710000)
+}
+
+// horizontal returns the altitude and azimuth, from north through east,
+// of right ascension ra and declination dec seen from lat, lon at t, all
+// in degrees.
+func horizontal(t time.Time, lat, lon, ra, dec float64) (alt, az float64) {
+	H := (siderealTime(t) + lon - ra) * deg
+	phi, d := lat*deg, dec*deg
+	alt = math.Asin(math.Sin(phi)*math.Sin(d) + math.Cos(phi)*math.Cos(d)*math.Cos(H))
+	az = math.Atan2(math.Sin(H), math.Cos(H)*math.Sin(phi)-math.Tan(d)*math.Cos(phi))
+	return alt / deg, norm360(az/deg + 180)
+}
+
+// sunAltAz returns the Sun's altitude and azimuth in degrees from lat, lon
+// at t, without refraction.
+func sunAltAz(t time.Time, lat, lon float64) (alt, az float64) {
+	sunLon, _ := sunPosition(t)
+	ra, dec := equatorial(t, sunLon, 0)
+	return horizontal(t, lat, lon, ra, dec)
+}
+
+// moonAltAz returns the Moon's altitude and azimuth in degrees from lat,
+// lon at t, without refraction. The altitude is topocentric: the Moon is
+// close enough that it is up to a degree lower than seen from the Earth's
+// centre.
+func moonAltAz(t time.Time, lat, lon float64) (alt, az float64) {
+	moonLon, moonLat, dist := moonPosition(t)
+	ra, dec := equatorial(t, moonLon, moonLat)
+	alt, az = horizontal(t, lat, lon, ra, dec)
+	parallax := math.Asin(6378.14/dist) / deg
+	return alt - parallax*math.Cos(alt*deg), az
+}
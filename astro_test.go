@@ -0,0 +1,81 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// Test the lunar position against Meeus example 47.a
+func TestMoonPosition(t *testing.T) {
+	lon, lat, dist := moonPosition(time.Date(1992, 4, 12, 0, 0, 0, 0, time.UTC))
+	if math.Abs(lon-133.162655) > 0.01 || math.Abs(lat+3.229126) > 0.01 || math.Abs(dist-368409.7) > 20 {
+		t.Errorf("moonPosition = %.6f, %.6f, %.1f; want 133.162655, -3.229126, 368409.7", lon, lat, dist)
+	}
+}
+
+// Test phase times are within a few minutes of the published ones
+func TestMoonPhases(t *testing.T) {
+	got := moonPhases(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC))
+	want := []phaseEvent{
+		{"Last quarter", time.Date(2024, 1, 4, 3, 30, 0, 0, time.UTC)},
+		{"New moon", time.Date(2024, 1, 11, 11, 57, 0, 0, time.UTC)},
+		{"First quarter", time.Date(2024, 1, 18, 3, 52, 0, 0, time.UTC)},
+		{"Full moon", time.Date(2024, 1, 25, 17, 54, 0, 0, time.UTC)},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("moonPhases = %v, want %d phases", got, len(want))
+	}
+	for i, w := range want {
+		if got[i].Name != w.Name || got[i].Time.Sub(w.Time).Abs() > 5*time.Minute {
+			t.Errorf("phase %d = %s %v, want %s %v", i, got[i].Name, got[i].Time, w.Name, w.Time)
+		}
+	}
+
+	// The eclipse new moon of 8 April 2024.
+	eclipse := moonPhases(time.Date(2024, 4, 8, 0, 0, 0, 0, time.UTC), time.Date(2024, 4, 9, 0, 0, 0, 0, time.UTC))
+	if len(eclipse) != 1 || eclipse[0].Name != "New moon" || eclipse[0].Time.Sub(time.Date(2024, 4, 8, 18, 21, 0, 0, time.UTC)).Abs() > 5*time.Minute {
+		t.Errorf("8 April 2024 = %v, want new moon at 18:21", eclipse)
+	}
+}
+
+// Test the illuminated fraction against Meeus example 48.a and the day's phase names
+func TestMoonIllumination(t *testing.T) {
+	if k := moonIllumination(time.Date(1992, 4, 12, 0, 0, 0, 0, time.UTC)); math.Abs(k-0.6786) > 0.002 {
+		t.Errorf("moonIllumination = %.4f, want 0.6786", k)
+	}
+
+	day := func(y int, m time.Month, d int) string {
+		start := time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+		return dayPhase(start, start.AddDate(0, 0, 1))
+	}
+	cases := []struct {
+		got, want string
+	}{
+		{day(2024, 1, 11), "New moon"},
+		{day(2024, 1, 14), "Waxing crescent"},
+		{day(2024, 1, 21), "Waxing gibbous"},
+		{day(2024, 1, 25), "Full moon"},
+		{day(2024, 1, 28), "Waning gibbous"},
+		{day(2024, 2, 6), "Waning crescent"},
+	}
+	for _, c := range cases {
+		if c.got != c.want {
+			t.Errorf("dayPhase = %q, want %q", c.got, c.want)
+		}
+	}
+}
+
+// Test sidereal time and horizontal coordinates against Meeus examples 12.a and 13.b
+func TestHorizontal(t *testing.T) {
+	if st := siderealTime(time.Date(1987, 4, 10, 0, 0, 0, 0, time.UTC)); math.Abs(st-197.693195) > 1e-4 {
+		t.Errorf("siderealTime = %.6f, want 197.693195", st)
+	}
+	// Venus from the US Naval Observatory, 1987 April 10 19:21 UT.
+	ra := (23 + 9.0/60 + 16.641/3600) * 15
+	dec := -(6 + 43.0/60 + 11.61/3600)
+	alt, az := horizontal(time.Date(1987, 4, 10, 19, 21, 0, 0, time.UTC), 38+55.0/60+17.0/3600, -(77 + 3.0/60 + 56.0/3600), ra, dec)
+	if math.Abs(alt-15.1249) > 0.01 || math.Abs(az-(68.0337+180)) > 0.01 {
+		t.Errorf("horizontal = %.4f, %.4f, want 15.1249, 248.0337", alt, az)
+	}
+}
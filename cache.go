@@ -0,0 +1,153 @@
+package main
+
+import (
+	"container/list"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/exploded/riseset"
+)
+
+// lruCache is a bounded, concurrency-safe least-recently-used cache. Its
+// get method also de-duplicates concurrent misses: while one caller computes
+// a value, others asking for the same key wait for that result instead of
+// computing it again.
+type lruCache[K comparable, V any] struct {
+	mu       sync.Mutex
+	size     int
+	order    *list.List // front is most recently used; values are *lruEntry
+	items    map[K]*list.Element
+	inflight map[K]*lruCall[V]
+
+	hits, misses atomic.Uint64
+}
+
+type lruEntry[K comparable, V any] struct {
+	key K
+	val V
+}
+
+// lruCall is a computation in progress; done is closed once val and err
+// are set.
+type lruCall[V any] struct {
+	done chan struct{}
+	val  V
+	err  error
+}
+
+// newLRUCache returns a cache holding at most size entries. A size of zero
+// or less disables caching, though concurrent misses are still shared.
+func newLRUCache[K comparable, V any](size int) *lruCache[K, V] {
+	return &lruCache[K, V]{
+		size:     size,
+		order:    list.New(),
+		items:    map[K]*list.Element{},
+		inflight: map[K]*lruCall[V]{},
+	}
+}
+
+// Cache lookup outcomes, as reported by get.
+const (
+	cacheHit    = "hit"
+	cacheMiss   = "miss"
+	cacheShared = "shared" // waited for another caller's computation
+)
+
+// get returns the value for key, calling compute on a miss. It also
+// reports how the value was obtained. compute runs without the lock held.
+func (c *lruCache[K, V]) get(key K, compute func() V) (V, string) {
+	v, outcome, _ := c.getErr(key, func() (V, error) { return compute(), nil })
+	return v, outcome
+}
+
+// getErr is get for computations that can fail. A failed value is not
+// cached; callers waiting on the same computation share its error.
+func (c *lruCache[K, V]) getErr(key K, compute func() (V, error)) (V, string, error) {
+	c.mu.Lock()
+	if el, ok := c.items[key]; ok {
+		c.order.MoveToFront(el)
+		c.mu.Unlock()
+		c.hits.Add(1)
+		return el.Value.(*lruEntry[K, V]).val, cacheHit, nil
+	}
+	if call, ok := c.inflight[key]; ok {
+		c.mu.Unlock()
+		<-call.done
+		c.hits.Add(1)
+		return call.val, cacheShared, call.err
+	}
+	call := &lruCall[V]{done: make(chan struct{})}
+	c.inflight[key] = call
+	c.mu.Unlock()
+	c.misses.Add(1)
+
+	call.val, call.err = compute()
+
+	c.mu.Lock()
+	delete(c.inflight, key)
+	if c.size > 0 && call.err == nil {
+		c.items[key] = c.order.PushFront(&lruEntry[K, V]{key, call.val})
+		if c.order.Len() > c.size {
+			oldest := c.order.Back()
+			c.order.Remove(oldest)
+			delete(c.items, oldest.Value.(*lruEntry[K, V]).key)
+		}
+	}
+	c.mu.Unlock()
+	close(call.done)
+	return call.val, cacheMiss, call.err
+}
+
+// len returns the number of cached entries.
+func (c *lruCache[K, V]) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+// risetKey identifies one computed rise/set day. Coordinates are rounded
+// to the cache precision before both lookup and computation, so the cached
+// value doesn't depend on which nearby request happened to come first.
+type risetKey struct {
+	object           riseset.Object
+	year, month, day int
+	lon, lat, zon    float64
+}
+
+// risetDays caches computed rise/set days. It is replaced in main once the
+// configuration is loaded; the default lets handlers work in tests.
+var risetDays = newRisetCache(defaultConfig())
+
+// risetCache is an lruCache of rise/set days with the configured rounding.
+type risetCache struct {
+	*lruCache[risetKey, riseset.RiseSet]
+	scale float64 // 10^precision
+}
+
+func newRisetCache(c config) *risetCache {
+	return &risetCache{
+		lruCache: newLRUCache[risetKey, riseset.RiseSet](c.RisetCacheSize),
+		scale:    math.Pow10(c.RisetCachePrecision),
+	}
+}
+
+// key rounds the coordinates and drops the time of day, which riseset
+// ignores.
+func (rc *risetCache) key(object riseset.Object, date time.Time, lon, lat, zon float64) risetKey {
+	y, m, d := date.Date()
+	return risetKey{
+		object: object,
+		year:   y, month: int(m), day: d,
+		lon: math.Round(lon*rc.scale) / rc.scale,
+		lat: math.Round(lat*rc.scale) / rc.scale,
+		zon: zon,
+	}
+}
+
+// compute runs the calculation for a key.
+func (k risetKey) compute() riseset.RiseSet {
+	date := time.Date(k.year, time.Month(k.month), k.day, 0, 0, 0, 0, time.UTC)
+	return riseset.Riseset(k.object, date, k.lon, k.lat, k.zon)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/exploded/riseset"
+)
+
+// Test the least recently used entry is evicted and counters are kept
+func TestLRUCacheEviction(t *testing.T) {
+	c := newLRUCache[string, int](2)
+	calls := 0
+	get := func(k string) (int, string) {
+		return c.get(k, func() int { calls++; return len(k) })
+	}
+	get("a")
+	get("bb")
+	if _, outcome := get("a"); outcome != cacheHit {
+		t.Errorf("a: got %s, want hit", outcome)
+	}
+	get("ccc") // evicts bb, the least recently used
+	if _, outcome := get("bb"); outcome != cacheMiss {
+		t.Errorf("bb: got %s, want miss after eviction", outcome)
+	}
+	if c.len() != 2 {
+		t.Errorf("len = %d, want 2", c.len())
+	}
+	if calls != 4 || c.misses.Load() != 4 || c.hits.Load() != 1 {
+		t.Errorf("calls=%d hits=%d misses=%d", calls, c.hits.Load(), c.misses.Load())
+	}
+}
+
+// Test concurrent misses for one key compute once
+func TestLRUCacheSingleflight(t *testing.T) {
+	c := newLRUCache[int, int](1)
+	release := make(chan struct{})
+	var computed int
+	var wg sync.WaitGroup
+	results := make([]int, 10)
+	for i := range results {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], _ = c.get(1, func() int {
+				<-release
+				computed++
+				return 42
+			})
+		}()
+	}
+	// Wait until every goroutine is either computing or waiting on it.
+	for c.misses.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if computed != 1 {
+		t.Errorf("computed %d times, want 1", computed)
+	}
+	for i, v := range results {
+		if v != 42 {
+			t.Errorf("results[%d] = %d", i, v)
+		}
+	}
+
+	off := newLRUCache[int, int](0)
+	off.get(1, func() int { return 1 })
+	if _, outcome := off.get(1, func() int { return 1 }); outcome != cacheMiss || off.len() != 0 {
+		t.Error("size 0 cache should not keep entries")
+	}
+}
+
+// Test failed computations are returned but not kept
+func TestLRUCacheErrors(t *testing.T) {
+	c := newLRUCache[string, int](2)
+	fail := errors.New("failed")
+	if _, _, err := c.getErr("a", func() (int, error) { return 0, fail }); err != fail {
+		t.Errorf("err = %v, want %v", err, fail)
+	}
+	if c.len() != 0 {
+		t.Error("a failed value was cached")
+	}
+	if v, outcome, err := c.getErr("a", func() (int, error) { return 1, nil }); v != 1 || outcome != cacheMiss || err != nil {
+		t.Errorf("after a failure got %d, %s, %v", v, outcome, err)
+	}
+	if _, outcome, _ := c.getErr("a", func() (int, error) { return 2, fail }); outcome != cacheHit {
+		t.Errorf("got %s, want hit", outcome)
+	}
+}
+
+// Test nearby coordinates and times of day share a cache key
+func TestRisetCacheKey(t *testing.T) {
+	rc := newRisetCache(defaultConfig())
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	a := rc.key(riseset.Moon, day, 144.9631, -37.8136, 10)
+	b := rc.key(riseset.Moon, day.Add(15*time.Hour), 144.9649, -37.8149, 10)
+	if a != b {
+		t.Errorf("keys differ: %+v vs %+v", a, b)
+	}
+	if a.lon != 144.96 || a.lat != -37.81 {
+		t.Errorf("rounded to %v, %v", a.lon, a.lat)
+	}
+	if a == rc.key(riseset.Sun, day, 144.9631, -37.8136, 10) {
+		t.Error("bodies should not share a key")
+	}
+	if got, want := a.compute(), riseset.Riseset(riseset.Moon, day, 144.96, -37.81, 10); got != want {
+		t.Errorf("compute = %+v, want %+v", got, want)
+	}
+}
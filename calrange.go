@@ -0,0 +1,168 @@
+package main
+
+import (
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Besides whole months, the calendar shows a week (Monday to Sunday) with
+//
+//	/calendar?view=week&date=2026-10-18
+//
+// or any span of days, which may cross months, with
+//
+//	/calendar?from=2026-10-28&to=2026-11-03
+//
+// Both take the usual location parameters, and their navigation moves by
+// the same number of days.
+
+// Calendar views other than a month.
+const (
+	viewWeek  = "week"
+	viewRange = "range"
+)
+
+// maxRangeDays limits the days in a from/to range.
+const maxRangeDays = 62
+
+// setDays reads the days to show from q: year and month, defaulting to
+// the current month in the view's zone, or a week or range instead.
+func (v *calendarView) setDays(q url.Values) {
+	now := v.localNow()
+	var err error
+	v.Year, err = strconv.Atoi(q.Get("year"))
+	if err != nil || v.Year < 1 || v.Year > 9999 {
+		v.Year = now.Year()
+	}
+	v.Month, err = strconv.Atoi(q.Get("month"))
+	if err != nil || v.Month < 1 || v.Month > 12 {
+		v.Month = int(now.Month())
+	}
+	v.setSpan(q, time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC))
+}
+
+// setSpan reads a week or range from q, if it asks for one; otherwise the
+// view stays a month. A week without a date is the one containing today.
+// The view's Year and Month become those of its first day.
+func (v *calendarView) setSpan(q url.Values, today time.Time) {
+	if q.Get("view") == viewWeek {
+		date, err := time.Parse(time.DateOnly, q.Get("date"))
+		if err != nil {
+			date = today
+		}
+		// Weeks start on Monday.
+		v.View = viewWeek
+		v.From = date.AddDate(0, 0, -(int(date.Weekday())+6)%7)
+		v.To = v.From.AddDate(0, 0, 6)
+	} else {
+		from, err1 := time.Parse(time.DateOnly, q.Get("from"))
+		to, err2 := time.Parse(time.DateOnly, q.Get("to"))
+		if err1 != nil || err2 != nil {
+			return
+		}
+		if to.Before(from) {
+			from, to = to, from
+		}
+		if last := from.AddDate(0, 0, maxRangeDays-1); to.After(last) {
+			to = last
+		}
+		v.View, v.From, v.To = viewRange, from, to
+	}
+	v.Year, v.Month = v.From.Year(), int(v.From.Month())
+}
+
+// span returns the first and last local dates the view shows, at midnight
+// UTC.
+func (v calendarView) span() (from, to time.Time) {
+	if v.View != "" {
+		return v.From, v.To
+	}
+	first := time.Date(v.Year, time.Month(v.Month), 1, 0, 0, 0, 0, time.UTC)
+	return first, first.AddDate(0, 1, -1)
+}
+
+// weekURL links to the calendar for the week containing date.
+func (v calendarView) weekURL(date time.Time) string {
+	q := v.query()
+	q.Set("view", viewWeek)
+	q.Set("date", date.Format(time.DateOnly))
+	return "/calendar?" + q.Encode()
+}
+
+// spanParams adds the parameters selecting the view's month, week or range
+// to q.
+func (v calendarView) spanParams(q url.Values) {
+	switch v.View {
+	case viewWeek:
+		q.Set("view", viewWeek)
+		q.Set("date", v.From.Format(time.DateOnly))
+	case viewRange:
+		q.Set("from", v.From.Format(time.DateOnly))
+		q.Set("to", v.To.Format(time.DateOnly))
+	default:
+		q.Set("year", strconv.Itoa(v.Year))
+		q.Set("month", strconv.Itoa(v.Month))
+	}
+}
+
+// spanURL links to the view's own month, week or range.
+func (v calendarView) spanURL() string {
+	if v.View == "" {
+		return v.monthURL(v.Year, v.Month)
+	}
+	q := v.query()
+	v.spanParams(q)
+	return "/calendar?" + q.Encode()
+}
+
+// shifted returns the view moved n of its own lengths later: by months for
+// a month, otherwise by its number of days.
+func (v calendarView) shifted(n int) calendarView {
+	if v.View == "" {
+		// time.Date normalizes the month, handling year rollovers.
+		first := time.Date(v.Year, time.Month(v.Month+n), 1, 0, 0, 0, 0, time.UTC)
+		v.Year, v.Month = first.Year(), int(first.Month())
+		return v
+	}
+	days := n * (int(v.To.Sub(v.From)/(24*time.Hour)) + 1)
+	v.From, v.To = v.From.AddDate(0, 0, days), v.To.AddDate(0, 0, days)
+	v.Year, v.Month = v.From.Year(), int(v.From.Month())
+	return v
+}
+
+// prevNextURLs link to the views before and after this one, of the same
+// kind and length.
+func (v calendarView) prevNextURLs() (prev, next string) {
+	return v.shifted(-1).spanURL(), v.shifted(1).spanURL()
+}
+
+// spanCacheControl returns the Cache-Control for a page about the local
+// dates from to to, and whether it includes today, given the local time
+// now. Past days never change, but the page's asset URLs do, so shared
+// caches keep them for maxDynamicAge rather than indefinitely (see the
+// README's Computed Pages); a page including today changes at
+// local midnight (its highlighted row) and a future one when it begins.
+func spanCacheControl(now, from, to time.Time) (cacheControl string, today bool) {
+	switch {
+	case !now.Before(to.AddDate(0, 0, 1)):
+		return cacheFor(maxDynamicAge), false
+	case !now.Before(from):
+		return cacheFor(untilMidnight(now)), true
+	}
+	return cacheFor(from.Sub(now)), false
+}
+
+// spanLabel names the days from and to as briefly as possible, e.g.
+// "12–18 October 2026" or "28 September – 4 October 2026".
+func spanLabel(from, to time.Time) string {
+	switch {
+	case from.Equal(to):
+		return from.Format("2 January 2006")
+	case from.Year() != to.Year():
+		return from.Format("2 January 2006") + " – " + to.Format("2 January 2006")
+	case from.Month() != to.Month():
+		return from.Format("2 January") + " – " + to.Format("2 January 2006")
+	}
+	return from.Format("2") + "–" + to.Format("2 January 2006")
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test week and range views resolve their days
+func TestCalendarSpan(t *testing.T) {
+	day := func(s string) time.Time {
+		d, _ := time.Parse(time.DateOnly, s)
+		return d
+	}
+	cases := []struct {
+		query, view, from, to string
+	}{
+		{"view=week&date=2026-10-01", viewWeek, "2026-09-28", "2026-10-04"},
+		{"view=week&date=2026-10-04", viewWeek, "2026-09-28", "2026-10-04"},
+		{"view=week&date=2026-10-05", viewWeek, "2026-10-05", "2026-10-11"},
+		{"from=2026-12-28&to=2027-01-03", viewRange, "2026-12-28", "2027-01-03"},
+		{"from=2027-01-03&to=2026-12-28", viewRange, "2026-12-28", "2027-01-03"},
+		{"from=2026-01-01&to=2026-12-31", viewRange, "2026-01-01", "2026-03-03"},
+		{"from=2026-01-01&year=2026&month=5", "", "2026-05-01", "2026-05-31"},
+		{"year=2026&month=2", "", "2026-02-01", "2026-02-28"},
+	}
+	for _, c := range cases {
+		v := calendarFromQuery(httptest.NewRequest("GET", "/calendar?lat=-37.81&lon=144.96&tz=Australia/Melbourne&"+c.query, nil))
+		from, to := v.span()
+		if v.View != c.view || !from.Equal(day(c.from)) || !to.Equal(day(c.to)) {
+			t.Errorf("%s: view %q %s to %s, want %q %s to %s", c.query, v.View, from.Format(time.DateOnly), to.Format(time.DateOnly), c.view, c.from, c.to)
+		}
+	}
+
+	for _, c := range []struct{ from, to, want string }{
+		{"2026-10-12", "2026-10-18", "12–18 October 2026"},
+		{"2026-09-28", "2026-10-04", "28 September – 4 October 2026"},
+		{"2026-12-28", "2027-01-03", "28 December 2026 – 3 January 2027"},
+		{"2026-10-12", "2026-10-12", "12 October 2026"},
+	} {
+		if got := spanLabel(day(c.from), day(c.to)); got != c.want {
+			t.Errorf("spanLabel(%s, %s) = %q, want %q", c.from, c.to, got, c.want)
+		}
+	}
+}
+
+// Test week and range pages show their days and keep the view when moving
+func TestCalendarRangePages(t *testing.T) {
+	get := func(url string) string {
+		rr := httptest.NewRecorder()
+		calendar(rr, httptest.NewRequest("GET", url, nil))
+		return rr.Body.String()
+	}
+	dates := regexp.MustCompile(`>(\d\d-\d\d-\d{4})</a></td>`)
+
+	body := get("/calendar?lat=-37.81&lon=144.96&tz=Australia/Melbourne&view=week&date=2026-10-01")
+	if got := dates.FindAllStringSubmatch(body, -1); len(got) != 7 || got[0][1] != "28-09-2026" || got[6][1] != "04-10-2026" {
+		t.Errorf("week rows = %q", got)
+	}
+	for _, want := range []string{
+		"<span>28 September – 4 October 2026</span>",
+		`href="/calendar?date=2026-09-21&amp;lat=-37.81&amp;lon=144.96&amp;tz=Australia%2FMelbourne&amp;view=week" rel="prev"`,
+		`href="/calendar?date=2026-10-05&amp;lat=-37.81&amp;lon=144.96&amp;tz=Australia%2FMelbourne&amp;view=week" rel="next"`,
+		`view=week" class="current">Week</a>`,
+		`Last quarter 3 Oct.`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("week page missing %q", want)
+		}
+	}
+
+	body = get("/calendar?lat=-37.81&lon=144.96&tz=Australia/Melbourne&from=2026-12-28&to=2027-01-03")
+	if got := dates.FindAllStringSubmatch(body, -1); len(got) != 7 || got[0][1] != "28-12-2026" || got[6][1] != "03-01-2027" {
+		t.Errorf("range rows = %q", got)
+	}
+	for _, want := range []string{
+		"<span>28 December 2026 – 3 January 2027</span>",
+		`href="/calendar?from=2026-12-21&amp;lat=-37.81&amp;lon=144.96&amp;to=2026-12-27&amp;tz=Australia%2FMelbourne" rel="prev"`,
+		`href="/calendar?from=2027-01-04&amp;lat=-37.81&amp;lon=144.96&amp;to=2027-01-10&amp;tz=Australia%2FMelbourne" rel="next"`,
+		`<link rel="canonical" href="/calendar?from=2026-12-28&amp;lat=-37.81&amp;lon=144.96&amp;to=2027-01-03&amp;tz=Australia%2FMelbourne">`,
+		`<input type="hidden" name="tz" value="Australia/Melbourne">`,
+		`<input type="date" name="from" value="2026-12-28" required>`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("range page missing %q", want)
+		}
+	}
+
+	// The week link from a permalink keeps the place.
+	v := calendarFromQuery(httptest.NewRequest("GET", "/calendar?place=Melbourne,+Australia&view=week", nil))
+	if v.Name == "" || v.Lat != -37.814 {
+		t.Errorf("place from a permalink page = %+v", v)
+	}
+}
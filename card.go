@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/png"
+	"log/slog"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/exploded/riseset"
+)
+
+// Social cards are the Open Graph preview images for calendar and index
+// links: a PNG showing the location, a date, that day's moonrise and
+// moonset and the Moon's phase. They are served at
+//
+//	/og/c/melbourne-au/2026-10-18.png
+//	/og/l/r1r0fsn/2026-10-18.png
+//
+// for the same locations as the calendar permalinks. A card depends only
+// on its path and the configured site-url, so it is cached by its path.
+
+// Card size, as recommended for Open Graph and Twitter large images.
+const cardWidth, cardHeight = 1200, 630
+
+// cardCacheSize is the number of rendered cards kept in memory; each is
+// about 60 KB.
+const cardCacheSize = 128
+
+var cardCache = newLRUCache[string, []byte](cardCacheSize)
+
+// Card colours.
+var (
+	cardSkyTop    = color.RGBA{0x0b, 0x10, 0x26, 0xff}
+	cardSkyBottom = color.RGBA{0x1d, 0x2b, 0x53, 0xff}
+	cardText      = color.RGBA{0xff, 0xff, 0xff, 0xff}
+	cardMuted     = color.RGBA{0x9f, 0xb0, 0xd8, 0xff}
+)
+
+// cardPath returns the path of the view's social card for date.
+func (v calendarView) cardPath(date time.Time) string {
+	return "/og" + v.permalinkWhere() + "/" + date.Format("2006-01-02") + ".png"
+}
+
+// locationCardPath returns the path of the social card for l on date: by
+// slug if l is named after a gazetteer place in the same zone nearby,
+// otherwise by geohash.
+func locationCardPath(l location, date time.Time) string {
+	v := calendarView{Lat: l.Lat, Lon: l.Lon}
+	for _, p := range findPlaces(l.Name) {
+		if p.Zone == l.Zone && math.Abs(p.Lat-l.Lat) < 1 && math.Abs(p.Lon-l.Lon) < 1 {
+			v.Slug = placeSlug(p)
+			break
+		}
+	}
+	return v.cardPath(date)
+}
+
+// handlePlaceCard serves /og/c/{slug}/{date}.
+func handlePlaceCard(w http.ResponseWriter, r *http.Request) {
+	v, ok := placeView(r.PathValue("slug"))
+	serveCard(w, r, v, ok)
+}
+
+// handleGeohashCard serves /og/l/{hash}/{date}.
+func handleGeohashCard(w http.ResponseWriter, r *http.Request) {
+	v, ok := geohashView(r.PathValue("hash"))
+	serveCard(w, r, v, ok)
+}
+
+func serveCard(w http.ResponseWriter, r *http.Request, v calendarView, ok bool) {
+	ds, isPNG := strings.CutSuffix(r.PathValue("date"), ".png")
+	date, err := time.Parse("2006-01-02", ds)
+	if !ok || !isPNG || err != nil {
+		handle404(w, r)
+		return
+	}
+
+	// The card never changes. Its footer names the configured site, if
+	// any, which is the same for every request, so the path alone keys
+	// the cache; the ETag covers a changed site-url across restarts.
+	host := strings.TrimPrefix(strings.TrimPrefix(siteURL(), "https://"), "http://")
+	etag := computedETag("card", r.URL.Path, host)
+	if notModified(w, r, etag, cacheImmutable) {
+		return
+	}
+	body, _, err := cardCache.getErr(r.URL.Path, func() ([]byte, error) {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, drawCard(r.Context(), v, date, host)); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error encoding social card", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Write(body)
+}
+
+// drawCard draws the social card for the view's location on date, a local
+// date at midnight UTC.
+func drawCard(ctx context.Context, v calendarView, date time.Time, host string) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, cardWidth, cardHeight))
+	for y := 0; y < cardHeight; y++ {
+		c := mixRGBA(cardSkyTop, cardSkyBottom, float64(y)/cardHeight)
+		for x := 0; x < cardWidth; x++ {
+			img.SetRGBA(x, y, c)
+		}
+	}
+
+	// The local day in UTC, and the Moon at its noon.
+	zon := v.zonAt(date.Add(12 * time.Hour))
+	start := date.Add(-time.Duration(zon * float64(time.Hour)))
+	noon := start.Add(12 * time.Hour)
+	m := moonAppearanceAt(noon, v.Lat < 0)
+	drawMoonDisc(img, image.Pt(260, cardHeight/2), 180, m)
+
+	moon := computeRiseset(ctx, riseset.Moon, date, v.Lon, v.Lat, zon)
+	name := v.Name
+	if name == "" {
+		name = coordLabel(v.Lat, v.Lon)
+	}
+
+	const x, width = 500, cardWidth - 500 - 50
+	fontSmall().draw(img, x, 120, "MOON RISE AND SET TIMES", cardMuted)
+	fontTitle().draw(img, x, 205, fontTitle().fit(name, width), cardText)
+	fontBody().draw(img, x, 265, date.Format("Monday 2 January 2006"), cardMuted)
+	fontBody().draw(img, x, 360, "Moonrise", cardMuted)
+	fontBody().draw(img, x+200, 360, cardTime(moon, moon.Rise), cardText)
+	fontBody().draw(img, x, 415, "Moonset", cardMuted)
+	fontBody().draw(img, x+200, 415, cardTime(moon, moon.Set), cardText)
+	phase := phaseLabel(start, start.Add(24*time.Hour), m.Lit)
+	fontBody().draw(img, x, 470, fontBody().fit(phase, width), cardText)
+	fontSmall().draw(img, cardWidth-50-fontSmall().width(host), 580, host, cardMuted)
+	return img
+}
+
+// cardTime describes a rise or set time for the card.
+func cardTime(rs riseset.RiseSet, t string) string {
+	switch {
+	case rs.AlwaysAbove:
+		return "Up all day"
+	case rs.AlwaysBelow:
+		return "Down all day"
+	case t == "-" || t == "":
+		return "None today"
+	}
+	return t
+}
+
+// mixRGBA returns the colour t of the way from a to b.
+func mixRGBA(a, b color.RGBA, t float64) color.RGBA {
+	mix := func(x, y uint8) uint8 { return uint8(math.Round(float64(x) + (float64(y)-float64(x))*t)) }
+	return color.RGBA{mix(a.R, b.R), mix(a.G, b.G), mix(a.B, b.B), 0xff}
+}
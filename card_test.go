@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test the embedded fonts load and measure, draw and shorten text
+func TestBitmapFonts(t *testing.T) {
+	for _, f := range []*bitmapFont{fontTitle(), fontBody(), fontSmall()} {
+		if f.ascent <= 0 || len(f.glyphs) < 300 {
+			t.Fatalf("font has ascent %d and %d glyphs", f.ascent, len(f.glyphs))
+		}
+	}
+	f := fontBody()
+	if w, ww := f.width("Moon"), f.width("MoonMoon"); w <= 0 || ww < 2*w-1 || ww > 2*w+1 {
+		t.Errorf("width(Moon) = %d, width(MoonMoon) = %d", w, ww)
+	}
+	// Characters outside the font are drawn as '?'.
+	if f.width("Москва") != f.width("??????") {
+		t.Error("missing glyphs should fall back to '?'")
+	}
+	if got := f.fit("Tromsø", 1000); got != "Tromsø" {
+		t.Errorf("fit shortened text that fits: %q", got)
+	}
+	long := "Llanfairpwllgwyngyll, United Kingdom"
+	if got := f.fit(long, 300); !strings.HasSuffix(got, "…") || f.width(got) > 300 {
+		t.Errorf("fit(%q, 300) = %q, width %d", long, got, f.width(got))
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, 100, 60))
+	f.draw(img, 10, 40, "O", cardText)
+	if img.RGBAAt(0, 0).A != 0 || img.Bounds().Dx() != 100 {
+		t.Error("draw touched pixels outside the glyph")
+	}
+	inked := false
+	for _, v := range img.Pix {
+		inked = inked || v != 0
+	}
+	if !inked {
+		t.Error("draw drew nothing")
+	}
+}
+
+// Test the card handlers serve a cacheable PNG and reject bad paths
+func TestCards(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/og/c/{slug}/{date}", handlePlaceCard)
+	mux.HandleFunc("/og/l/{hash}/{date}", handleGeohashCard)
+	get := func(path, etag string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		return rr
+	}
+
+	for _, path := range []string{"/og/c/melbourne-au/2026-10-18.png", "/og/l/u4pruyd/2026-10-18.png"} {
+		rr := get(path, "")
+		if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "image/png" || rr.Header().Get("Cache-Control") != cacheImmutable {
+			t.Fatalf("%s = %d %v", path, rr.Code, rr.Header())
+		}
+		img, err := png.Decode(bytes.NewReader(rr.Body.Bytes()))
+		if err != nil || img.Bounds() != image.Rect(0, 0, cardWidth, cardHeight) {
+			t.Fatalf("%s: %v, %v", path, err, img.Bounds())
+		}
+		if rr := get(path, rr.Header().Get("ETag")); rr.Code != http.StatusNotModified {
+			t.Errorf("%s with its ETag = %d, want 304", path, rr.Code)
+		}
+	}
+	if cardCache.len() < 2 {
+		t.Errorf("cardCache holds %d cards, want 2", cardCache.len())
+	}
+
+	// The request's host doesn't change the card.
+	req := httptest.NewRequest("GET", "/og/c/melbourne-au/2026-10-18.png", nil)
+	req.Host = "evil.example"
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || rr.Header().Get("ETag") != get("/og/c/melbourne-au/2026-10-18.png", "").Header().Get("ETag") {
+		t.Errorf("card for another host = %d %v", rr.Code, rr.Header())
+	}
+
+	for _, path := range []string{"/og/c/atlantis-xx/2026-10-18.png", "/og/c/melbourne-au/2026-10-18", "/og/c/melbourne-au/2026-13-01.png", "/og/l/r1r0fsa/2026-10-18.png"} {
+		if rr := get(path, ""); rr.Code != http.StatusNotFound {
+			t.Errorf("%s = %d, want 404", path, rr.Code)
+		}
+	}
+}
+
+// Test the index and calendar pages name their cards
+func TestCardMeta(t *testing.T) {
+	if got := locationCardPath(location{Name: "Melbourne", Lat: -37, Lon: 144, Zone: "Australia/Melbourne"}, time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC)); got != "/og/c/melbourne-au/2026-10-18.png" {
+		t.Errorf("default location card = %q", got)
+	}
+	if got := locationCardPath(location{Name: "Home", Lat: -37.8136, Lon: 144.9631, Zone: "Australia/Melbourne"}, time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC)); got != "/og/l/r1r0fsn/2026-10-18.png" {
+		t.Errorf("unnamed location card = %q", got)
+	}
+
+	old := cfg.SiteURL
+	cfg.SiteURL = "https://moon.example.com"
+	defer func() { cfg.SiteURL = old }()
+	rr := httptest.NewRecorder()
+	handleIndex(rr, httptest.NewRequest("GET", "/", nil))
+	if !strings.Contains(rr.Body.String(), `<meta property="og:image" content="https://moon.example.com/og/`) {
+		t.Error("index page has no og:image")
+	}
+	rr = httptest.NewRecorder()
+	calendar(rr, httptest.NewRequest("GET", "/calendar?place=Melbourne&year=2026&month=4", nil))
+	if !strings.Contains(rr.Body.String(), `<meta property="og:image" content="https://moon.example.com/og/c/melbourne-au/2026-04-01.png">`) {
+		t.Error("calendar page should show the first of a past month on its card")
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"math"
+	"time"
+)
+
+// The altitude chart plots the Sun and Moon across a local day, over
+// bands for daylight, the three twilights and night.
+
+// Chart geometry, in SVG user units.
+const (
+	chartWidth, chartHeight = 720, 320
+	chartLeft, chartRight   = 44, 12
+	chartTop, chartBottom   = 24, 28
+	chartMinAlt             = -45.0 // lower altitudes are clipped
+	chartMaxAlt             = 90.0
+)
+
+// chartEvent is a marker on a curve: a rise, set or transit.
+type chartEvent struct {
+	Label string
+	T     time.Time
+	Moon  bool // on the Moon's curve, otherwise the Sun's
+}
+
+// Sun altitudes bounding the twilights, in degrees. Daylight begins when
+// the Sun's upper limb clears the horizon, allowing for refraction.
+var twilights = []struct {
+	alt    float64
+	name   string
+	colour string
+}{
+	{-0.833, "Daylight", "#fff8e1"},
+	{-6, "Civil twilight", "#dbe9f6"},
+	{-12, "Nautical twilight", "#a9c6e8"},
+	{-18, "Astronomical twilight", "#7d9fd3"},
+	{math.Inf(-1), "Night", "#5a7bbf"},
+}
+
+// twilightBand returns the index in twilights of the sky with the Sun at
+// altitude alt.
+func twilightBand(alt float64) int {
+	for i, tw := range twilights {
+		if alt >= tw.alt {
+			return i
+		}
+	}
+	return len(twilights) - 1
+}
+
+// altitudeChart returns an SVG chart of samples, which span one local day,
+// with the given markers.
+func altitudeChart(samples []altSample, events []chartEvent, title string) []byte {
+	start, end := samples[0].T, samples[len(samples)-1].T
+	plotW := float64(chartWidth - chartLeft - chartRight)
+	plotH := float64(chartHeight - chartTop - chartBottom)
+	x := func(t time.Time) float64 {
+		return chartLeft + plotW*float64(t.Sub(start))/float64(end.Sub(start))
+	}
+	y := func(alt float64) float64 {
+		return chartTop + plotH*(chartMaxAlt-alt)/(chartMaxAlt-chartMinAlt)
+	}
+	// altAt interpolates a curve between samples.
+	altAt := func(t time.Time, moon bool) float64 {
+		i := min(int(t.Sub(start)/altitudeStep), len(samples)-2)
+		a, b := samples[i], samples[i+1]
+		f := float64(t.Sub(a.T)) / float64(b.T.Sub(a.T))
+		if moon {
+			return a.Moon + (b.Moon-a.Moon)*f
+		}
+		return a.Sun + (b.Sun-a.Sun)*f
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" width="%d" height="%d" role="img" font-family="sans-serif" font-size="11">`,
+		chartWidth, chartHeight, chartWidth, chartHeight)
+	fmt.Fprintf(&b, "<title>%s</title>", html.EscapeString(title))
+	fmt.Fprintf(&b, `<clipPath id="plot"><rect x="%d" y="%d" width="%g" height="%g"/></clipPath>`, chartLeft, chartTop, plotW, plotH)
+
+	// Sky bands, split where the Sun crosses a twilight boundary between
+	// samples.
+	bandStart, band := start, twilightBand(samples[0].Sun)
+	drawBand := func(from, to time.Time, band int) {
+		fmt.Fprintf(&b, `<rect x="%.1f" y="%d" width="%.1f" height="%g" fill="%s"><title>%s</title></rect>`,
+			x(from), chartTop, x(to)-x(from), plotH, twilights[band].colour, twilights[band].name)
+	}
+	for i := 1; i < len(samples); i++ {
+		next := twilightBand(samples[i].Sun)
+		if next == band {
+			continue
+		}
+		// The boundary crossed is the lower one going up, the upper going
+		// down.
+		edge := twilights[min(band, next)].alt
+		a, c := samples[i-1], samples[i]
+		t := a.T.Add(time.Duration(float64(c.T.Sub(a.T)) * (edge - a.Sun) / (c.Sun - a.Sun)))
+		drawBand(bandStart, t, band)
+		bandStart, band = t, next
+	}
+	drawBand(bandStart, end, band)
+
+	// Grid: altitudes every 30 degrees and hours every 3.
+	for alt := -30.0; alt <= chartMaxAlt; alt += 30 {
+		stroke := `stroke="#ffffff" stroke-opacity="0.5"`
+		if alt == 0 {
+			stroke = `stroke="#333333" stroke-width="1.5"`
+		}
+		fmt.Fprintf(&b, `<line x1="%d" x2="%d" y1="%.1f" y2="%.1f" %s/>`, chartLeft, chartWidth-chartRight, y(alt), y(alt), stroke)
+		fmt.Fprintf(&b, `<text x="%d" y="%.1f" text-anchor="end" fill="#333333">%g°</text>`, chartLeft-6, y(alt)+4, alt)
+	}
+	for h := 0; h <= 24; h += 3 {
+		t := start.Add(time.Duration(h) * time.Hour)
+		fmt.Fprintf(&b, `<line x1="%.1f" x2="%.1f" y1="%d" y2="%g" stroke="#ffffff" stroke-opacity="0.5"/>`, x(t), x(t), chartTop, chartTop+plotH)
+		fmt.Fprintf(&b, `<text x="%.1f" y="%d" text-anchor="middle" fill="#333333">%02d:00</text>`, x(t), chartHeight-10, h)
+	}
+
+	// Curves.
+	curve := func(moon bool, colour string) {
+		b.WriteString(`<polyline clip-path="url(#plot)" fill="none" stroke-width="2.5" stroke-linejoin="round" stroke="` + colour + `" points="`)
+		for i, s := range samples {
+			alt := s.Sun
+			if moon {
+				alt = s.Moon
+			}
+			if i > 0 {
+				b.WriteByte(' ')
+			}
+			fmt.Fprintf(&b, "%.1f,%.1f", x(s.T), y(max(alt, chartMinAlt-1)))
+		}
+		b.WriteString(`"/>`)
+	}
+	curve(false, "#e65100")
+	curve(true, "#0d1452")
+
+	// Markers, labelled above the point, or below if that would leave the
+	// plot.
+	for _, e := range events {
+		colour := "#e65100"
+		if e.Moon {
+			colour = "#0d1452"
+		}
+		px, py := x(e.T), y(max(altAt(e.T, e.Moon), chartMinAlt))
+		fmt.Fprintf(&b, `<circle cx="%.1f" cy="%.1f" r="4" fill="%s" stroke="#ffffff" stroke-width="1.5"/>`, px, py, colour)
+		anchor := "middle"
+		if px < chartLeft+50 {
+			anchor = "start"
+		} else if px > chartWidth-chartRight-50 {
+			anchor = "end"
+		}
+		ly := py - 9
+		if ly < chartTop+12 {
+			ly = py + 18
+		}
+		fmt.Fprintf(&b, `<text x="%.1f" y="%.1f" text-anchor="%s" fill="%s" font-weight="bold">%s</text>`, px, ly, anchor, colour, html.EscapeString(e.Label))
+	}
+
+	// Legend.
+	fmt.Fprintf(&b, `<g font-size="12"><line x1="%d" x2="%d" y1="12" y2="12" stroke="#0d1452" stroke-width="2.5"/><text x="%d" y="16" fill="#333333">Moon</text>`, chartLeft, chartLeft+20, chartLeft+25)
+	fmt.Fprintf(&b, `<line x1="%d" x2="%d" y1="12" y2="12" stroke="#e65100" stroke-width="2.5"/><text x="%d" y="16" fill="#333333">Sun</text></g>`, chartLeft+70, chartLeft+90, chartLeft+95)
+	b.WriteString("</svg>\n")
+	return b.Bytes()
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The comparison page shows moonrise and moonset at several locations side
+// by side, one row per day:
+//
+//	/compare?loc=Melbourne,AU&loc=Dark site:-36.95,144.05@Australia/Melbourne
+//
+// Each loc is anything the location form accepts (a place name,
+// coordinates or a grid locator), optionally labelled with "Label:" in
+// front and followed by "@zone", an IANA zone or a fixed offset in hours.
+// Each location's times are local to it, in that zone, the place's zone or
+// the zone containing it.
+// The days are chosen as for the calendar: year and month, view=week and
+// date, or from and to. Without any loc it compares the saved locations.
+
+// maxCompareLocations limits the loc parameters read.
+const maxCompareLocations = 6
+
+// compareLocation is one column of the comparison.
+type compareLocation struct {
+	Spec  string // the loc parameter
+	Label string
+	view  calendarView
+}
+
+// parseCompareLocation resolves one loc parameter. Place names matching
+// several places take the first, as for place=. The label ends at the last
+// ":", since labels are free text and locations never contain one. A
+// trailing "@" only starts a zone if what follows is one.
+func parseCompareLocation(spec string) (compareLocation, error) {
+	rest, zone, zon, fixed := spec, "", 0.0, false
+	if i := strings.LastIndex(spec, "@"); i >= 0 {
+		z := strings.TrimSpace(spec[i+1:])
+		if validZone(z) {
+			rest, zone = spec[:i], z
+		} else if h, err := strconv.ParseFloat(z, 64); err == nil && h >= -12 && h <= 14 {
+			rest, zon, fixed = spec[:i], h, true
+		}
+	}
+	label, where := "", rest
+	if i := strings.LastIndex(rest, ":"); i >= 0 {
+		label, where = rest[:i], rest[i+1:]
+	}
+	label = strings.TrimSpace(label)
+	locs, err := resolveLocation(where)
+	if err != nil {
+		return compareLocation{}, err
+	}
+	l := locs[0]
+	if label == "" {
+		label = l.Name
+	}
+	if label == "" {
+		label = coordLabel(l.Lat, l.Lon)
+	}
+	v := calendarView{Name: l.Name, Lat: l.Lat, Lon: l.Lon, Zone: l.Zone}
+	switch {
+	case zone != "":
+		v.Zone = zone
+	case fixed:
+		v.Zone, v.Zon = "", zon
+	}
+	return compareLocation{Spec: spec, Label: label, view: v}, nil
+}
+
+// compareSpec returns the loc parameter for a location labelled name (if
+// any) at lat, lon, in zone or, if zone is empty, at the fixed offset zon.
+func compareSpec(name string, lat, lon float64, zone string, zon float64) string {
+	spec := strconv.FormatFloat(lat, 'f', -1, 64) + "," + strconv.FormatFloat(lon, 'f', -1, 64)
+	if name != "" {
+		spec = name + ":" + spec
+	}
+	if zone == "" {
+		zone = strconv.FormatFloat(zon, 'f', -1, 64)
+	}
+	return spec + "@" + zone
+}
+
+// savedCompareLocation returns the column for a saved location, in its
+// saved zone.
+func savedCompareLocation(l location) compareLocation {
+	spec := compareSpec(l.Name, l.Lat, l.Lon, l.Zone, 0)
+	return compareLocation{
+		Spec:  spec,
+		Label: l.Name,
+		view:  calendarView{Name: l.Name, Lat: l.Lat, Lon: l.Lon, Zone: l.Zone},
+	}
+}
+
+// comparison is a resolved /compare request.
+type comparison struct {
+	Locations []compareLocation
+	Errors    []string // loc parameters that could not be resolved
+	days      calendarView
+	saved     bool // the locations are the caller's saved ones
+}
+
+// compareFromQuery resolves the comparison's query parameters. The days
+// are read in the first location's zone.
+func compareFromQuery(r *http.Request) comparison {
+	q := r.URL.Query()
+	var c comparison
+	for _, spec := range q["loc"] {
+		if strings.TrimSpace(spec) == "" {
+			continue
+		}
+		if len(c.Locations)+len(c.Errors) == maxCompareLocations {
+			c.Errors = append(c.Errors, fmt.Sprintf("only the first %d locations are shown", maxCompareLocations))
+			break
+		}
+		l, err := parseCompareLocation(spec)
+		if err != nil {
+			c.Errors = append(c.Errors, fmt.Sprintf("%s: %v", spec, err))
+			continue
+		}
+		c.Locations = append(c.Locations, l)
+	}
+	if len(c.Locations)+len(c.Errors) == 0 {
+		saved := savedLocations(r)
+		for _, l := range saved[:min(len(saved), maxCompareLocations)] {
+			c.Locations = append(c.Locations, savedCompareLocation(l))
+		}
+		c.saved = len(c.Locations) > 0
+	}
+	if len(c.Locations) > 0 {
+		c.days = c.Locations[0].view
+	} else {
+		c.days = calendarView{Zone: defaultLocationFor(r).Zone}
+	}
+	c.days.setDays(q)
+	for i := range c.Locations {
+		v := &c.Locations[i].view
+		v.Year, v.Month, v.View, v.From, v.To = c.days.Year, c.days.Month, c.days.View, c.days.From, c.days.To
+	}
+	return c
+}
+
+// url links to the comparison of the same locations over days. Saved
+// locations are left to the cookie.
+func (c comparison) url(days calendarView) string {
+	q := url.Values{}
+	if !c.saved {
+		for _, l := range c.Locations {
+			q.Add("loc", l.Spec)
+		}
+	}
+	days.spanParams(q)
+	return "/compare?" + q.Encode()
+}
+
+// compareURL links to the comparison starting with the view's location, in
+// the view's zone.
+func (v calendarView) compareURL() string {
+	return "/compare?" + url.Values{"loc": {compareSpec(v.Name, v.Lat, v.Lon, v.Zone, v.Zon)}}.Encode()
+}
+
+// handleCompare serves /compare.
+func handleCompare(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	c := compareFromQuery(r)
+	now := c.days.localNow()
+	from, to := c.days.span()
+	today := now.Format("02-01-2006")
+
+	cacheControl, includesToday := spanCacheControl(now, from, to)
+	var highlight string
+	if includesToday {
+		highlight = today
+	}
+	w.Header().Add("Vary", "Cookie")
+	if len(c.Locations) == 0 || c.saved {
+		cacheControl = cachePerLocation // from the caller's IP or cookie
+	}
+	site := siteURL()
+	params := []any{c.days.View, from.Format(time.DateOnly), to.Format(time.DateOnly), site, highlight}
+	for _, l := range c.Locations {
+		params = append(params, l.Spec, l.view.Zone)
+	}
+	for _, e := range c.Errors {
+		params = append(params, e)
+	}
+	etag := computedETag("compare", params...)
+	if notModified(w, r, etag, cacheControl) {
+		return
+	}
+
+	type column struct {
+		Label string
+		Zone  string
+	}
+	type row struct {
+		Date     string
+		Phase    string
+		PhaseImg string
+		IsToday  bool
+		Cells    []calendarRow
+	}
+	data := struct {
+		Heading    string
+		Columns    []column
+		Rows       []row
+		Errors     []string
+		Specs      []string // the loc inputs, with room for one more
+		Days       url.Values
+		PrevURL    string
+		NextURL    string
+		MonthURL   string
+		WeekURL    string
+		View       string
+		From, To   string
+		Canonical  string
+		FooterSpan int
+	}{
+		Heading: time.Month(c.days.Month).String() + " " + strconv.Itoa(c.days.Year),
+		Errors:  c.Errors,
+		View:    c.days.View,
+		From:    from.Format(time.DateOnly),
+		To:      to.Format(time.DateOnly),
+		// Date, phase, and rise and set for each location.
+		FooterSpan: 2 + 2*len(c.Locations),
+	}
+	if c.days.View != "" {
+		data.Heading = spanLabel(from, to)
+	}
+	for _, l := range c.Locations {
+		zone := l.view.Zone
+		if zone == "" {
+			zone = formatOffset(int(l.view.Zon * 3600))
+		}
+		data.Columns = append(data.Columns, column{Label: l.Label, Zone: zone})
+		data.Specs = append(data.Specs, l.Spec)
+	}
+	if len(data.Specs) < maxCompareLocations {
+		data.Specs = append(data.Specs, "")
+	}
+	data.Days = url.Values{}
+	c.days.spanParams(data.Days)
+	data.PrevURL, data.NextURL = c.url(c.days.shifted(-1)), c.url(c.days.shifted(1))
+
+	// The view switch, as in the calendar, starts from today if it is on
+	// the page, otherwise from the first day.
+	switchDate := from
+	if includesToday {
+		switchDate = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	}
+	month := calendarView{Year: switchDate.Year(), Month: int(switchDate.Month())}
+	var week calendarView
+	week.setSpan(url.Values{"view": {viewWeek}, "date": {switchDate.Format(time.DateOnly)}}, switchDate)
+	data.MonthURL, data.WeekURL = c.url(month), c.url(week)
+	data.Canonical = site + c.url(c.days)
+
+	for d := from; len(c.Locations) > 0 && !d.After(to); d = d.AddDate(0, 0, 1) {
+		rw := row{Date: d.Format("02-01-2006"), IsToday: d.Format("02-01-2006") == highlight}
+		for _, l := range c.Locations {
+			rw.Cells = append(rw.Cells, l.view.row(r.Context(), d, ""))
+		}
+		rw.Phase, rw.PhaseImg = rw.Cells[0].Phase, rw.Cells[0].PhaseImg
+		data.Rows = append(data.Rows, rw)
+	}
+
+	if err := executeTemplate(r.Context(), w, "compare.html", data); err != nil {
+		slog.ErrorContext(r.Context(), "Error executing compare template", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"html"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// Test loc parameters resolve with optional labels
+func TestParseCompareLocation(t *testing.T) {
+	cases := []struct {
+		spec, label, zone string
+	}{
+		{"Melbourne, AU", "Melbourne, Australia", "Australia/Melbourne"},
+		{"Dark site: -36.95, 144.05", "Dark site", "Australia/Melbourne"},
+		{"51.48,0", "51.48°N 0.00°E", "Europe/London"},
+		{"Cabin:QF22", "Cabin", "Australia/Melbourne"},
+		{"Site: North:-37.8,144.9", "Site: North", "Australia/Melbourne"},
+		{"Base:-37.8,144.9@Asia/Tokyo", "Base", "Asia/Tokyo"},
+		{"Melbourne, AU@UTC", "Melbourne, Australia", "UTC"},
+		{"Me@home:QF22", "Me@home", "Australia/Melbourne"},
+		{"Ship:-37.8,144.9@9.5", "Ship", ""},
+	}
+	for _, c := range cases {
+		l, err := parseCompareLocation(c.spec)
+		if err != nil || l.Label != c.label || l.view.Zone != c.zone || l.Spec != c.spec {
+			t.Errorf("parseCompareLocation(%q) = %+v, %v", c.spec, l, err)
+		}
+	}
+	if _, err := parseCompareLocation("Nowhere:zzzzqq"); err == nil {
+		t.Error("expected an error for an unknown place")
+	}
+}
+
+// Test the comparison shows each location in its own zone and keeps them in links
+func TestCompare(t *testing.T) {
+	get := func(url string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		handleCompare(rr, httptest.NewRequest("GET", url, nil))
+		return rr
+	}
+
+	rr := get("/compare?loc=Melbourne,AU&loc=Perth&loc=zzzzqq&year=2026&month=4")
+	body := rr.Body.String()
+	if rr.Code != http.StatusOK || rr.Header().Get("Cache-Control") != cacheFor(maxDynamicAge) {
+		t.Fatalf("compare = %d %v", rr.Code, rr.Header())
+	}
+	for _, want := range []string{
+		`Melbourne, Australia<div class="compare-zone">Australia/Melbourne</div>`,
+		`Perth, Australia<div class="compare-zone">Australia/Perth</div>`,
+		`zzzzqq: no place called`,
+		`href="/compare?loc=Melbourne%2CAU&amp;loc=Perth&amp;month=3&amp;year=2026" rel="prev"`,
+		`href="/compare?loc=Melbourne%2CAU&amp;loc=Perth&amp;month=5&amp;year=2026" rel="next"`,
+		`<input type="text" name="loc" value="Perth" aria-label="Location"><input type="text" name="loc" value="" aria-label="Location">`,
+		`<th colspan="6">`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("compare page missing %q", want)
+		}
+	}
+
+	// Melbourne leaves DST on 5 April 2026 and Perth has none, so the gap
+	// between their moonrises shrinks by an hour.
+	rise := func(date string) (melbourne, perth string) {
+		m := regexp.MustCompile(date + `</td>\s*<td><img[^>]*></td>\s*<td>(\d\d:\d\d)</td><td>[^<]*</td><td>(\d\d:\d\d)</td>`).FindStringSubmatch(body)
+		if m == nil {
+			t.Fatalf("no moonrises for %s", date)
+		}
+		return m[1], m[2]
+	}
+	gap := func(date string) int {
+		a, b := rise(date)
+		mins := func(s string) int { return int(s[0]-'0')*600 + int(s[1]-'0')*60 + int(s[3]-'0')*10 + int(s[4]-'0') }
+		return mins(a) - mins(b)
+	}
+	if g4, g5 := gap("04-04-2026"), gap("05-04-2026"); g4-g5 < 50 || g4-g5 > 70 {
+		t.Errorf("Melbourne-Perth gap %d min on 4 April, %d min on 5 April", g4, g5)
+	}
+
+	body = get("/compare?loc=Melbourne,AU&loc=London,GB&view=week&date=2026-10-01").Body.String()
+	if n := strings.Count(body, `<tr>`+"\n"+`								<td>`); n != 7 {
+		t.Errorf("week comparison has %d rows", n)
+	}
+	if !strings.Contains(body, `href="/compare?date=2026-10-05&amp;loc=Melbourne%2CAU&amp;loc=London%2CGB&amp;view=week" rel="next"`) {
+		t.Error("week comparison should keep the view in its links")
+	}
+
+	// Without locations there is only the form.
+	rr = get("/compare")
+	if rr.Code != http.StatusOK || strings.Contains(rr.Body.String(), "<table") || rr.Header().Get("Cache-Control") != cachePerLocation {
+		t.Errorf("empty comparison = %d, %q", rr.Code, rr.Header().Get("Cache-Control"))
+	}
+}
+
+// Test the calendar links to a comparison starting with its location
+func TestCalendarCompareLink(t *testing.T) {
+	rr := httptest.NewRecorder()
+	calendar(rr, httptest.NewRequest("GET", "/calendar?place=Melbourne,AU&year=2026&month=10", nil))
+	if !strings.Contains(rr.Body.String(), `href="/compare?loc=Melbourne%2C&#43;Australia%3A-37.814%2C144.9633%40Australia%2FMelbourne">Compare</a>`) {
+		t.Error("calendar missing its compare link")
+	}
+
+	// Saved names may hold a colon, and saved zones needn't be the one
+	// containing the coordinates; the comparison keeps both.
+	req := httptest.NewRequest("GET", "/calendar?lat=-37.8&lon=144.9&tz=Asia/Tokyo&year=2026&month=10", nil)
+	req.AddCookie(savedCookie(t, []location{{Name: "Site: North", Lat: -37.8, Lon: 144.9, Zone: "Asia/Tokyo"}}))
+	rr = httptest.NewRecorder()
+	calendar(rr, req)
+	calBody := rr.Body.String()
+	link := regexp.MustCompile(`href="(/compare\?[^"]*)">Compare</a>`).FindStringSubmatch(calBody)
+	if link == nil {
+		t.Fatal("saved location's calendar missing its compare link")
+	}
+	rr = httptest.NewRecorder()
+	handleCompare(rr, httptest.NewRequest("GET", html.UnescapeString(link[1])+"&year=2026&month=10", nil))
+	body := rr.Body.String()
+	if !strings.Contains(body, `Site: North<div class="compare-zone">Asia/Tokyo</div>`) {
+		t.Fatalf("compare link %s doesn't resolve the saved location", link[1])
+	}
+	rise := func(body string) string {
+		m := regexp.MustCompile(`15-10-2026</a></td>\s*<td><img[^>]*></td>\s*<td>(\d\d:\d\d)|15-10-2026</td>\s*<td><img[^>]*></td>\s*<td>(\d\d:\d\d)`).FindStringSubmatch(body)
+		if m == nil {
+			t.Fatal("no moonrise on 15 October")
+		}
+		return m[1] + m[2]
+	}
+	if c, p := rise(calBody), rise(body); c != p {
+		t.Errorf("moonrise %s on the calendar, %s on the comparison", c, p)
+	}
+
+	// A fixed offset is kept as one.
+	rr = httptest.NewRecorder()
+	calendar(rr, httptest.NewRequest("GET", "/calendar?lat=-37.8&lon=144.9&zon=9.5&year=2026&month=10", nil))
+	if !strings.Contains(rr.Body.String(), `%2C144.9%409.5">Compare</a>`) {
+		t.Error("fixed-offset calendar should link to a fixed-offset comparison")
+	}
+	rr = httptest.NewRecorder()
+	handleCompare(rr, httptest.NewRequest("GET", "/compare?loc=-37.8,144.9@9.5&year=2026&month=10", nil))
+	if !strings.Contains(rr.Body.String(), `<div class="compare-zone">UTC&#43;09:30</div>`) {
+		t.Error("fixed-offset comparison should name its offset")
+	}
+}
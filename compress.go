@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// Content codings offered, in order of preference.
+const (
+	encodingBrotli = "br"
+	encodingGzip   = "gzip"
+)
+
+// negotiateEncoding picks brotli or gzip from an Accept-Encoding header,
+// honouring q-values (including q=0 refusals and the "*" wildcard). It
+// returns "" when the response should be sent uncompressed.
+func negotiateEncoding(header string) string {
+	return negotiateAmong(header, encodingBrotli, encodingGzip)
+}
+
+// negotiateAmong is negotiateEncoding limited to the codings offered, in
+// order of preference, for responses that only exist in some of them.
+func negotiateAmong(header string, offered ...string) string {
+	q := map[string]float64{}
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding == "" {
+			continue
+		}
+		weight := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				weight = f
+			}
+		}
+		q[coding] = weight
+	}
+	best, bestQ := "", 0.0
+	for _, coding := range offered {
+		w, ok := q[coding]
+		if !ok {
+			w, ok = q["*"]
+		}
+		if ok && w > bestQ {
+			best, bestQ = coding, w
+		}
+	}
+	return best
+}
+
+// isCompressible reports whether a Content-Type is worth compressing.
+// Images other than SVG, and other binary types, are already compressed.
+func isCompressible(contentType string) bool {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	switch {
+	case strings.HasPrefix(mt, "text/"):
+		return true
+	case mt == "application/json", mt == "application/javascript",
+		mt == "application/xml", mt == "image/svg+xml",
+		strings.HasSuffix(mt, "+json"), strings.HasSuffix(mt, "+xml"):
+		return true
+	}
+	return false
+}
+
+// minCompressSize is the smallest body worth compressing; below this the
+// encoding overhead outweighs the saving.
+const minCompressSize = 512
+
+// compressResponses negotiates gzip or brotli for dynamic responses. The
+// decision is made once the handler writes its headers: responses that are
+// already encoded, aren't a compressible type, or carry no body pass
+// through. Static assets are skipped here because staticHandler serves
+// precompressed variants itself.
+func compressResponses(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isStaticPath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cw := &compressWriter{ResponseWriter: w, encoding: negotiateEncoding(r.Header.Get("Accept-Encoding")), head: r.Method == http.MethodHead}
+		defer cw.close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// compressWriter buffers the start of the body until it knows whether the
+// response is big enough to compress, then either streams it through an
+// encoder or writes it out unchanged.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string // negotiated coding, "" for none
+	head     bool
+
+	status      int
+	wroteHeader bool // headers decided and sent downstream
+	eligible    bool // compressible response, still buffering
+	buf         bytes.Buffer
+	enc         io.WriteCloser
+}
+
+func (cw *compressWriter) WriteHeader(code int) {
+	if cw.status != 0 {
+		return
+	}
+	cw.status = code
+	h := cw.Header()
+	ct := h.Get("Content-Type")
+	if isCompressible(ct) {
+		h.Add("Vary", "Accept-Encoding")
+	}
+	cw.eligible = cw.encoding != "" && !cw.head &&
+		code >= 200 && code != http.StatusNoContent && code != http.StatusNotModified &&
+		h.Get("Content-Encoding") == "" && isCompressible(ct)
+	if !cw.eligible {
+		cw.flushHeader()
+	}
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if cw.status == 0 {
+		if cw.Header().Get("Content-Type") == "" {
+			cw.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		cw.WriteHeader(http.StatusOK)
+	}
+	switch {
+	case cw.enc != nil:
+		return cw.enc.Write(b)
+	case !cw.eligible:
+		return cw.ResponseWriter.Write(b)
+	}
+	cw.buf.Write(b)
+	if cw.buf.Len() >= minCompressSize {
+		if err := cw.startEncoding(); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+func (cw *compressWriter) flushHeader() {
+	if !cw.wroteHeader {
+		cw.wroteHeader = true
+		cw.ResponseWriter.WriteHeader(cw.status)
+	}
+}
+
+// startEncoding commits to compressing: it fixes up the headers, then
+// pushes the buffered prefix through a new encoder.
+func (cw *compressWriter) startEncoding() error {
+	h := cw.Header()
+	h.Set("Content-Encoding", cw.encoding)
+	h.Del("Content-Length")
+	if etag := h.Get("ETag"); etag != "" {
+		// A different representation needs a different entity tag.
+		h.Set("ETag", strings.TrimSuffix(etag, `"`)+"-"+cw.encoding+`"`)
+	}
+	cw.flushHeader()
+	if cw.encoding == encodingBrotli {
+		cw.enc = brotli.NewWriterLevel(cw.ResponseWriter, 5)
+	} else {
+		cw.enc = gzip.NewWriter(cw.ResponseWriter)
+	}
+	_, err := cw.enc.Write(cw.buf.Bytes())
+	cw.buf.Reset()
+	return err
+}
+
+// close finishes the response: it flushes the encoder, or writes out a
+// body that stayed under minCompressSize uncompressed.
+func (cw *compressWriter) close() {
+	switch {
+	case cw.enc != nil:
+		cw.enc.Close()
+	case cw.status == 0:
+		// Handler wrote nothing; let net/http send its implicit 200.
+	default:
+		cw.eligible = false
+		cw.flushHeader()
+		if cw.buf.Len() > 0 {
+			cw.ResponseWriter.Write(cw.buf.Bytes())
+		}
+	}
+}
+
+// Flush sends any buffered data, committing to compression if eligible,
+// so streaming handlers still work.
+func (cw *compressWriter) Flush() {
+	if cw.status == 0 {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.eligible && cw.enc == nil {
+		_ = cw.startEncoding()
+	} else {
+		cw.flushHeader()
+	}
+	if f, ok := cw.enc.(interface{ Flush() error }); ok {
+		_ = f.Flush()
+	}
+	http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// precompress returns the gzip and brotli encodings of data at maximum
+// compression, each nil if it wouldn't be smaller than the original.
+func precompress(data []byte) (gz, br []byte) {
+	var b bytes.Buffer
+	gw, _ := gzip.NewWriterLevel(&b, gzip.BestCompression)
+	gw.Write(data)
+	gw.Close()
+	if b.Len() < len(data) {
+		gz = bytes.Clone(b.Bytes())
+	}
+
+	b.Reset()
+	bw := brotli.NewWriterLevel(&b, brotli.BestCompression)
+	bw.Write(data)
+	bw.Close()
+	if b.Len() < len(data) {
+		br = bytes.Clone(b.Bytes())
+	}
+	return gz, br
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	cases := map[string]string{
+		"":                          "",
+		"identity":                  "",
+		"gzip":                      "gzip",
+		"gzip, deflate, br":         "br",
+		"br;q=0, gzip":              "gzip",
+		"br;q=0.5, gzip;q=0.8":      "gzip",
+		"*":                         "br",
+		"*;q=0.1, br;q=0":           "gzip",
+		"GZIP;q=1.0":                "gzip",
+		"deflate, gzip;q=0, br;q=0": "",
+	}
+	for header, want := range cases {
+		if got := negotiateEncoding(header); got != want {
+			t.Errorf("negotiateEncoding(%q) = %q, want %q", header, got, want)
+		}
+	}
+}
+
+// Test the calendar page is compressed for both codings and decodes intact
+func TestCompressResponses(t *testing.T) {
+	handler := compressResponses(http.HandlerFunc(calendar))
+	plain := httptest.NewRecorder()
+	handler.ServeHTTP(plain, httptest.NewRequest("GET", "/calendar?year=2026&month=3", nil))
+	if plain.Header().Get("Content-Encoding") != "" {
+		t.Fatalf("uncompressed request got Content-Encoding %q", plain.Header().Get("Content-Encoding"))
+	}
+
+	for enc, reader := range map[string]func(io.Reader) (io.Reader, error){
+		"gzip": func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+		"br":   func(r io.Reader) (io.Reader, error) { return brotli.NewReader(r), nil },
+	} {
+		req := httptest.NewRequest("GET", "/calendar?year=2026&month=3", nil)
+		req.Header.Set("Accept-Encoding", enc)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if got := rr.Header().Get("Content-Encoding"); got != enc {
+			t.Errorf("%s: Content-Encoding = %q", enc, got)
+		}
+		if got := rr.Header().Values("Vary"); !slices.Contains(got, "Accept-Encoding") {
+			t.Errorf("%s: Vary = %q", enc, got)
+		}
+		if rr.Body.Len() >= plain.Body.Len() {
+			t.Errorf("%s: compressed body (%d) not smaller than plain (%d)", enc, rr.Body.Len(), plain.Body.Len())
+		}
+		r, err := reader(rr.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("%s: decode: %v", enc, err)
+		}
+		if string(body) != plain.Body.String() {
+			t.Errorf("%s: decoded body differs from uncompressed response", enc)
+		}
+	}
+}
+
+// Test small and non-text responses are left alone
+func TestCompressResponsesSkips(t *testing.T) {
+	cases := []struct {
+		name    string
+		handler http.HandlerFunc
+	}{
+		{"small JSON", gettimes},
+		{"binary", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "image/png")
+			w.Write([]byte(strings.Repeat("x", 4096)))
+		}},
+		{"not modified", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html")
+			w.WriteHeader(http.StatusNotModified)
+		}},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest("GET", "/gettimes?lon=144&lat=-37&zon=10", nil)
+		req.Header.Set("Accept-Encoding", "gzip, br")
+		rr := httptest.NewRecorder()
+		compressResponses(c.handler).ServeHTTP(rr, req)
+		if got := rr.Header().Get("Content-Encoding"); got != "" {
+			t.Errorf("%s: Content-Encoding = %q, want none", c.name, got)
+		}
+	}
+}
+
+// Test static text assets are served from their precompressed variants
+func TestStaticPrecompressed(t *testing.T) {
+	handler := http.StripPrefix("/static", staticHandler())
+	a := assets.byName["script.js"]
+	if a.gz == nil || a.br == nil {
+		t.Fatal("script.js should have precompressed variants")
+	}
+	if assets.byName["moon.jpg"].gz != nil {
+		t.Error("JPEG should not be precompressed")
+	}
+
+	req := httptest.NewRequest("GET", assetURL("script.js"), nil)
+	req.Header.Set("Accept-Encoding", "gzip, br")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Header().Get("Content-Encoding") != "br" || rr.Header().Get("Vary") != "Accept-Encoding" {
+		t.Errorf("headers = %v", rr.Header())
+	}
+	if rr.Body.String() != string(a.br) {
+		t.Error("body should be the brotli variant")
+	}
+	if etag := rr.Header().Get("ETag"); etag != strings.TrimSuffix(a.etag, `"`)+`-br"` {
+		t.Errorf("ETag = %s, want encoding-specific tag", etag)
+	}
+}
+
+// Test a client is never sent a coding it didn't accept when the asset
+// lacks the variant it prefers
+func TestStaticPrecompressedMissingVariant(t *testing.T) {
+	handler := http.StripPrefix("/static", staticHandler())
+	gzOnly := *assets.byName["script.js"]
+	gzOnly.name, gzOnly.hashed, gzOnly.br = "gzonly.js", "gzonly.0123456789.js", nil
+	assets.byName[gzOnly.name], assets.byHashed[gzOnly.hashed] = &gzOnly, &gzOnly
+	t.Cleanup(func() {
+		delete(assets.byName, gzOnly.name)
+		delete(assets.byHashed, gzOnly.hashed)
+	})
+
+	cases := map[string]string{
+		"br":              "",
+		"br, gzip;q=0":    "",
+		"br, identity":    "",
+		"br, gzip;q=0.5":  "gzip",
+		"*":               "gzip",
+		"gzip, deflate":   "gzip",
+		"identity;q=0, *": "gzip",
+	}
+	for header, want := range cases {
+		req := httptest.NewRequest("GET", "/static/"+gzOnly.hashed, nil)
+		req.Header.Set("Accept-Encoding", header)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		body := gzOnly.data
+		if want == "gzip" {
+			body = gzOnly.gz
+		}
+		if got := rr.Header().Get("Content-Encoding"); got != want || !bytes.Equal(rr.Body.Bytes(), body) {
+			t.Errorf("Accept-Encoding %q: Content-Encoding %q, want %q", header, got, want)
+		}
+	}
+}
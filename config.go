@@ -0,0 +1,434 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// config holds every runtime setting. Values are layered with increasing
+// precedence: built-in defaults, then a config file (JSON, or flat TOML or
+// YAML), then environment variables, then command-line flags.
+type config struct {
+	Prod            bool
+	Dev             bool
+	Port            int
+	SiteURL         string
+	GoogleMapsKey   string
+	MapProvider     string
+	TileURL         string
+	TileAttribution string
+	MonitorURL      string
+	MonitorAPIKey   string
+	CookieKey       string
+	ReadTimeout     time.Duration
+	WriteTimeout    time.Duration
+	IdleTimeout     time.Duration
+	ShutdownTimeout time.Duration
+	RateLimit       int
+	RateWindow      time.Duration
+	ClientIPHeader  string
+	DefaultName     string
+	DefaultLat      float64
+	DefaultLon      float64
+	DefaultTZ       string
+	GeoIPDB         string
+	StaticMaxAge    time.Duration
+	LogStaticSample int
+
+	RisetCacheSize      int
+	RisetCachePrecision int
+
+	TracesExporter     string
+	OTLPEndpoint       string
+	OTLPTracesEndpoint string
+	OTLPHeaders        string
+	ServiceName        string
+}
+
+// cfg is the effective configuration. It starts at the defaults so handlers
+// work unchanged in tests, and is replaced by main after loadConfig.
+var cfg = defaultConfig()
+
+func defaultConfig() config {
+	return config{
+		Port:            8484,
+		MapProvider:     mapGoogle,
+		TileURL:         "https://tile.openstreetmap.org/{z}/{x}/{y}.png",
+		TileAttribution: `&copy; <a href="https://www.openstreetmap.org/copyright">OpenStreetMap</a> contributors`,
+		ReadTimeout:     5 * time.Second,
+		WriteTimeout:    10 * time.Second,
+		IdleTimeout:     60 * time.Second,
+		ShutdownTimeout: 5 * time.Second,
+		RateLimit:       60,
+		RateWindow:      time.Minute,
+		DefaultName:     "Melbourne",
+		DefaultLat:      -37,
+		DefaultLon:      144,
+		DefaultTZ:       "Australia/Melbourne",
+		StaticMaxAge:    365 * 24 * time.Hour,
+		LogStaticSample: 1,
+
+		RisetCacheSize:      20000,
+		RisetCachePrecision: 2,
+
+		TracesExporter: "none",
+		OTLPEndpoint:   "http://localhost:4318",
+		ServiceName:    "moon",
+	}
+}
+
+// configEnv maps each flag name to the environment variable that sets it.
+// Flag names double as the keys in the JSON config file.
+var configEnv = map[string]string{
+	"prod":              "PROD",
+	"dev":               "DEV",
+	"port":              "PORT",
+	"site-url":          "SITE_URL",
+	"google-maps-key":   "GOOGLE_MAPS_API_KEY",
+	"map-provider":      "MAP_PROVIDER",
+	"tile-url":          "TILE_URL",
+	"tile-attribution":  "TILE_ATTRIBUTION",
+	"monitor-url":       "MONITOR_URL",
+	"monitor-api-key":   "MONITOR_API_KEY",
+	"cookie-key":        "COOKIE_KEY",
+	"read-timeout":      "READ_TIMEOUT",
+	"write-timeout":     "WRITE_TIMEOUT",
+	"idle-timeout":      "IDLE_TIMEOUT",
+	"shutdown-timeout":  "SHUTDOWN_TIMEOUT",
+	"rate-limit":        "RATE_LIMIT",
+	"rate-window":       "RATE_WINDOW",
+	"client-ip-header":  "CLIENT_IP_HEADER",
+	"default-name":      "DEFAULT_NAME",
+	"default-lat":       "DEFAULT_LAT",
+	"default-lon":       "DEFAULT_LON",
+	"default-tz":        "DEFAULT_TZ",
+	"geoip-db":          "GEOIP_DB",
+	"static-max-age":    "STATIC_MAX_AGE",
+	"log-static-sample": "LOG_STATIC_SAMPLE",
+
+	"riseset-cache-size":      "RISESET_CACHE_SIZE",
+	"riseset-cache-precision": "RISESET_CACHE_PRECISION",
+
+	// The standard OpenTelemetry variables.
+	"otel-traces-exporter":               "OTEL_TRACES_EXPORTER",
+	"otel-exporter-otlp-endpoint":        "OTEL_EXPORTER_OTLP_ENDPOINT",
+	"otel-exporter-otlp-traces-endpoint": "OTEL_EXPORTER_OTLP_TRACES_ENDPOINT",
+	"otel-exporter-otlp-headers":         "OTEL_EXPORTER_OTLP_HEADERS",
+	"otel-service-name":                  "OTEL_SERVICE_NAME",
+}
+
+// configSecrets are redacted by -print-config.
+var configSecrets = map[string]bool{
+	"google-maps-key": true,
+	"monitor-api-key": true,
+	"cookie-key":      true,
+
+	"otel-exporter-otlp-headers": true, // usually carries an API key
+}
+
+// bindConfigFlags defines one flag per config field, bound directly to c,
+// so setting a flag's Value (from the file, env or command line) updates c.
+func bindConfigFlags(fs *flag.FlagSet, c *config) {
+	fs.BoolVar(&c.Prod, "prod", c.Prod, "production mode (enables HSTS)")
+	fs.BoolVar(&c.Dev, "dev", c.Dev, "serve templates and static files from the working directory, re-parsing templates within half a second of a change")
+	fs.IntVar(&c.Port, "port", c.Port, "HTTP listen port")
+	fs.StringVar(&c.SiteURL, "site-url", c.SiteURL, "public base URL for canonical links and Open Graph tags, e.g. https://moon.example.com (default: relative canonical links and no og:url or og:image)")
+	fs.StringVar(&c.GoogleMapsKey, "google-maps-key", c.GoogleMapsKey, "Google Maps JavaScript API key")
+	fs.StringVar(&c.MapProvider, "map-provider", c.MapProvider, `index page map: "google" or "leaflet" (bundled Leaflet with tile-url)`)
+	fs.StringVar(&c.TileURL, "tile-url", c.TileURL, "Leaflet tile URL template with {z}, {x}, {y} and optional {s}")
+	fs.StringVar(&c.TileAttribution, "tile-attribution", c.TileAttribution, "Leaflet attribution HTML for the tiles")
+	fs.StringVar(&c.MonitorURL, "monitor-url", c.MonitorURL, "monitor portal base URL for log shipping")
+	fs.StringVar(&c.MonitorAPIKey, "monitor-api-key", c.MonitorAPIKey, "monitor portal API key")
+	fs.StringVar(&c.CookieKey, "cookie-key", c.CookieKey, "secret signing the saved-locations cookie, at least 32 characters (default: random, so saved locations are lost on restart)")
+	fs.DurationVar(&c.ReadTimeout, "read-timeout", c.ReadTimeout, "HTTP server read timeout")
+	fs.DurationVar(&c.WriteTimeout, "write-timeout", c.WriteTimeout, "HTTP server write timeout")
+	fs.DurationVar(&c.IdleTimeout, "idle-timeout", c.IdleTimeout, "HTTP server idle timeout")
+	fs.DurationVar(&c.ShutdownTimeout, "shutdown-timeout", c.ShutdownTimeout, "grace period for in-flight requests on shutdown")
+	fs.IntVar(&c.RateLimit, "rate-limit", c.RateLimit, "max requests per client IP per rate window")
+	fs.DurationVar(&c.RateWindow, "rate-window", c.RateWindow, "rate limit window")
+	fs.StringVar(&c.ClientIPHeader, "client-ip-header", c.ClientIPHeader, "request header a trusted proxy puts the client IP in, e.g. CF-Connecting-IP (default: the connection's address)")
+	fs.StringVar(&c.DefaultName, "default-name", c.DefaultName, "name of the default location")
+	fs.Float64Var(&c.DefaultLat, "default-lat", c.DefaultLat, "latitude used when a request omits one")
+	fs.Float64Var(&c.DefaultLon, "default-lon", c.DefaultLon, "longitude used when a request omits one")
+	fs.StringVar(&c.DefaultTZ, "default-tz", c.DefaultTZ, "IANA time zone of the default location")
+	fs.StringVar(&c.GeoIPDB, "geoip-db", c.GeoIPDB, "optional DB-IP city CSV used to default the location from the client IP; behind a proxy, set client-ip-header too")
+	fs.DurationVar(&c.StaticMaxAge, "static-max-age", c.StaticMaxAge, "Cache-Control max-age for content-hashed /static/ assets")
+	fs.IntVar(&c.LogStaticSample, "log-static-sample", c.LogStaticSample, "log 1 in N successful static-asset requests (0 = none)")
+	fs.IntVar(&c.RisetCacheSize, "riseset-cache-size", c.RisetCacheSize, "max computed rise/set days kept in memory (0 = no caching)")
+	fs.IntVar(&c.RisetCachePrecision, "riseset-cache-precision", c.RisetCachePrecision, "decimal places lat/lon are rounded to before computing and caching")
+	fs.StringVar(&c.TracesExporter, "otel-traces-exporter", c.TracesExporter, `span exporter: "none", "console" (stdout) or "otlp"`)
+	fs.StringVar(&c.OTLPEndpoint, "otel-exporter-otlp-endpoint", c.OTLPEndpoint, "OTLP/HTTP collector base URL")
+	fs.StringVar(&c.OTLPTracesEndpoint, "otel-exporter-otlp-traces-endpoint", c.OTLPTracesEndpoint, "full OTLP traces URL, overriding the base URL")
+	fs.StringVar(&c.OTLPHeaders, "otel-exporter-otlp-headers", c.OTLPHeaders, "extra OTLP request headers as key=value pairs separated by commas")
+	fs.StringVar(&c.ServiceName, "otel-service-name", c.ServiceName, "service.name resource attribute on spans")
+}
+
+// loadConfig builds the effective configuration from args (without the
+// program name) and the environment. The config file is named by -config
+// or MOON_CONFIG. It also reports whether -print-config was given.
+func loadConfig(args []string, getenv func(string) string) (config, bool, error) {
+	// First pass: find -config and -print-config, and reject bad flags (or
+	// print usage for -h) before anything else is read.
+	var path string
+	var printConfig bool
+	scratch := defaultConfig()
+	pre := newConfigFlagSet(&scratch, &path, &printConfig)
+	if err := pre.Parse(args); err != nil {
+		return config{}, false, err
+	}
+	if path == "" {
+		path = getenv("MOON_CONFIG")
+	}
+
+	c := defaultConfig()
+	fs := newConfigFlagSet(&c, &path, &printConfig)
+
+	if path != "" {
+		if err := applyConfigFile(fs, path); err != nil {
+			return config{}, false, err
+		}
+	}
+
+	var errs []error
+	fs.VisitAll(func(f *flag.Flag) {
+		env, ok := configEnv[f.Name]
+		if !ok {
+			return
+		}
+		if v := getenv(env); v != "" {
+			if err := f.Value.Set(v); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", env, err))
+			}
+		}
+	})
+	if err := errors.Join(errs...); err != nil {
+		return config{}, false, err
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return config{}, false, err
+	}
+	if fs.NArg() > 0 {
+		return config{}, false, fmt.Errorf("unexpected arguments: %v", fs.Args())
+	}
+
+	return c, printConfig, c.validate()
+}
+
+func newConfigFlagSet(c *config, path *string, printConfig *bool) *flag.FlagSet {
+	fs := flag.NewFlagSet("moon", flag.ContinueOnError)
+	bindConfigFlags(fs, c)
+	fs.StringVar(path, "config", *path, "path to a config file: JSON, or flat TOML (.toml) or YAML (.yaml, .yml) (or MOON_CONFIG)")
+	fs.BoolVar(printConfig, "print-config", *printConfig, "print the effective config with secrets redacted, then exit")
+	return fs
+}
+
+// applyConfigFile sets flags from a config file whose keys are flag names.
+// The format is chosen by extension: .toml and .yaml or .yml for flat TOML
+// and YAML, anything else JSON.
+func applyConfigFile(fs *flag.FlagSet, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading config file: %w", err)
+	}
+	var settings map[string]string
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".toml":
+		settings, err = parseFlatConfig(data, "=")
+	case ".yaml", ".yml":
+		settings, err = parseFlatConfig(data, ":")
+	default:
+		settings, err = parseJSONConfig(data)
+	}
+	if err != nil {
+		return fmt.Errorf("parsing config file %s: %w", path, err)
+	}
+	var errs []error
+	for key, val := range settings {
+		f := fs.Lookup(key)
+		if f == nil || configEnv[key] == "" {
+			errs = append(errs, fmt.Errorf("%s: unknown setting %q", path, key))
+			continue
+		}
+		if err := f.Value.Set(val); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %s: %w", path, key, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// parseJSONConfig reads a JSON object of settings. Strings are passed
+// through as-is (so durations are written "5s"); numbers and booleans are
+// passed as their JSON text.
+func parseJSONConfig(data []byte) (map[string]string, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	settings := make(map[string]string, len(raw))
+	for key, val := range raw {
+		var s string
+		if err := json.Unmarshal(val, &s); err != nil {
+			s = string(val)
+		}
+		settings[key] = s
+	}
+	return settings, nil
+}
+
+// parseFlatConfig reads the flat subset of TOML (sep "=") or YAML (sep ":")
+// that settings need: one "key = value" or "key: value" per line, with
+// values bare, in double quotes (with Go/TOML escapes) or in single quotes
+// (literal), and # comments. Tables, sections, lists and multi-line values
+// are rejected rather than misread.
+func parseFlatConfig(data []byte, sep string) (map[string]string, error) {
+	settings := map[string]string{}
+	for i, line := range strings.Split(string(data), "\n") {
+		n := i + 1
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") || (sep == ":" && trimmed == "---") {
+			continue
+		}
+		if line[0] == ' ' || line[0] == '\t' || strings.HasPrefix(trimmed, "[") || strings.HasPrefix(trimmed, "-") {
+			return nil, fmt.Errorf("line %d: only flat \"key%svalue\" settings are supported", n, sep)
+		}
+		key, val, ok := strings.Cut(trimmed, sep)
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("line %d: expected \"key%svalue\"", n, sep)
+		}
+		v, err := flatValue(strings.TrimSpace(val))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s: %w", n, key, err)
+		}
+		if _, dup := settings[key]; dup {
+			return nil, fmt.Errorf("line %d: %s is set twice", n, key)
+		}
+		settings[key] = v
+	}
+	return settings, nil
+}
+
+// flatValue returns the value of a quoted or bare scalar, without any
+// trailing comment.
+func flatValue(s string) (string, error) {
+	var v, rest string
+	switch {
+	case strings.HasPrefix(s, `"`):
+		q, err := strconv.QuotedPrefix(s)
+		if err != nil {
+			return "", errors.New("unterminated or invalid string")
+		}
+		v, _ = strconv.Unquote(q)
+		rest = s[len(q):]
+	case strings.HasPrefix(s, "'"):
+		end := strings.IndexByte(s[1:], '\'')
+		if end < 0 {
+			return "", errors.New("unterminated string")
+		}
+		v, rest = s[1:end+1], s[end+2:]
+	default:
+		if i := strings.Index(s, " #"); i >= 0 {
+			s = s[:i]
+		}
+		return strings.TrimSpace(s), nil
+	}
+	if rest = strings.TrimSpace(rest); rest != "" && !strings.HasPrefix(rest, "#") {
+		return "", fmt.Errorf("unexpected %q after string", rest)
+	}
+	return v, nil
+}
+
+// validate reports every invalid setting at once, so a bad deploy fails at
+// startup with a complete list rather than one error per restart.
+func (c config) validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+	check(c.Port > 0 && c.Port <= 65535, "port %d out of range 1-65535", c.Port)
+	check(c.ReadTimeout > 0, "read-timeout must be positive")
+	check(c.WriteTimeout > 0, "write-timeout must be positive")
+	check(c.IdleTimeout > 0, "idle-timeout must be positive")
+	check(c.ShutdownTimeout > 0, "shutdown-timeout must be positive")
+	check(c.RateLimit > 0, "rate-limit must be positive")
+	check(c.RateWindow > 0, "rate-window must be positive")
+	check(!strings.ContainsAny(c.ClientIPHeader, " \t:"), "client-ip-header %q is not a header name", c.ClientIPHeader)
+	check(c.DefaultLat >= -90 && c.DefaultLat <= 90, "default-lat %v out of range -90 to 90", c.DefaultLat)
+	check(c.DefaultLon >= -180 && c.DefaultLon <= 180, "default-lon %v out of range -180 to 180", c.DefaultLon)
+	_, err := loadZone(c.DefaultTZ)
+	check(c.DefaultTZ != "" && err == nil, "default-tz %q is not a known IANA time zone", c.DefaultTZ)
+	check(c.StaticMaxAge >= 0, "static-max-age must not be negative")
+	check(c.LogStaticSample >= 0, "log-static-sample must not be negative")
+	check(c.RisetCacheSize >= 0, "riseset-cache-size must not be negative")
+	check(c.RisetCachePrecision >= 0 && c.RisetCachePrecision <= 6, "riseset-cache-precision %d out of range 0-6", c.RisetCachePrecision)
+	check(c.MapProvider == mapGoogle || c.MapProvider == mapLeaflet, "map-provider %q must be %q or %q", c.MapProvider, mapGoogle, mapLeaflet)
+	if c.MapProvider == mapLeaflet {
+		_, err := tileSource(c.TileURL)
+		check(err == nil, "tile-url %q: %v", c.TileURL, err)
+	}
+	if c.SiteURL != "" {
+		u, err := url.Parse(c.SiteURL)
+		check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" && (u.Path == "" || u.Path == "/") && u.RawQuery == "",
+			"site-url %q is not an http(s) URL without a path", c.SiteURL)
+	}
+	// Before typed config, a URL without a key silently disabled shipping;
+	// now the mismatch stops startup so the missing key is noticed.
+	check((c.MonitorURL == "") == (c.MonitorAPIKey == ""), "monitor-url and monitor-api-key must be set together")
+	check(c.CookieKey == "" || len(c.CookieKey) >= minCookieKeyLen, "cookie-key must be at least %d characters", minCookieKeyLen)
+	if c.MonitorURL != "" {
+		u, err := url.Parse(c.MonitorURL)
+		check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", "monitor-url %q is not an http(s) URL", c.MonitorURL)
+	}
+	switch c.TracesExporter {
+	case "", "none", "console":
+	case "otlp":
+		_, err := parseOTLPHeaders(c.OTLPHeaders)
+		check(err == nil, "otel-exporter-otlp-headers: %v", err)
+	default:
+		check(false, "otel-traces-exporter %q must be none, console or otlp", c.TracesExporter)
+	}
+	return errors.Join(errs...)
+}
+
+// defaultLocation returns the configured default location.
+func (c config) defaultLocation() location {
+	return location{Name: c.DefaultName, Lat: c.DefaultLat, Lon: c.DefaultLon, Zone: c.DefaultTZ}
+}
+
+// printConfig writes the effective configuration as a JSON config file,
+// with secrets redacted.
+func printConfig(w io.Writer, c config) error {
+	fs := flag.NewFlagSet("moon", flag.ContinueOnError)
+	bindConfigFlags(fs, &c)
+	out := map[string]any{}
+	fs.VisitAll(func(f *flag.Flag) {
+		v := f.Value.(flag.Getter).Get()
+		switch x := v.(type) {
+		case string:
+			if configSecrets[f.Name] && x != "" {
+				v = "REDACTED"
+			}
+		case time.Duration:
+			v = x.String()
+		}
+		out[f.Name] = v
+	})
+	b, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
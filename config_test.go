@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfigFile(t *testing.T, body string) string {
+	t.Helper()
+	return writeConfigFileNamed(t, "moon.json", body)
+}
+
+func writeConfigFileNamed(t *testing.T, name, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func envMap(m map[string]string) func(string) string {
+	return func(k string) string { return m[k] }
+}
+
+// Test that flags override env, which overrides the file, which overrides defaults
+func TestLoadConfigPrecedence(t *testing.T) {
+	path := writeConfigFile(t, `{"port": 9000, "rate-limit": 10, "read-timeout": "2s", "prod": true}`)
+	env := envMap(map[string]string{
+		"MOON_CONFIG": path,
+		"RATE_LIMIT":  "20",
+		"PROD":        "False",
+	})
+
+	c, printOnly, err := loadConfig([]string{"-rate-limit", "30"}, env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if printOnly {
+		t.Error("printOnly should be false")
+	}
+	if c.Port != 9000 || c.ReadTimeout != 2*time.Second {
+		t.Errorf("file values not applied: port=%d read-timeout=%v", c.Port, c.ReadTimeout)
+	}
+	if c.Prod {
+		t.Error("env PROD=False should override file prod=true")
+	}
+	if c.RateLimit != 30 {
+		t.Errorf("rate-limit = %d, want flag value 30", c.RateLimit)
+	}
+	if c.WriteTimeout != defaultConfig().WriteTimeout {
+		t.Errorf("write-timeout = %v, want default", c.WriteTimeout)
+	}
+}
+
+// Test flat TOML and YAML config files read the same as JSON
+func TestLoadConfigFormats(t *testing.T) {
+	files := map[string]string{
+		"moon.toml": `# moon settings
+port = 9000
+prod = true
+read-timeout = "2s" # quoted
+default-name = 'Dark site # literal'
+site-url = "https://moon.example.com"
+`,
+		"moon.yml": `---
+port: 9000
+prod: true
+read-timeout: 2s   # bare
+default-name: 'Dark site # literal'
+site-url: "https://moon.example.com"
+`,
+	}
+	for name, body := range files {
+		c, _, err := loadConfig([]string{"-config", writeConfigFileNamed(t, name, body)}, envMap(nil))
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if c.Port != 9000 || !c.Prod || c.ReadTimeout != 2*time.Second || c.DefaultName != "Dark site # literal" || c.SiteURL != "https://moon.example.com" {
+			t.Errorf("%s: got %+v", name, c)
+		}
+	}
+
+	for name, body := range map[string]string{
+		"table.toml":   "[server]\nport = 9000\n",
+		"nested.yaml":  "server:\n  port: 9000\n",
+		"list.yaml":    "- port: 9000\n",
+		"twice.toml":   "port = 1\nport = 2\n",
+		"unquote.toml": `default-name = "Home` + "\n",
+		"trail.toml":   `default-name = "Home" x` + "\n",
+	} {
+		if _, _, err := loadConfig([]string{"-config", writeConfigFileNamed(t, name, body)}, envMap(nil)); err == nil || !strings.Contains(err.Error(), "line ") {
+			t.Errorf("%s: got %v, want a line error", name, err)
+		}
+	}
+}
+
+// Test that invalid settings are all reported
+func TestLoadConfigValidation(t *testing.T) {
+	_, _, err := loadConfig([]string{"-port", "0", "-default-lat", "95", "-default-tz", "Mars/Olympus", "-monitor-url", "https://m.example", "-riseset-cache-precision", "9", "-site-url", "https://moon.example.com/cal", "-cookie-key", "short", "-client-ip-header", "CF-Connecting-IP:"}, envMap(nil))
+	if err == nil {
+		t.Fatal("expected validation error")
+	}
+	for _, want := range []string{"port 0", "default-lat 95", `default-tz "Mars/Olympus"`, "monitor-api-key must be set together", "riseset-cache-precision 9", `site-url "https://moon.example.com/cal"`, "cookie-key must be at least 32", `client-ip-header "CF-Connecting-IP:"`} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q missing %q", err, want)
+		}
+	}
+
+	path := writeConfigFile(t, `{"colour": "blue"}`)
+	if _, _, err := loadConfig([]string{"-config", path}, envMap(nil)); err == nil || !strings.Contains(err.Error(), `unknown setting "colour"`) {
+		t.Errorf("unknown file key: got %v", err)
+	}
+
+	if _, _, err := loadConfig(nil, envMap(map[string]string{"READ_TIMEOUT": "soon"})); err == nil || !strings.Contains(err.Error(), "READ_TIMEOUT") {
+		t.Errorf("bad env duration: got %v", err)
+	}
+}
+
+// Test -print-config redacts secrets and round-trips as a config file
+func TestPrintConfig(t *testing.T) {
+	c, printOnly, err := loadConfig([]string{"-print-config"}, envMap(map[string]string{
+		"GOOGLE_MAPS_API_KEY":        "AIzaSecret",
+		"MONITOR_URL":                "https://monitor.example",
+		"MONITOR_API_KEY":            "hunter2",
+		"COOKIE_KEY":                 "correct horse battery staple 0123",
+		"OTEL_TRACES_EXPORTER":       "otlp",
+		"OTEL_EXPORTER_OTLP_HEADERS": "x-api-key=otelsecret",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !printOnly {
+		t.Error("printOnly should be true")
+	}
+
+	var buf bytes.Buffer
+	if err := printConfig(&buf, c); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	if strings.Contains(out, "AIzaSecret") || strings.Contains(out, "hunter2") || strings.Contains(out, "battery") || strings.Contains(out, "otelsecret") {
+		t.Errorf("secrets leaked:\n%s", out)
+	}
+	for _, want := range []string{`"google-maps-key": "REDACTED"`, `"monitor-url": "https://monitor.example"`, `"port": 8484`, `"read-timeout": "5s"`, `"otel-traces-exporter": "otlp"`} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %s:\n%s", want, out)
+		}
+	}
+
+	// The printed config (minus redacted secrets) is itself a valid config file.
+	c.GoogleMapsKey, c.MonitorURL, c.MonitorAPIKey, c.CookieKey, c.OTLPHeaders = "", "", "", "", ""
+	buf.Reset()
+	if err := printConfig(&buf, c); err != nil {
+		t.Fatal(err)
+	}
+	path := writeConfigFile(t, buf.String())
+	if _, _, err := loadConfig([]string{"-config", path}, envMap(nil)); err != nil {
+		t.Errorf("printed config does not load: %v", err)
+	}
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// cspReportPath receives violation reports from browsers, named in both
+// report-uri (older browsers) and report-to (the Reporting API).
+const cspReportPath = "/csp-report"
+
+// newNonce returns a fresh random value for a script nonce. The URL-safe
+// alphabet is valid in CSP and passes through html/template unescaped.
+func newNonce() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// cspNonce returns the nonce securityHeaders generated for this request, or
+// "" outside the middleware chain (e.g. handlers called from tests).
+func cspNonce(ctx context.Context) string {
+	if info := requestInfoFrom(ctx); info != nil {
+		return info.Nonce
+	}
+	return ""
+}
+
+// cspPolicy holds the fetch directives that vary between routes.
+type cspPolicy struct {
+	script, style, img, font, connect, worker string
+}
+
+func (p cspPolicy) String() string {
+	directives := []string{
+		"default-src 'self'",
+		"script-src " + p.script,
+		"style-src " + p.style,
+		"img-src " + p.img,
+		"font-src " + p.font,
+		"connect-src " + p.connect,
+	}
+	if p.worker != "" {
+		directives = append(directives, "worker-src "+p.worker)
+	}
+	return strings.Join(append(directives,
+		"base-uri 'self'",
+		"form-action 'self'",
+		"frame-ancestors 'none'",
+		"report-uri "+cspReportPath,
+		"report-to csp",
+	), "; ")
+}
+
+// contentSecurityPolicy builds the policy for a request path. Every page
+// may run only its own scripts (from 'self' or carrying the nonce) plus the
+// Cloudflare analytics beacon. Only the map page gets allowances for the
+// configured map provider. For Google Maps:
+//
+//	script-src: 'unsafe-eval' + blob: + maps.googleapis.com, required by Maps.
+//	style-src:  fonts.googleapis.com, the stylesheet Maps injects at runtime,
+//	            and 'unsafe-inline' for the inline styles it sets on markers
+//	            and controls.
+//	font-src:   fonts.gstatic.com, the font files for that stylesheet.
+//	img-src:    *.googleapis.com / *.gstatic.com, tiles and icons.
+//
+// Leaflet is served from 'self' and needs only the tile server in img-src.
+func contentSecurityPolicy(path, nonce string) string {
+	p := cspPolicy{
+		script:  "'self' 'nonce-" + nonce + "' https://static.cloudflareinsights.com",
+		style:   "'self'",
+		img:     "'self' data:",
+		font:    "'self'",
+		connect: "'self' https://cloudflareinsights.com",
+	}
+	if path == "/" {
+		switch cfg.MapProvider {
+		case mapLeaflet:
+			if tiles, err := tileSource(cfg.TileURL); err == nil {
+				p.img += " " + tiles
+			}
+		default:
+			p.script += " 'unsafe-eval' blob: https://maps.googleapis.com"
+			p.style += " 'unsafe-inline' https://fonts.googleapis.com"
+			p.img += " blob: https://*.googleapis.com https://*.gstatic.com"
+			p.font += " https://fonts.gstatic.com"
+			p.connect = "'self' data: https://maps.googleapis.com https://*.gstatic.com https://cloudflareinsights.com"
+			p.worker = "blob:"
+		}
+	}
+	return p.String()
+}
+
+// maxCSPReport bounds the size of a violation report body. Reporting API
+// batches may hold several reports, but rarely more than a few KiB.
+const maxCSPReport = 64 << 10
+
+// maxCSPBatch bounds the number of reports accepted in one Reporting API
+// request.
+const maxCSPBatch = 50
+
+// cspViolation is a report normalised from either payload format.
+type cspViolation struct {
+	DocumentURL string
+	BlockedURL  string
+	Directive   string
+	Disposition string
+	SourceFile  string
+	Line        int
+	Column      int
+	Sample      string
+}
+
+// legacyCSPReport is the application/csp-report body sent for report-uri.
+type legacyCSPReport struct {
+	Report struct {
+		DocumentURI        string `json:"document-uri"`
+		BlockedURI         string `json:"blocked-uri"`
+		ViolatedDirective  string `json:"violated-directive"`
+		EffectiveDirective string `json:"effective-directive"`
+		Disposition        string `json:"disposition"`
+		SourceFile         string `json:"source-file"`
+		LineNumber         int    `json:"line-number"`
+		ColumnNumber       int    `json:"column-number"`
+		ScriptSample       string `json:"script-sample"`
+	} `json:"csp-report"`
+}
+
+// reportingAPIReport is one entry of an application/reports+json batch
+// sent for report-to.
+type reportingAPIReport struct {
+	Type string `json:"type"`
+	Body struct {
+		DocumentURL        string `json:"documentURL"`
+		BlockedURL         string `json:"blockedURL"`
+		EffectiveDirective string `json:"effectiveDirective"`
+		Disposition        string `json:"disposition"`
+		SourceFile         string `json:"sourceFile"`
+		LineNumber         int    `json:"lineNumber"`
+		ColumnNumber       int    `json:"columnNumber"`
+		Sample             string `json:"sample"`
+	} `json:"body"`
+}
+
+// parseCSPReports decodes a report body according to its Content-Type.
+// Reports that aren't CSP violations (the Reporting API carries other
+// types too) are skipped; malformed bodies are an error.
+func parseCSPReports(contentType string, body []byte) ([]cspViolation, error) {
+	switch contentType {
+	case "application/csp-report", "application/json":
+		var rep legacyCSPReport
+		if err := json.Unmarshal(body, &rep); err != nil {
+			return nil, err
+		}
+		b := rep.Report
+		directive := b.EffectiveDirective
+		if directive == "" {
+			directive = b.ViolatedDirective
+		}
+		v := cspViolation{b.DocumentURI, b.BlockedURI, directive, b.Disposition, b.SourceFile, b.LineNumber, b.ColumnNumber, b.ScriptSample}
+		if !v.valid() {
+			return nil, errors.New("missing document-uri or violated-directive")
+		}
+		return []cspViolation{v}, nil
+
+	case "application/reports+json":
+		var reps []reportingAPIReport
+		if err := json.Unmarshal(body, &reps); err != nil {
+			return nil, err
+		}
+		if len(reps) > maxCSPBatch {
+			return nil, fmt.Errorf("%d reports in one batch, limit %d", len(reps), maxCSPBatch)
+		}
+		var out []cspViolation
+		for _, rep := range reps {
+			if rep.Type != "csp-violation" {
+				continue
+			}
+			b := rep.Body
+			v := cspViolation{b.DocumentURL, b.BlockedURL, b.EffectiveDirective, b.Disposition, b.SourceFile, b.LineNumber, b.ColumnNumber, b.Sample}
+			if !v.valid() {
+				return nil, errors.New("missing documentURL or effectiveDirective")
+			}
+			out = append(out, v)
+		}
+		return out, nil
+	}
+	return nil, errUnsupportedReport
+}
+
+var errUnsupportedReport = errors.New("unsupported report content type")
+
+func (v cspViolation) valid() bool {
+	return v.DocumentURL != "" && v.Directive != ""
+}
+
+// maxReportField truncates report strings before logging, since they are
+// attacker-controlled.
+const maxReportField = 256
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return strings.ToValidUTF8(s[:n], "") + "…"
+}
+
+// key identifies repeats of the same violation. The query string is
+// dropped from the document URL, so every calendar page reporting the same
+// blocked resource counts as one.
+func (v cspViolation) key() string {
+	doc, _, _ := strings.Cut(v.DocumentURL, "?")
+	return strings.Join([]string{doc, v.BlockedURL, v.Directive, v.SourceFile, strconv.Itoa(v.Line), strconv.Itoa(v.Column)}, "\x00")
+}
+
+// reportDeduper suppresses repeats of a report within a window, so one
+// broken page viewed by many visitors doesn't flood the logs. When a
+// report is seen again after its window, the number suppressed is logged
+// with it.
+type reportDeduper struct {
+	mu     sync.Mutex
+	seen   map[string]*seenReport
+	window time.Duration
+	max    int // bound on tracked keys
+}
+
+type seenReport struct {
+	first      time.Time
+	suppressed int
+}
+
+func newReportDeduper(window time.Duration, max int) *reportDeduper {
+	return &reportDeduper{seen: make(map[string]*seenReport), window: window, max: max}
+}
+
+// cspReports de-duplicates violation reports across all requests.
+var cspReports = newReportDeduper(10*time.Minute, 1000)
+
+// observe records a report and returns whether to log it, along with how
+// many repeats were suppressed since it was last logged.
+func (d *reportDeduper) observe(key string, now time.Time) (bool, int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if s, ok := d.seen[key]; ok && now.Sub(s.first) <= d.window {
+		s.suppressed++
+		return false, 0
+	}
+	suppressed := 0
+	if s, ok := d.seen[key]; ok {
+		suppressed = s.suppressed
+	} else if len(d.seen) >= d.max {
+		// Full: drop expired entries, or everything if none have expired.
+		for k, s := range d.seen {
+			if now.Sub(s.first) > d.window {
+				delete(d.seen, k)
+			}
+		}
+		if len(d.seen) >= d.max {
+			clear(d.seen)
+		}
+	}
+	d.seen[key] = &seenReport{first: now}
+	return true, suppressed
+}
+
+// handleCSPReport collects violation reports posted by browsers, in either
+// the legacy report-uri format or as a Reporting API batch, and logs each
+// distinct violation at WARN (so it is shipped to the monitor portal).
+func handleCSPReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxCSPReport))
+	if err != nil {
+		http.Error(w, "Request Entity Too Large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	violations, err := parseCSPReports(ct, body)
+	if errors.Is(err, errUnsupportedReport) {
+		http.Error(w, "Unsupported Media Type", http.StatusUnsupportedMediaType)
+		return
+	}
+	if err != nil {
+		slog.DebugContext(r.Context(), "Rejected CSP report", "error", err)
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	for _, v := range violations {
+		log, suppressed := cspReports.observe(v.key(), now)
+		if !log {
+			continue
+		}
+		slog.WarnContext(r.Context(), "CSP violation",
+			"directive", truncate(v.Directive, maxReportField),
+			"blocked_uri", truncate(v.BlockedURL, maxReportField),
+			"document_uri", truncate(v.DocumentURL, maxReportField),
+			"source", truncate(v.SourceFile, maxReportField),
+			"line", v.Line,
+			"column", v.Column,
+			"sample", truncate(v.Sample, maxReportField),
+			"disposition", v.Disposition,
+			"suppressed", suppressed,
+			"user_agent", truncate(r.UserAgent(), maxReportField),
+		)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test each request gets its own nonce, in both the policy and the page
+func TestCSPNonce(t *testing.T) {
+	handler := makeHTTPServer().Handler
+	var nonces []string
+	for range 2 {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("GET", "/archive", nil))
+		csp := rr.Header().Get("Content-Security-Policy")
+		_, rest, ok := strings.Cut(csp, "'nonce-")
+		if !ok {
+			t.Fatalf("no nonce in policy %q", csp)
+		}
+		nonce, _, _ := strings.Cut(rest, "'")
+		if !strings.Contains(rr.Body.String(), `<script nonce="`+nonce+`"`) {
+			t.Errorf("page scripts don't carry nonce %q", nonce)
+		}
+		nonces = append(nonces, nonce)
+	}
+	if nonces[0] == nonces[1] {
+		t.Error("nonce reused across requests")
+	}
+}
+
+// Test only the map page gets the Google Maps allowances
+func TestCSPPerRoute(t *testing.T) {
+	for _, path := range []string{"/", "/about", "/archive", "/calendar"} {
+		csp := contentSecurityPolicy(path, "abc")
+		isMap := path == "/"
+		for _, maps := range []string{"'unsafe-eval'", "'unsafe-inline'", "maps.googleapis.com", "fonts.gstatic.com"} {
+			if strings.Contains(csp, maps) != isMap {
+				t.Errorf("%s: contains %s = %v, want %v", path, maps, !isMap, isMap)
+			}
+		}
+		for _, want := range []string{"'nonce-abc'", "report-uri /csp-report", "report-to csp", "frame-ancestors 'none'"} {
+			if !strings.Contains(csp, want) {
+				t.Errorf("%s: policy missing %s", path, want)
+			}
+		}
+	}
+}
+
+// useCSPReports gives the test a fresh de-duplication window.
+func useCSPReports(t *testing.T) {
+	t.Helper()
+	prev := cspReports
+	cspReports = newReportDeduper(time.Minute, 100)
+	t.Cleanup(func() { cspReports = prev })
+}
+
+func postReport(contentType, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", cspReportPath, strings.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	rr := httptest.NewRecorder()
+	handleCSPReport(rr, req)
+	return rr
+}
+
+// Test both report formats are accepted and logged
+func TestCSPReport(t *testing.T) {
+	useCSPReports(t)
+	logs := captureLogs(t)
+
+	legacy := `{"csp-report":{"document-uri":"https://moon.example/about","violated-directive":"script-src-elem","blocked-uri":"https://evil.example/x.js"}}`
+	if rr := postReport("application/csp-report", legacy); rr.Code != http.StatusNoContent {
+		t.Errorf("legacy: status %d, want 204", rr.Code)
+	}
+	batch := `[
+		{"type":"deprecation","body":{"id":"x"}},
+		{"type":"csp-violation","url":"https://moon.example/archive","body":{"documentURL":"https://moon.example/archive","effectiveDirective":"style-src-attr","blockedURL":"inline","lineNumber":12,"sample":"color: red"}}
+	]`
+	if rr := postReport("application/reports+json", batch); rr.Code != http.StatusNoContent {
+		t.Errorf("reporting API: status %d, want 204", rr.Code)
+	}
+	for _, want := range []string{"directive=script-src-elem", "blocked_uri=https://evil.example/x.js", "directive=style-src-attr", "line=12", `sample="color: red"`} {
+		if !strings.Contains(logs.String(), want) {
+			t.Errorf("logs missing %s:\n%s", want, logs)
+		}
+	}
+	if strings.Contains(logs.String(), "deprecation") {
+		t.Error("non-CSP report should be ignored")
+	}
+	if n := strings.Count(logs.String(), "level=WARN"); n != 2 {
+		t.Errorf("got %d WARN lines, want 2", n)
+	}
+}
+
+// Test malformed, oversized and unsupported reports are rejected
+func TestCSPReportRejects(t *testing.T) {
+	useCSPReports(t)
+	logs := captureLogs(t)
+	cases := []struct {
+		name, contentType, body string
+		want                    int
+	}{
+		{"not JSON", "application/csp-report", "{", http.StatusBadRequest},
+		{"missing directive", "application/csp-report", `{"csp-report":{"document-uri":"https://moon.example/"}}`, http.StatusBadRequest},
+		{"wrong type", "text/plain", "hello", http.StatusUnsupportedMediaType},
+		{"too large", "application/csp-report", `{"csp-report":{"script-sample":"` + strings.Repeat("x", maxCSPReport) + `"}}`, http.StatusRequestEntityTooLarge},
+		{"batch too large", "application/reports+json", "[" + strings.Repeat(`{"type":"x"},`, maxCSPBatch) + `{"type":"x"}]`, http.StatusBadRequest},
+	}
+	for _, c := range cases {
+		if rr := postReport(c.contentType, c.body); rr.Code != c.want {
+			t.Errorf("%s: status %d, want %d", c.name, rr.Code, c.want)
+		}
+	}
+	if strings.Contains(logs.String(), "CSP violation") {
+		t.Errorf("rejected reports were logged: %s", logs)
+	}
+
+	rr := httptest.NewRecorder()
+	handleCSPReport(rr, httptest.NewRequest("GET", cspReportPath, nil))
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET: status %d, want 405", rr.Code)
+	}
+}
+
+// Test repeats are suppressed within the window and counted afterwards
+func TestReportDeduper(t *testing.T) {
+	d := newReportDeduper(time.Minute, 2)
+	now := time.Now()
+	if ok, _ := d.observe("a", now); !ok {
+		t.Error("first report should be logged")
+	}
+	for range 3 {
+		if ok, _ := d.observe("a", now.Add(time.Second)); ok {
+			t.Error("repeat within window should be suppressed")
+		}
+	}
+	if ok, n := d.observe("a", now.Add(2*time.Minute)); !ok || n != 3 {
+		t.Errorf("after window: logged=%v suppressed=%d, want true 3", ok, n)
+	}
+
+	// The key set stays bounded.
+	d.observe("b", now)
+	d.observe("c", now)
+	if len(d.seen) > 2 {
+		t.Errorf("tracking %d keys, limit 2", len(d.seen))
+	}
+
+	// Calendar pages differing only in query string count as one.
+	v := cspViolation{DocumentURL: "https://moon.example/calendar?lat=1", Directive: "img-src"}
+	w := v
+	w.DocumentURL = "https://moon.example/calendar?lat=2"
+	if v.key() != w.key() {
+		t.Error("query string should not distinguish reports")
+	}
+}
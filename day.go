@@ -0,0 +1,403 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/exploded/riseset"
+)
+
+// dayView is a resolved /day request: a location, its zone and one local
+// date.
+type dayView struct {
+	calendarView
+	Date        time.Time // the local date, at midnight UTC
+	defaultDate bool      // no date was given, so it is today
+}
+
+// dayFromQuery resolves the day page's query parameters: the location as
+// for the calendar, and date (YYYY-MM-DD), defaulting to today there.
+func dayFromQuery(r *http.Request) dayView {
+	d := dayView{calendarView: locationFromQuery(r)}
+	date, err := time.Parse(time.DateOnly, r.URL.Query().Get("date"))
+	if err != nil {
+		now := d.localNow()
+		date = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+		d.defaultDate = true
+	}
+	d.Date = date
+	d.Year, d.Month = date.Year(), int(date.Month())
+	return d
+}
+
+// zon returns the UTC offset used for the whole day, as in the calendar:
+// the one in force at noon.
+func (d dayView) zon() float64 {
+	return d.zonAt(d.Date.Add(12 * time.Hour))
+}
+
+// bounds returns the instants at which the local day starts and ends.
+func (d dayView) bounds() (start, end time.Time) {
+	start = d.Date.Add(-time.Duration(d.zon() * float64(time.Hour)))
+	return start, start.Add(24 * time.Hour)
+}
+
+// local returns t as local wall-clock time, in UTC.
+func (d dayView) local(t time.Time) time.Time {
+	return t.Add(time.Duration(d.zon() * float64(time.Hour))).UTC()
+}
+
+// dayURL returns the day page for date at the view's location.
+func (v calendarView) dayURL(date time.Time) string {
+	q := v.query()
+	q.Set("date", date.Format(time.DateOnly))
+	return "/day?" + q.Encode()
+}
+
+// cacheControl returns the Cache-Control for responses about the day:
+// today's change at midnight, while a given date's never do and get
+// settled, which is cacheImmutable for the chart but capped for the page
+// (see cacheImmutable).
+func (d dayView) cacheControl(settled string) string {
+	switch {
+	case d.defaulted:
+		return cachePerLocation
+	case d.defaultDate:
+		return cacheFor(untilMidnight(d.localNow()))
+	}
+	return settled
+}
+
+// altSample is the Sun's and Moon's altitude at one instant.
+type altSample struct {
+	T         time.Time
+	Sun, Moon float64
+}
+
+// altitudeStep is the spacing of altitude samples through the day.
+const altitudeStep = 10 * time.Minute
+
+// altitudes samples the Sun's and Moon's altitudes through the day.
+func (d dayView) altitudes() []altSample {
+	start, end := d.bounds()
+	var out []altSample
+	for t := start; !t.After(end); t = t.Add(altitudeStep) {
+		sun, _ := sunAltAz(t, d.Lat, d.Lon)
+		moon, _ := moonAltAz(t, d.Lat, d.Lon)
+		out = append(out, altSample{T: t, Sun: sun, Moon: moon})
+	}
+	return out
+}
+
+// culmination finds the highest point of alt within the day, if it is
+// there rather than at either end: for the Moon, its transit, which it
+// skips about one day a month. Samples are refined to the second.
+func (d dayView) culmination(alt func(time.Time) float64) (time.Time, bool) {
+	start, end := d.bounds()
+	best, bestAlt := start, alt(start)
+	for t := start.Add(altitudeStep); !t.After(end); t = t.Add(altitudeStep) {
+		if a := alt(t); a > bestAlt {
+			best, bestAlt = t, a
+		}
+	}
+	if !best.After(start) || !best.Before(end) {
+		return time.Time{}, false
+	}
+	lo, hi := best.Add(-altitudeStep), best.Add(altitudeStep)
+	for hi.Sub(lo) > time.Second {
+		m1 := lo.Add(hi.Sub(lo) / 3)
+		m2 := hi.Add(-hi.Sub(lo) / 3)
+		if alt(m1) < alt(m2) {
+			lo = m1
+		} else {
+			hi = m2
+		}
+	}
+	t := lo.Add(hi.Sub(lo) / 2).Round(time.Second)
+	if !t.After(start) || !t.Before(end) {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// moonAlt and sunAlt return the Moon's and Sun's altitudes at t.
+func (d dayView) moonAlt(t time.Time) float64 {
+	alt, _ := moonAltAz(t, d.Lat, d.Lon)
+	return alt
+}
+
+func (d dayView) sunAlt(t time.Time) float64 {
+	alt, _ := sunAltAz(t, d.Lat, d.Lon)
+	return alt
+}
+
+// moonTransit returns the Moon's transit during the day, if it has one.
+func (d dayView) moonTransit() (time.Time, bool) {
+	return d.culmination(d.moonAlt)
+}
+
+// crossing finds the first time in the day that alt rises (or sets) through
+// h, refined to the second.
+func (d dayView) crossing(alt func(time.Time) float64, h float64, rising bool) (time.Time, bool) {
+	start, end := d.bounds()
+	above := alt(start) >= h
+	for t := start.Add(altitudeStep); !t.After(end); t = t.Add(altitudeStep) {
+		now := alt(t) >= h
+		if now == above {
+			continue
+		}
+		above = now
+		if now != rising {
+			continue
+		}
+		lo, hi := t.Add(-altitudeStep), t
+		for hi.Sub(lo) > time.Second {
+			mid := lo.Add(hi.Sub(lo) / 2)
+			if (alt(mid) >= h) == rising {
+				hi = mid
+			} else {
+				lo = mid
+			}
+		}
+		return hi.Round(time.Second), true
+	}
+	return time.Time{}, false
+}
+
+// eventTime converts a riseset time ("15:04", or "-" for none) on the
+// view's date to an instant.
+func (d dayView) eventTime(hm string) (time.Time, bool) {
+	t, err := time.Parse("15:04", hm)
+	if err != nil {
+		return time.Time{}, false
+	}
+	start, _ := d.bounds()
+	return start.Add(time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute), true
+}
+
+// chartEvents returns the rise, set and transit markers for the altitude
+// chart. Rise and set times are the calendar's.
+func (d dayView) chartEvents(moon, sun riseset.RiseSet) []chartEvent {
+	var out []chartEvent
+	add := func(label string, isMoon bool, t time.Time, ok bool) {
+		if ok {
+			out = append(out, chartEvent{Label: label + " " + d.local(t).Format("15:04"), T: t, Moon: isMoon})
+		}
+	}
+	t, ok := d.eventTime(moon.Rise)
+	add("Moonrise", true, t, ok)
+	t, ok = d.eventTime(moon.Set)
+	add("Moonset", true, t, ok)
+	t, ok = d.moonTransit()
+	add("Transit", true, t, ok)
+	t, ok = d.eventTime(sun.Rise)
+	add("Sunrise", false, t, ok)
+	t, ok = d.eventTime(sun.Set)
+	add("Sunset", false, t, ok)
+	return out
+}
+
+// riseSets returns the day's Moon and Sun rise and set times, as in the
+// calendar.
+func (d dayView) riseSets(ctx context.Context) (moon, sun riseset.RiseSet) {
+	zon := d.zon()
+	moon = computeRiseset(ctx, riseset.Moon, d.Date, d.Lon, d.Lat, zon)
+	sun = computeRiseset(ctx, riseset.Sun, d.Date, d.Lon, d.Lat, zon)
+	return moon, sun
+}
+
+// chart draws the day's altitude chart.
+func (d dayView) chart(r *http.Request) []byte {
+	moon, sun := d.riseSets(r.Context())
+	return altitudeChart(d.altitudes(), d.chartEvents(moon, sun), "Altitude of the Moon and Sun on "+d.Date.Format("Monday 2 January 2006"))
+}
+
+// handleAltitudeChart serves /img/altitude.svg, the altitude chart for the
+// same parameters as /day.
+func handleAltitudeChart(w http.ResponseWriter, r *http.Request) {
+	d := dayFromQuery(r)
+	etag := computedETag("altitude", d.Lat, d.Lon, d.Zone, d.zon(), d.Date.Format(time.DateOnly))
+	if notModified(w, r, etag, d.cacheControl(cacheImmutable)) {
+		return
+	}
+	w.Header().Set("Content-Type", "image/svg+xml")
+	w.Write(d.chart(r))
+}
+
+// chartURL returns the altitude chart for the view's day.
+func (d dayView) chartURL() string {
+	q := d.query()
+	q.Set("date", d.Date.Format(time.DateOnly))
+	return "/img/altitude.svg?" + q.Encode()
+}
+
+// dayEvent is a row of the day page's event tables.
+type dayEvent struct {
+	Name   string
+	Time   string // local, "15:04"
+	Detail string // where in the sky
+	t      time.Time
+}
+
+// event returns the named event at t, placed in the sky by altAz.
+func (d dayView) event(name string, t time.Time, altAz func(time.Time, float64, float64) (float64, float64), transit bool) dayEvent {
+	alt, az := altAz(t, d.Lat, d.Lon)
+	detail := fmt.Sprintf("Azimuth %.0f° %s", az, compassPoint(az))
+	if transit {
+		detail = fmt.Sprintf("Altitude %.0f°", alt)
+	}
+	return dayEvent{Name: name, Time: d.local(t).Format("15:04"), Detail: detail, t: t}
+}
+
+// moonEvents returns the Moon's rise, transit and set, in order.
+func (d dayView) moonEvents(moon riseset.RiseSet) []dayEvent {
+	var out []dayEvent
+	if t, ok := d.eventTime(moon.Rise); ok {
+		out = append(out, d.event("Moonrise", t, moonAltAz, false))
+	}
+	if t, ok := d.moonTransit(); ok {
+		out = append(out, d.event("Transit", t, moonAltAz, true))
+	}
+	if t, ok := d.eventTime(moon.Set); ok {
+		out = append(out, d.event("Moonset", t, moonAltAz, false))
+	}
+	sortEvents(out)
+	return out
+}
+
+// sunEvents returns the Sun's rise, noon and set and the morning and
+// evening twilights, in order. Twilights begin and end where the sky bands
+// of the altitude chart change.
+func (d dayView) sunEvents(sun riseset.RiseSet) []dayEvent {
+	var out []dayEvent
+	if t, ok := d.eventTime(sun.Rise); ok {
+		out = append(out, d.event("Sunrise", t, sunAltAz, false))
+	}
+	if t, ok := d.culmination(d.sunAlt); ok {
+		out = append(out, d.event("Solar noon", t, sunAltAz, true))
+	}
+	if t, ok := d.eventTime(sun.Set); ok {
+		out = append(out, d.event("Sunset", t, sunAltAz, false))
+	}
+	for _, tw := range twilights[1:4] {
+		kind := strings.TrimSuffix(tw.name, " twilight")
+		if t, ok := d.crossing(d.sunAlt, tw.alt, true); ok {
+			out = append(out, d.event(kind+" dawn", t, sunAltAz, false))
+		}
+		if t, ok := d.crossing(d.sunAlt, tw.alt, false); ok {
+			out = append(out, d.event(kind+" dusk", t, sunAltAz, false))
+		}
+	}
+	sortEvents(out)
+	return out
+}
+
+func sortEvents(events []dayEvent) {
+	slices.SortFunc(events, func(a, b dayEvent) int { return a.t.Compare(b.t) })
+}
+
+// upAllDay describes a body that neither rises nor sets, or returns "".
+func upAllDay(body string, rs riseset.RiseSet) string {
+	switch {
+	case rs.AlwaysAbove:
+		return "The " + body + " is up all day."
+	case rs.AlwaysBelow:
+		return "The " + body + " is down all day."
+	}
+	return ""
+}
+
+// compassPoint names the nearest of the 16 compass points to azimuth az.
+func compassPoint(az float64) string {
+	points := [...]string{"N", "NNE", "NE", "ENE", "E", "ESE", "SE", "SSE", "S", "SSW", "SW", "WSW", "W", "WNW", "NW", "NNW"}
+	return points[int(math.Round(norm360(az)/22.5))%16]
+}
+
+// handleDay serves /day, the Moon and Sun over one day at one location.
+func handleDay(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Add("Vary", "Cookie") // saved locations name and default the location
+	d := dayFromQuery(r)
+	site := siteURL()
+	etag := computedETag("day", d.Name, d.Place, d.Lat, d.Lon, d.Zone, d.zon(), d.Date.Format(time.DateOnly), site)
+	if notModified(w, r, etag, d.cacheControl(cacheFor(maxDynamicAge))) {
+		return
+	}
+
+	// Previous / next day navigation; AddDate handles month and year
+	// rollovers.
+	prev, next := d.Date.AddDate(0, 0, -1), d.Date.AddDate(0, 0, 1)
+
+	start, end := d.bounds()
+	noon := start.Add(12 * time.Hour)
+	lit := moonIllumination(noon)
+	_, _, dist := moonPosition(noon)
+	moon, sun := d.riseSets(r.Context())
+
+	data := struct {
+		Name         string
+		Lat, Lon     float64
+		Zone         string
+		Zon          float64
+		DateLong     string
+		Title        string
+		Description  string
+		Canonical    string
+		PrevURL      string
+		NextURL      string
+		ChartURL     string
+		CalendarURL  string
+		MonthName    string
+		Year         int
+		Phase        string
+		PhaseImg     string
+		Illumination int
+		Distance     string
+		MoonEvents   []dayEvent
+		SunEvents    []dayEvent
+		MoonNote     string
+		SunNote      string
+	}{
+		Name:         d.Name,
+		Lat:          d.Lat,
+		Lon:          d.Lon,
+		Zone:         d.Zone,
+		Zon:          d.zon(),
+		DateLong:     d.Date.Format("Monday 2 January 2006"),
+		Canonical:    site + d.dayURL(d.Date),
+		PrevURL:      d.dayURL(prev),
+		NextURL:      d.dayURL(next),
+		ChartURL:     d.chartURL(),
+		CalendarURL:  d.monthURL(d.Date.Year(), int(d.Date.Month())),
+		MonthName:    d.Date.Month().String(),
+		Year:         d.Date.Year(),
+		Phase:        dayPhase(start, end),
+		PhaseImg:     phaseImageURL(d.Date, d.Lat),
+		Illumination: int(math.Round(lit * 100)),
+		Distance:     fmt.Sprintf("%d,%03d km", int(dist)/1000, int(dist)%1000),
+		MoonEvents:   d.moonEvents(moon),
+		SunEvents:    d.sunEvents(sun),
+		MoonNote:     upAllDay("Moon", moon),
+		SunNote:      upAllDay("Sun", sun),
+	}
+	where := d.Name
+	if where == "" {
+		where = coordLabel(d.Lat, d.Lon)
+	}
+	if data.SunNote == "" && !slices.ContainsFunc(d.altitudes(), func(s altSample) bool { return s.Sun < twilights[3].alt }) {
+		data.SunNote = "The sky is never fully dark."
+	}
+	data.Title = fmt.Sprintf("Moon and sun on %s, %s", data.DateLong, where)
+	data.Description = fmt.Sprintf("Moonrise, moonset, sunrise, sunset and twilight times for %s on %s. %s.",
+		where, data.DateLong, phaseLabel(start, end, lit))
+	if err := executeTemplate(r.Context(), w, "day.html", data); err != nil {
+		slog.ErrorContext(r.Context(), "Error executing day template", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test the day's samples, transit and chart markers
+func TestDayChart(t *testing.T) {
+	d := dayFromQuery(httptest.NewRequest("GET", "/day?lat=-37.81&lon=144.96&tz=Australia/Melbourne&date=2026-10-18", nil))
+	if d.defaultDate || d.zon() != 11 {
+		t.Fatalf("dayFromQuery = %+v, zon %v", d, d.zon())
+	}
+	start, end := d.bounds()
+	if want := time.Date(2026, 10, 17, 13, 0, 0, 0, time.UTC); !start.Equal(want) || end.Sub(start) != 24*time.Hour {
+		t.Errorf("bounds = %v, %v", start, end)
+	}
+	samples := d.altitudes()
+	if len(samples) != 145 || !samples[0].T.Equal(start) || !samples[144].T.Equal(end) {
+		t.Fatalf("altitudes: %d samples from %v", len(samples), samples[0].T)
+	}
+
+	// The Moon, five days past new, transits in the evening, high in the
+	// north.
+	transit, ok := d.moonTransit()
+	if !ok || d.local(transit).Hour() != 19 {
+		t.Errorf("moonTransit = %v, %v", d.local(transit), ok)
+	}
+	if alt, az := moonAltAz(transit, d.Lat, d.Lon); alt < 30 || az > 30 && az < 330 {
+		t.Errorf("Moon at transit: alt %.1f az %.1f", alt, az)
+	}
+
+	rr := httptest.NewRecorder()
+	handleAltitudeChart(rr, httptest.NewRequest("GET", d.chartURL(), nil))
+	body := rr.Body.String()
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "image/svg+xml" || rr.Header().Get("Cache-Control") != cacheImmutable {
+		t.Fatalf("chart = %d %v", rr.Code, rr.Header())
+	}
+	for _, want := range []string{
+		"<title>Altitude of the Moon and Sun on Sunday 18 October 2026</title>",
+		"<title>Night</title>", "<title>Civil twilight</title>", "<title>Daylight</title>",
+		">Sunrise 06:31<", ">Sunset 19:40<", ">Transit 19:",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("chart missing %q", want)
+		}
+	}
+	if n := strings.Count(body, "<polyline"); n != 2 {
+		t.Errorf("chart has %d curves, want 2", n)
+	}
+}
+
+// Test days of midnight sun have no twilight bands or sunrise
+func TestDayChartPolar(t *testing.T) {
+	rr := httptest.NewRecorder()
+	handleAltitudeChart(rr, httptest.NewRequest("GET", "/img/altitude.svg?lat=69.65&lon=18.96&tz=Europe/Oslo&date=2026-06-21", nil))
+	body := rr.Body.String()
+	if strings.Count(body, "</rect>") != 1 || !strings.Contains(body, "<title>Daylight</title>") || strings.Contains(body, "Sunrise") {
+		t.Errorf("midnight sun chart: %s", body)
+	}
+}
+
+// Test the day page and the calendar's links to it
+func TestDayPage(t *testing.T) {
+	rr := httptest.NewRecorder()
+	handleDay(rr, httptest.NewRequest("GET", "/day?place=Melbourne,AU&date=2026-10-18", nil))
+	body := rr.Body.String()
+	if rr.Code != http.StatusOK {
+		t.Fatalf("day = %d", rr.Code)
+	}
+	for _, want := range []string{
+		"Sunday 18 October 2026",
+		`<img src="/img/altitude.svg?date=2026-10-18&amp;place=Melbourne%2CAU&amp;tz=Australia%2FMelbourne"`,
+		`href="/calendar?month=10&amp;place=Melbourne%2CAU&amp;tz=Australia%2FMelbourne&amp;year=2026"`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("day page missing %q", want)
+		}
+	}
+
+	// Without a date it is today there, until midnight.
+	rr = httptest.NewRecorder()
+	handleDay(rr, httptest.NewRequest("GET", "/day?lat=-37.81&lon=144.96&tz=Australia/Melbourne", nil))
+	if cc := rr.Header().Get("Cache-Control"); cc == cacheImmutable {
+		t.Errorf("today's page Cache-Control = %q", cc)
+	}
+
+	rr = httptest.NewRecorder()
+	calendar(rr, httptest.NewRequest("GET", "/calendar?lat=-37.81&lon=144.96&tz=Australia/Melbourne&year=2026&month=10", nil))
+	link := regexp.MustCompile(`<a class="day-link" href="([^"]*)">18-10-2026</a>`).FindStringSubmatch(rr.Body.String())
+	if link == nil || link[1] != "/day?date=2026-10-18&amp;lat=-37.81&amp;lon=144.96&amp;tz=Australia%2FMelbourne" {
+		t.Errorf("calendar day link = %q", link)
+	}
+}
+
+// Test the day page's events, twilights and notes
+func TestDayEvents(t *testing.T) {
+	get := func(url string) string {
+		rr := httptest.NewRecorder()
+		handleDay(rr, httptest.NewRequest("GET", url, nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("%s = %d", url, rr.Code)
+		}
+		return rr.Body.String()
+	}
+	names := func(body string) []string {
+		var out []string
+		for _, m := range regexp.MustCompile(`<td>([A-Z][a-z]+(?: [a-z]+)?)</td>\s*<td>\d\d:\d\d</td>`).FindAllStringSubmatch(body, -1) {
+			out = append(out, m[1])
+		}
+		return out
+	}
+
+	body := get("/day?lat=-37.81&lon=144.96&tz=Australia/Melbourne&date=2026-10-18")
+	want := []string{
+		"Moonset", "Moonrise", "Transit",
+		"Astronomical dawn", "Nautical dawn", "Civil dawn", "Sunrise", "Solar noon",
+		"Sunset", "Civil dusk", "Nautical dusk", "Astronomical dusk",
+	}
+	if got := names(body); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("events = %q, want %q", got, want)
+	}
+	if !regexp.MustCompile(`<td>Sunrise</td>\s*<td>06:31</td>\s*<td>Azimuth 103° ESE</td>`).MatchString(body) {
+		t.Error("day page missing sunrise time and azimuth")
+	}
+	for _, want := range []string{
+		"<strong>Waxing crescent</strong>",
+		"44% illuminated",
+		" km away at noon",
+		`<img src="/img/phase.svg?date=2026-10-18&amp;lat=-37.81"`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("day page missing %q", want)
+		}
+	}
+
+	// Midsummer in London has no astronomical night; in Tromsø no sunset.
+	body = get("/day?lat=51.5&lon=0&tz=Europe/London&date=2026-06-21")
+	if strings.Contains(body, "Astronomical") || !strings.Contains(body, "The sky is never fully dark.") {
+		t.Errorf("London midsummer events = %q", names(body))
+	}
+	body = get("/day?lat=69.65&lon=18.96&tz=Europe/Oslo&date=2026-06-21")
+	if strings.Contains(body, "Sunset") || !strings.Contains(body, "The Sun is up all day.") {
+		t.Errorf("Tromsø midsummer events = %q", names(body))
+	}
+}
+
+// Test day navigation crosses month and year boundaries
+func TestDayNavigation(t *testing.T) {
+	rr := httptest.NewRecorder()
+	handleDay(rr, httptest.NewRequest("GET", "/day?lat=-37.81&lon=144.96&tz=Australia/Melbourne&date=2026-12-31", nil))
+	body := rr.Body.String()
+	for _, want := range []string{
+		`href="/day?date=2026-12-30&amp;lat=-37.81&amp;lon=144.96&amp;tz=Australia%2FMelbourne" rel="prev"`,
+		`href="/day?date=2027-01-01&amp;lat=-37.81&amp;lon=144.96&amp;tz=Australia%2FMelbourne" rel="next"`,
+		`<link rel="canonical" href="/day?date=2026-12-31&amp;lat=-37.81&amp;lon=144.96&amp;tz=Australia%2FMelbourne">`,
+		">December 2026 calendar</a>",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("day page missing %q", want)
+		}
+	}
+}
+
+// Test azimuths name the nearest compass point
+func TestCompassPoint(t *testing.T) {
+	for az, want := range map[float64]string{0: "N", 11: "N", 12: "NNE", 90: "E", 200: "SSW", 348.75: "N", 359: "N", -45: "NW"} {
+		if got := compassPoint(az); got != want {
+			t.Errorf("compassPoint(%v) = %s, want %s", az, got, want)
+		}
+	}
+}
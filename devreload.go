@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"html/template"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// devTemplates holds the most recent result of parsing the templates
+// directory in dev mode. A failed parse keeps the error (and drops the
+// stale templates) so the problem is shown rather than silently ignored.
+var devTemplates struct {
+	sync.RWMutex
+	t   *template.Template
+	bas string
+	err error
+}
+
+// reloadTemplates re-parses the templates and riset.bas from fsys into
+// devTemplates, logging the outcome.
+func reloadTemplates(fsys fs.FS) {
+	t, err := parseTemplates(fsys)
+	var bas []byte
+	if err == nil {
+		bas, err = fs.ReadFile(fsys, "templates/riset.bas")
+	}
+	devTemplates.Lock()
+	devTemplates.t, devTemplates.bas, devTemplates.err = t, string(bas), err
+	devTemplates.Unlock()
+	if err != nil {
+		slog.Error("template reload failed", "error", err)
+	} else {
+		slog.Info("templates reloaded")
+	}
+}
+
+// templatesSignature summarises the names, sizes and modification times of
+// everything in the templates directory, so a change to any of them (or a
+// file being added or removed) is noticed.
+func templatesSignature(fsys fs.FS) string {
+	entries, err := fs.ReadDir(fsys, "templates")
+	if err != nil {
+		return "error: " + err.Error()
+	}
+	var b strings.Builder
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		fmt.Fprintf(&b, "%s:%d:%d;", e.Name(), info.Size(), info.ModTime().UnixNano())
+	}
+	return b.String()
+}
+
+// templatePollInterval is how often -dev checks the templates for changes.
+const templatePollInterval = 500 * time.Millisecond
+
+// watchTemplates polls the templates directory every interval and reloads
+// when anything changes, until ctx is cancelled. Polling keeps this to the
+// standard library; the directory is small, so the cost is negligible.
+// Call reloadTemplates once before starting it.
+func watchTemplates(ctx context.Context, fsys fs.FS, interval time.Duration) {
+	last := templatesSignature(fsys)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if sig := templatesSignature(fsys); sig != last {
+				last = sig
+				reloadTemplates(fsys)
+			}
+		}
+	}
+}
+
+// writeTemplateError writes an error report in place of a page while the
+// templates fail to parse in dev mode, so the mistake is visible in the
+// browser. executeTemplate calls it, so only pages are affected: JSON,
+// images and static files are served as usual.
+func writeTemplateError(w http.ResponseWriter, err error) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Del("ETag")
+	w.WriteHeader(http.StatusInternalServerError)
+	fmt.Fprintf(w, `<!DOCTYPE html>
+<html lang="en">
+<head><meta charset="utf-8"><title>Template error</title><link rel="stylesheet" href="/static/styles.css"></head>
+<body class="about-page"><div class="container"><main><div class="page-content"><div class="card"><div class="card-content">
+<h2>Template error</h2>
+<pre>%s</pre>
+<p>Fix the template and save; the templates are checked for changes twice a second, so refresh this page once saved.</p>
+</div></div></div></main></div></body>
+</html>
+`, html.EscapeString(err.Error()))
+}
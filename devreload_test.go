@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// useDevTemplates switches to dev mode for the duration of the test.
+func useDevTemplates(t *testing.T) {
+	t.Helper()
+	prev := cfg.Dev
+	cfg.Dev = true
+	t.Cleanup(func() {
+		cfg.Dev = prev
+		devTemplates.Lock()
+		devTemplates.t, devTemplates.bas, devTemplates.err = nil, "", nil
+		devTemplates.Unlock()
+	})
+}
+
+// Test that a template parse error is shown in the browser, then cleared
+func TestDevTemplateReload(t *testing.T) {
+	useDevTemplates(t)
+	fsys := fstest.MapFS{
+		"templates/about.html": {Data: []byte(`{{define "about.html"}}broken {{.Missing`), ModTime: time.Unix(1, 0)},
+		"templates/riset.bas":  {Data: []byte("REM")},
+	}
+	reloadTemplates(fsys)
+
+	handler := makeHTTPServer().Handler
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/about", nil))
+	if rr.Code != http.StatusInternalServerError || !strings.Contains(rr.Body.String(), "Template error") || rr.Header().Get("Cache-Control") != "no-store" {
+		t.Fatalf("broken template: got %d %v %q", rr.Code, rr.Header(), rr.Body.String())
+	}
+	if strings.Contains(rr.Body.String(), "{{.Missing") {
+		t.Error("error report should be HTML-escaped")
+	}
+	// Routes without a template are served as usual.
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/api/timezones?date=2026-01-15", nil))
+	if rr.Code != http.StatusOK || !strings.HasPrefix(rr.Header().Get("Content-Type"), "application/json") {
+		t.Errorf("/api/timezones with broken templates: got %d %v", rr.Code, rr.Header())
+	}
+
+	before := templatesSignature(fsys)
+	fsys["templates/about.html"] = &fstest.MapFile{Data: []byte(`{{define "about.html"}}About page{{end}}`), ModTime: time.Unix(2, 0)}
+	if templatesSignature(fsys) == before {
+		t.Fatal("signature should change when a template changes")
+	}
+	reloadTemplates(fsys)
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/about", nil))
+	if rr.Code != http.StatusOK || rr.Body.String() != "About page" {
+		t.Errorf("fixed template: got %d %q", rr.Code, rr.Body.String())
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// version identifies the build. The deploy workflow sets it to the commit
+// with -ldflags "-X main.version=...", so every deploy invalidates the
+// ETags of computed pages (whose markup may have changed).
+var version = "dev"
+
+// computedETag derives a strong entity tag for a computed response from the
+// route, the app version and the normalised request parameters. Equal
+// inputs always produce the same tag, so repeat requests can be answered
+// with 304 Not Modified before any rise/set times are computed.
+func computedETag(route string, params ...any) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s", version, route)
+	for _, p := range params {
+		if f, ok := p.(float64); ok {
+			p = strconv.FormatFloat(f, 'g', -1, 64)
+		}
+		fmt.Fprintf(h, "\x00%v", p)
+	}
+	return `"` + hex.EncodeToString(h.Sum(nil)[:8]) + `"`
+}
+
+// etagMatch reports whether an If-None-Match header matches etag, returning
+// the tag the client sent. Tags carrying a content-coding suffix (added by
+// compressResponses) match their uncompressed original, and weak tags
+// compare equal to strong ones, as RFC 9110 requires for If-None-Match.
+func etagMatch(header, etag string) (string, bool) {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" {
+			return etag, true
+		}
+		base := strings.TrimPrefix(tag, "W/")
+		for _, enc := range []string{encodingBrotli, encodingGzip} {
+			base = strings.Replace(base, "-"+enc+`"`, `"`, 1)
+		}
+		if base == etag {
+			return tag, true
+		}
+	}
+	return "", false
+}
+
+// notModified sets the ETag and Cache-Control headers for a computed
+// response and, if the request's If-None-Match matches, writes 304 and
+// returns true so the handler can stop. In dev mode templates change
+// without the version changing, so nothing is cached.
+func notModified(w http.ResponseWriter, r *http.Request, etag, cacheControl string) bool {
+	if cfg.Dev {
+		w.Header().Set("Cache-Control", "no-cache")
+		return false
+	}
+	w.Header().Set("Cache-Control", cacheControl)
+	w.Header().Set("ETag", etag)
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	if tag, ok := etagMatch(r.Header.Get("If-None-Match"), etag); ok {
+		// Echo the tag the client holds, which may be an encoded variant.
+		w.Header().Set("ETag", strings.TrimPrefix(tag, "W/"))
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+	return false
+}
+
+// Cache-Control values for computed responses. Responses resolved from the
+// caller's IP (GeoIP default location) must not be shared by a CDN.
+// cacheImmutable is only for images: HTML pages link content-hashed assets
+// that the next deploy removes, so even pages that never change are kept
+// for at most maxDynamicAge.
+const (
+	cacheImmutable   = "public, max-age=31536000, immutable"
+	cachePerLocation = "private, no-cache"
+)
+
+// maxDynamicAge caps how long a shared cache may keep an HTML page, or a
+// response that will eventually change, so a deploy is picked up within a
+// day.
+const maxDynamicAge = 24 * time.Hour
+
+// cacheFor returns a public Cache-Control value lasting d, capped at
+// maxDynamicAge and at least one minute.
+func cacheFor(d time.Duration) string {
+	d = min(max(d, time.Minute), maxDynamicAge)
+	return "public, max-age=" + strconv.Itoa(int(d.Seconds()))
+}
+
+// untilMidnight returns the time from local until the next midnight, where
+// local is a wall-clock time expressed in UTC (as the handlers compute it).
+func untilMidnight(local time.Time) time.Duration {
+	y, m, d := local.Date()
+	return time.Date(y, m, d+1, 0, 0, 0, 0, time.UTC).Sub(local)
+}
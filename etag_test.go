@@ -0,0 +1,143 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestETagMatch(t *testing.T) {
+	etag := `"abc123"`
+	cases := map[string]bool{
+		``:                  false,
+		`"abc123"`:          true,
+		`W/"abc123"`:        true,
+		`"other", "abc123"`: true,
+		`"abc123-gzip"`:     true,
+		`"abc123-br"`:       true,
+		`"abc12"`:           false,
+		`"abc123-deflate"`:  false,
+		`*`:                 true,
+	}
+	for header, want := range cases {
+		if _, got := etagMatch(header, etag); got != want {
+			t.Errorf("etagMatch(%q) = %v, want %v", header, got, want)
+		}
+	}
+}
+
+// Test the ETag covers the parameters and app version
+func TestComputedETag(t *testing.T) {
+	a := computedETag("calendar", 144.0, -37.0, 10.0, 2020, 1)
+	if a != computedETag("calendar", 144.0, -37.0, 10.0, 2020, 1) {
+		t.Error("ETag should be deterministic")
+	}
+	if a == computedETag("calendar", 144.0, -37.0, 10.0, 2020, 2) {
+		t.Error("ETag should change with the parameters")
+	}
+	prev := version
+	version = "other"
+	defer func() { version = prev }()
+	if a == computedETag("calendar", 144.0, -37.0, 10.0, 2020, 1) {
+		t.Error("ETag should change with the version")
+	}
+}
+
+// Test a past month is cacheable forever and revalidates with 304
+func TestCalendarNotModified(t *testing.T) {
+	url := "/calendar?lon=144&lat=-37&zon=10&year=2020&month=1"
+	rr := httptest.NewRecorder()
+	calendar(rr, httptest.NewRequest("GET", url, nil))
+	etag := rr.Header().Get("ETag")
+	if rr.Code != http.StatusOK || etag == "" {
+		t.Fatalf("status %d, ETag %q", rr.Code, etag)
+	}
+	if got, want := rr.Header().Get("Cache-Control"), cacheFor(maxDynamicAge); got != want {
+		t.Errorf("past month Cache-Control = %q, want %q", got, want)
+	}
+
+	req := httptest.NewRequest("GET", url, nil)
+	req.Header.Set("If-None-Match", etag)
+	rr = httptest.NewRecorder()
+	calendar(rr, req)
+	if rr.Code != http.StatusNotModified || rr.Body.Len() != 0 {
+		t.Errorf("conditional request: status %d, %d byte body", rr.Code, rr.Body.Len())
+	}
+
+	// The tag compressResponses hands out for a gzipped copy also matches.
+	req = httptest.NewRequest("GET", url, nil)
+	req.Header.Set("If-None-Match", strings.TrimSuffix(etag, `"`)+`-gzip"`)
+	rr = httptest.NewRecorder()
+	calendar(rr, req)
+	if rr.Code != http.StatusNotModified {
+		t.Errorf("gzip variant tag: status %d, want 304", rr.Code)
+	}
+
+	req = httptest.NewRequest("GET", "/calendar?lon=144&lat=-37&zon=10&year=2020&month=2", nil)
+	req.Header.Set("If-None-Match", etag)
+	rr = httptest.NewRecorder()
+	calendar(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("different month: status %d, want 200", rr.Code)
+	}
+}
+
+// Test the current and future months expire, and defaulted locations stay private
+func TestCalendarCacheControl(t *testing.T) {
+	now := time.Now().UTC()
+	next := now.AddDate(0, 2, 0)
+	cases := []struct {
+		url  string
+		want string
+	}{
+		{"/calendar?lon=0&lat=51&zon=0", "public, max-age="},
+		{"/calendar?lon=0&lat=51&zon=0&year=" + next.Format("2006") + "&month=" + next.Format("1"), "public, max-age="},
+		{"/calendar?year=2020&month=1", cachePerLocation},
+	}
+	for _, c := range cases {
+		rr := httptest.NewRecorder()
+		calendar(rr, httptest.NewRequest("GET", c.url, nil))
+		got := rr.Header().Get("Cache-Control")
+		if !strings.HasPrefix(got, c.want) || strings.Contains(got, "immutable") {
+			t.Errorf("%s: Cache-Control = %q, want prefix %q", c.url, got, c.want)
+		}
+	}
+}
+
+// Test the JSON API answers conditional requests for the same day
+func TestGettimesNotModified(t *testing.T) {
+	url := "/gettimes?lon=144&lat=-37&zon=10"
+	rr := httptest.NewRecorder()
+	gettimes(rr, httptest.NewRequest("GET", url, nil))
+	etag := rr.Header().Get("ETag")
+	if etag == "" || !strings.HasPrefix(rr.Header().Get("Cache-Control"), "public, max-age=") {
+		t.Fatalf("headers = %v", rr.Header())
+	}
+	req := httptest.NewRequest("GET", url, nil)
+	req.Header.Set("If-None-Match", etag)
+	rr = httptest.NewRecorder()
+	gettimes(rr, req)
+	if rr.Code != http.StatusNotModified {
+		t.Errorf("status %d, want 304", rr.Code)
+	}
+
+	// Errors are never tagged.
+	rr = httptest.NewRecorder()
+	gettimes(rr, httptest.NewRequest("GET", "/gettimes?lon=144", nil))
+	if rr.Header().Get("ETag") != "" {
+		t.Error("error response should not carry an ETag")
+	}
+}
+
+// Test dev mode disables conditional responses
+func TestNotModifiedDev(t *testing.T) {
+	useDevTemplates(t)
+	reloadTemplates(embeddedFS)
+	rr := httptest.NewRecorder()
+	calendar(rr, httptest.NewRequest("GET", "/calendar?lon=144&lat=-37&zon=10&year=2020&month=1", nil))
+	if rr.Header().Get("ETag") != "" || rr.Header().Get("Cache-Control") != "no-cache" {
+		t.Errorf("headers = %v", rr.Header())
+	}
+}
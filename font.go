@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"embed"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"strings"
+	"sync"
+)
+
+//go:generate go run -C scripts/genfont . -out ../../data/fonts
+
+// fontFiles holds the bitmap fonts written by scripts/genfont: for each, a
+// PNG atlas of glyphs and a text file of their metrics.
+//
+//go:embed data/fonts
+var fontFiles embed.FS
+
+// bitmapFont is an antialiased font pre-rendered at one size, drawn with
+// the standard image packages alone.
+type bitmapFont struct {
+	atlas           *image.Alpha
+	glyphs          map[rune]bitmapGlyph
+	ascent, descent int
+}
+
+// bitmapGlyph is one glyph's box in the atlas, its offset from the pen on
+// the baseline, and how far it moves the pen, in 1/64 pixel.
+type bitmapGlyph struct {
+	box       image.Rectangle
+	left, top int
+	advance   int
+}
+
+// Fonts for the social cards, loaded on first use.
+var (
+	fontTitle = sync.OnceValue(func() *bitmapFont { return mustLoadFont("go-bold-60") })
+	fontBody  = sync.OnceValue(func() *bitmapFont { return mustLoadFont("go-regular-36") })
+	fontSmall = sync.OnceValue(func() *bitmapFont { return mustLoadFont("go-regular-26") })
+)
+
+func mustLoadFont(name string) *bitmapFont {
+	f, err := loadFont(name)
+	if err != nil {
+		panic(err)
+	}
+	return f
+}
+
+// loadFont reads the named font from fontFiles.
+func loadFont(name string) (*bitmapFont, error) {
+	pngData, err := fontFiles.ReadFile("data/fonts/" + name + ".png")
+	if err != nil {
+		return nil, err
+	}
+	img, err := png.Decode(bytes.NewReader(pngData))
+	if err != nil {
+		return nil, fmt.Errorf("font %s: %w", name, err)
+	}
+	gray, ok := img.(*image.Gray)
+	if !ok {
+		return nil, fmt.Errorf("font %s: atlas is %T, want grayscale", name, img)
+	}
+	// The atlas stores coverage as gray levels; as alpha it can be used
+	// directly as a mask.
+	f := &bitmapFont{
+		atlas:  &image.Alpha{Pix: gray.Pix, Stride: gray.Stride, Rect: gray.Rect},
+		glyphs: map[rune]bitmapGlyph{},
+	}
+
+	txt, err := fontFiles.ReadFile("data/fonts/" + name + ".txt")
+	if err != nil {
+		return nil, err
+	}
+	sc := bufio.NewScanner(bytes.NewReader(txt))
+	haveMetrics := false
+	for line := 1; sc.Scan(); line++ {
+		s := sc.Text()
+		if s == "" || strings.HasPrefix(s, "#") {
+			continue
+		}
+		if !haveMetrics {
+			if _, err := fmt.Sscan(s, &f.ascent, &f.descent); err != nil {
+				return nil, fmt.Errorf("font %s line %d: %w", name, line, err)
+			}
+			haveMetrics = true
+			continue
+		}
+		var r rune
+		var x, y, w, h int
+		var g bitmapGlyph
+		if _, err := fmt.Sscan(s, &r, &x, &y, &w, &h, &g.left, &g.top, &g.advance); err != nil {
+			return nil, fmt.Errorf("font %s line %d: %w", name, line, err)
+		}
+		g.box = image.Rect(x, y, x+w, y+h)
+		if !g.box.In(f.atlas.Rect) {
+			return nil, fmt.Errorf("font %s line %d: glyph %q outside the atlas", name, line, r)
+		}
+		f.glyphs[r] = g
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if _, ok := f.glyphs['?']; !ok {
+		return nil, fmt.Errorf("font %s: no glyph for '?'", name)
+	}
+	return f, nil
+}
+
+// glyph returns the glyph for r, or '?' if the font doesn't have it.
+func (f *bitmapFont) glyph(r rune) bitmapGlyph {
+	if g, ok := f.glyphs[r]; ok {
+		return g
+	}
+	return f.glyphs['?']
+}
+
+// width returns the width of s in pixels.
+func (f *bitmapFont) width(s string) int {
+	adv := 0
+	for _, r := range s {
+		adv += f.glyph(r).advance
+	}
+	return (adv + 63) / 64
+}
+
+// fit returns s, shortened with an ellipsis if needed to fit in width
+// pixels.
+func (f *bitmapFont) fit(s string, width int) string {
+	if f.width(s) <= width {
+		return s
+	}
+	rs := []rune(s)
+	for len(rs) > 0 {
+		rs = rs[:len(rs)-1]
+		t := strings.TrimRight(string(rs), " ,") + "…"
+		if f.width(t) <= width {
+			return t
+		}
+	}
+	return ""
+}
+
+// draw draws s in colour c with the pen starting at x on baseline y.
+func (f *bitmapFont) draw(dst draw.Image, x, y int, s string, c color.Color) {
+	src := image.NewUniform(c)
+	pen := x * 64
+	for _, r := range s {
+		g := f.glyph(r)
+		at := image.Pt((pen+32)/64+g.left, y+g.top)
+		draw.DrawMask(dst, image.Rectangle{at, at.Add(g.box.Size())}, src, image.Point{}, f.atlas, g.box.Min, draw.Over)
+		pen += g.advance
+	}
+}
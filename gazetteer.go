@@ -0,0 +1,296 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// placesCSV is the bundled gazetteer: about 10,800 cities from the public
+// domain github.com/tidwall/cities list, each with the IANA zone its
+// coordinates fall in, plus the principal city of every zone in zone.tab.
+// Rows are ordered by population within each country, so earlier rows are
+// generally the better-known places; search uses that order to break ties.
+//
+//go:embed data/places.csv
+var placesCSV string
+
+// place is one gazetteer entry.
+type place struct {
+	Name        string
+	CountryCode string
+	Country     string
+	Lat, Lon    float64
+	Zone        string
+
+	key string // folded Name for searching; see searchKey
+}
+
+// location returns the place as a location named "Name, Country".
+func (p place) location() location {
+	return location{Name: p.Name + ", " + p.Country, Lat: p.Lat, Lon: p.Lon, Zone: p.Zone}
+}
+
+// places is parsed from placesCSV at startup.
+var places = mustParsePlaces(placesCSV)
+
+func mustParsePlaces(data string) []place {
+	ps, err := parsePlaces(data)
+	if err != nil {
+		panic("failed to parse gazetteer: " + err.Error())
+	}
+	return ps
+}
+
+// parsePlaces reads the gazetteer CSV, which has a header row followed by
+// name,country_code,country,lat,lon,zone.
+func parsePlaces(data string) ([]place, error) {
+	recs, err := csv.NewReader(strings.NewReader(data)).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(recs) == 0 {
+		return nil, nil
+	}
+	ps := make([]place, 0, len(recs)-1)
+	for i, rec := range recs[1:] {
+		if len(rec) != 6 {
+			return nil, fmt.Errorf("line %d: want 6 fields, got %d", i+2, len(rec))
+		}
+		lat, err1 := strconv.ParseFloat(rec[3], 64)
+		lon, err2 := strconv.ParseFloat(rec[4], 64)
+		if err1 != nil || err2 != nil {
+			return nil, fmt.Errorf("line %d: invalid coordinates", i+2)
+		}
+		ps = append(ps, place{Name: rec[0], CountryCode: rec[1], Country: rec[2], Lat: lat, Lon: lon, Zone: rec[5], key: foldName(rec[0])})
+	}
+	return ps, nil
+}
+
+// findPlaces returns the places whose name matches query, ignoring case
+// and accents. The query may be narrowed with a country name or ISO code
+// after a comma, e.g. "Melbourne, AU" or "Perth, Australia".
+func findPlaces(query string) []place {
+	name, country := splitCountry(query)
+	var out []place
+	for _, p := range places {
+		if p.searchKey() == name && p.inCountry(country) {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// splitCountry separates "name, country" and folds both parts.
+func splitCountry(query string) (name, country string) {
+	name, country, _ = strings.Cut(query, ",")
+	return foldName(name), foldName(country)
+}
+
+// inCountry reports whether p is in the folded country name or code, or
+// country is empty.
+func (p place) inCountry(country string) bool {
+	return country == "" || country == strings.ToLower(p.CountryCode) || country == foldName(p.Country)
+}
+
+// searchKey returns the folded name, computing it for places that were not
+// read by parsePlaces.
+func (p place) searchKey() string {
+	if p.key == "" {
+		return foldName(p.Name)
+	}
+	return p.key
+}
+
+// accentFolds maps the accented letters found in place names, and those
+// people commonly type, to their unaccented forms.
+var accentFolds = strings.NewReplacer(
+	"à", "a", "á", "a", "â", "a", "ã", "a", "ä", "a", "å", "a", "ā", "a", "ă", "a", "ą", "a",
+	"æ", "ae", "ç", "c", "ć", "c", "č", "c", "ď", "d", "đ", "d", "ð", "d",
+	"è", "e", "é", "e", "ê", "e", "ë", "e", "ē", "e", "ė", "e", "ę", "e", "ě", "e",
+	"ğ", "g", "ì", "i", "í", "i", "î", "i", "ï", "i", "ī", "i", "ı", "i", "ł", "l", "ľ", "l",
+	"ñ", "n", "ń", "n", "ň", "n", "ò", "o", "ó", "o", "ô", "o", "õ", "o", "ö", "o", "ø", "o", "ő", "o",
+	"œ", "oe", "ř", "r", "ś", "s", "ş", "s", "š", "s", "ș", "s", "ß", "ss", "ť", "t", "ţ", "t", "ț", "t", "þ", "th",
+	"ù", "u", "ú", "u", "û", "u", "ü", "u", "ū", "u", "ů", "u", "ű", "u", "ý", "y", "ÿ", "y",
+	"ź", "z", "ż", "z", "ž", "z",
+)
+
+// foldName normalises a place name for comparison: lower case, accents
+// removed, and punctuation and runs of spaces collapsed to single spaces,
+// so "Saint-Étienne" and "saint etienne" compare equal.
+func foldName(s string) string {
+	s = accentFolds.Replace(strings.ToLower(s))
+	return strings.Join(strings.FieldsFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}), " ")
+}
+
+// Match tiers for searchPlaces, best first.
+const (
+	matchExact = iota
+	matchPrefix
+	matchWord // the query starts a later word, e.g. "paulo" in "Sao Paulo"
+	matchFuzzy
+)
+
+// maxPlaceQuery bounds the query length searchPlaces will consider, which
+// bounds the cost of the fuzzy comparison.
+const maxPlaceQuery = 64
+
+// searchPlaces returns up to limit places matching query, best first:
+// exact names, then names starting with the query, then names with a later
+// word starting with it, then names within a small edit distance of it
+// (one typo for queries of four or more letters, two from eight). Ties
+// keep gazetteer order, so larger cities come first. A country may follow
+// a comma, as for findPlaces.
+func searchPlaces(query string, limit int) []place {
+	name, country := splitCountry(query)
+	if name == "" || limit <= 0 || len(name) > maxPlaceQuery {
+		return nil
+	}
+	q := []rune(name)
+	maxDist := 0
+	switch {
+	case len(q) >= 8:
+		maxDist = 2
+	case len(q) >= 4:
+		maxDist = 1
+	}
+
+	type hit struct {
+		p    place
+		rank int // tier*10 + edit distance
+	}
+	var hits []hit
+	for _, p := range places {
+		if !p.inCountry(country) {
+			continue
+		}
+		key := p.searchKey()
+		switch {
+		case key == name:
+			hits = append(hits, hit{p, matchExact * 10})
+		case strings.HasPrefix(key, name):
+			hits = append(hits, hit{p, matchPrefix * 10})
+		case strings.Contains(key, " "+name):
+			hits = append(hits, hit{p, matchWord * 10})
+		case maxDist > 0:
+			if d := prefixDistance(q, []rune(key), maxDist); d <= maxDist {
+				hits = append(hits, hit{p, matchFuzzy*10 + d})
+			}
+		}
+	}
+	sort.SliceStable(hits, func(i, j int) bool { return hits[i].rank < hits[j].rank })
+	out := make([]place, 0, min(limit, len(hits)))
+	for _, h := range hits[:min(limit, len(hits))] {
+		out = append(out, h.p)
+	}
+	return out
+}
+
+// prefixDistance is the smallest edit distance (counting adjacent
+// transpositions as one edit) between q and a prefix of key within bound
+// runes of len(q), so a misspelt start of a name still matches. It returns
+// bound+1 if there is none within bound.
+func prefixDistance(q, key []rune, bound int) int {
+	if len(key) < len(q)-bound {
+		return bound + 1
+	}
+	// Rows of the optimal string alignment table for q against key; the
+	// final row gives the distance from q to every prefix of key.
+	n := min(len(key), len(q)+bound)
+	prev2 := make([]int, n+1)
+	prev := make([]int, n+1)
+	cur := make([]int, n+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(q); i++ {
+		cur[0] = i
+		rowMin := cur[0]
+		for j := 1; j <= n; j++ {
+			cost := 1
+			if q[i-1] == key[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && q[i-1] == key[j-2] && q[i-2] == key[j-1] {
+				cur[j] = min(cur[j], prev2[j-2]+1)
+			}
+			rowMin = min(rowMin, cur[j])
+		}
+		if rowMin > bound {
+			return bound + 1
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	best := bound + 1
+	for j := max(0, len(q)-bound); j <= n; j++ {
+		best = min(best, prev[j])
+	}
+	return best
+}
+
+// lookupPlace resolves a place= parameter: an exact name if there is one,
+// otherwise the best search match.
+func lookupPlace(query string) (place, bool) {
+	if ps := findPlaces(query); len(ps) > 0 {
+		return ps[0], true
+	}
+	if ps := searchPlaces(query, 1); len(ps) > 0 {
+		return ps[0], true
+	}
+	return place{}, false
+}
+
+// Limits on /api/places results.
+const (
+	defaultPlaceResults = 10
+	maxPlaceResults     = 50
+)
+
+// placesResponse is the JSON shape returned by /api/places.
+type placesResponse struct {
+	Places []place
+	Error  string `json:",omitempty"`
+}
+
+// handlePlaces serves /api/places?q=&limit=, the gazetteer search used for
+// place suggestions. Results depend only on the query and the build, so
+// they may be cached for a day.
+func handlePlaces(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	if q == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(placesResponse{Error: "missing q parameter"})
+		return
+	}
+	limit := defaultPlaceResults
+	if l := r.URL.Query().Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 1 {
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(placesResponse{Error: "invalid limit"})
+			return
+		}
+		limit = min(n, maxPlaceResults)
+	}
+
+	etag := computedETag("places", q, limit)
+	if notModified(w, r, etag, cacheFor(maxDynamicAge)) {
+		return
+	}
+	// An empty list rather than null, so clients can always iterate.
+	ps := searchPlaces(q, limit)
+	if ps == nil {
+		ps = []place{}
+	}
+	_ = json.NewEncoder(w).Encode(placesResponse{Places: ps})
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Test search ranks exact, prefix, word and fuzzy matches in that order
+func TestSearchPlaces(t *testing.T) {
+	names := func(q string, limit int) []string {
+		var out []string
+		for _, p := range searchPlaces(q, limit) {
+			out = append(out, p.Name+"/"+p.CountryCode)
+		}
+		return out
+	}
+	cases := []struct {
+		q     string
+		first string
+	}{
+		{"Melbourne", "Melbourne/AU"},
+		{"melb", "Melbourne/AU"},
+		{"melborne", "Melbourne/AU"},  // one typo
+		{"melbuorne", "Melbourne/AU"}, // transposition
+		{"londn", "London/GB"},        // typo in a short prefix
+		{"paulo", "Sao Paulo/BR"},     // later word
+		{"são paulo", "Sao Paulo/BR"}, // accents in the query
+		{"tromso", "Tromsø/NO"},       // accents in the data
+		{"saint etienne", "Saint-Etienne/FR"},
+		{"london, ca", "London/CA"},
+		{"perth, australia", "Perth/AU"},
+	}
+	for _, c := range cases {
+		got := names(c.q, 5)
+		if len(got) == 0 || got[0] != c.first {
+			t.Errorf("searchPlaces(%q) = %v, want %s first", c.q, got, c.first)
+		}
+	}
+
+	// Larger cities come first among equal matches.
+	if got := names("london", 2); len(got) != 2 || got[0] != "London/GB" || got[1] != "London/CA" {
+		t.Errorf("london = %v", got)
+	}
+	if got := names("san", 3); len(got) != 3 {
+		t.Errorf("limit ignored: %v", got)
+	}
+	for _, q := range []string{"", "Qwxzvbn", "!!", ", AU"} {
+		if got := names(q, 5); len(got) != 0 {
+			t.Errorf("searchPlaces(%q) = %v, want none", q, got)
+		}
+	}
+}
+
+// Test prefixDistance counts edits against the closest prefix of the name
+func TestPrefixDistance(t *testing.T) {
+	cases := []struct {
+		q, key string
+		want   int
+	}{
+		{"melb", "melbourne", 0},
+		{"melborne", "melbourne", 1},
+		{"mlebourne", "melbourne", 1},
+		{"melbxxrne", "melbourne", 2},
+		{"sydney", "melbourne", 3}, // beyond the bound
+		{"melbourne", "mel", 3},
+	}
+	for _, c := range cases {
+		if got := prefixDistance([]rune(c.q), []rune(c.key), 2); got != c.want {
+			t.Errorf("prefixDistance(%q, %q) = %d, want %d", c.q, c.key, got, c.want)
+		}
+	}
+}
+
+// Test /api/places returns JSON matches and rejects bad queries
+func TestHandlePlaces(t *testing.T) {
+	get := func(url string) (*httptest.ResponseRecorder, placesResponse) {
+		rr := httptest.NewRecorder()
+		handlePlaces(rr, httptest.NewRequest("GET", url, nil))
+		var resp placesResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("%s: invalid JSON: %v", url, err)
+		}
+		return rr, resp
+	}
+
+	rr, resp := get("/api/places?q=melb&limit=3")
+	if rr.Code != http.StatusOK || len(resp.Places) == 0 || len(resp.Places) > 3 {
+		t.Fatalf("status %d, %+v", rr.Code, resp)
+	}
+	if p := resp.Places[0]; p.Name != "Melbourne" || p.Zone != "Australia/Melbourne" || p.Lat != -37.814 {
+		t.Errorf("first result = %+v", p)
+	}
+	if rr.Header().Get("ETag") == "" || rr.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("headers = %v", rr.Header())
+	}
+
+	if rr, resp := get("/api/places?q=Qwxzvbn"); rr.Code != http.StatusOK || resp.Places == nil || len(resp.Places) != 0 {
+		t.Errorf("no match: status %d, %+v", rr.Code, resp)
+	}
+	for _, url := range []string{"/api/places", "/api/places?q=+", "/api/places?q=melb&limit=0", "/api/places?q=melb&limit=x"} {
+		if rr, resp := get(url); rr.Code != http.StatusBadRequest || resp.Error == "" {
+			t.Errorf("%s: status %d, %+v", url, rr.Code, resp)
+		}
+	}
+}
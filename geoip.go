@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// geoip is the optional IP-to-location database, nil when none is
+// configured. It is loaded once in main before the server starts.
+var geoip *geoIPDB
+
+// geoIPDB maps IP address ranges to approximate locations. It reads the
+// CSV layout of the free DB-IP "IP to City Lite" database:
+//
+//	ip_start,ip_end,continent,country,region,city,latitude,longitude[,zone]
+//
+// The optional ninth column is an IANA zone name. Rows without one use the
+// zone containing their coordinates.
+type geoIPDB struct {
+	ranges []geoIPRange // sorted by start, non-overlapping
+}
+
+type geoIPRange struct {
+	start, end netip.Addr
+	loc        location
+}
+
+func loadGeoIPDB(path string) (*geoIPDB, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	db, err := parseGeoIPCSV(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return db, nil
+}
+
+func parseGeoIPCSV(r io.Reader) (*geoIPDB, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.ReuseRecord = true
+	db := &geoIPDB{}
+	for line := 1; ; line++ {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(rec) < 8 {
+			return nil, fmt.Errorf("line %d: want at least 8 fields, got %d", line, len(rec))
+		}
+		start, err1 := netip.ParseAddr(rec[0])
+		end, err2 := netip.ParseAddr(rec[1])
+		lat, err3 := strconv.ParseFloat(rec[6], 64)
+		lon, err4 := strconv.ParseFloat(rec[7], 64)
+		if err := errors.Join(err1, err2, err3, err4); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if start.Is4() != end.Is4() || end.Less(start) {
+			return nil, fmt.Errorf("line %d: invalid range %s-%s", line, start, end)
+		}
+		loc := location{Name: rec[5], Lat: lat, Lon: lon}
+		if rec[3] != "" && loc.Name != "" {
+			loc.Name += ", " + rec[3]
+		}
+		if len(rec) > 8 && rec[8] != "" {
+			if _, err := loadZone(rec[8]); err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			loc.Zone = rec[8]
+		}
+		db.ranges = append(db.ranges, geoIPRange{start: start, end: end, loc: loc})
+	}
+	sort.Slice(db.ranges, func(i, j int) bool { return db.ranges[i].start.Less(db.ranges[j].start) })
+	return db, nil
+}
+
+// lookup returns the location for ip. It is safe to call on a nil db.
+func (db *geoIPDB) lookup(ip string) (location, bool) {
+	if db == nil {
+		return location{}, false
+	}
+	addr, err := netip.ParseAddr(strings.TrimSpace(ip))
+	if err != nil {
+		return location{}, false
+	}
+	addr = addr.Unmap()
+	// First range starting after addr; the candidate is the one before it.
+	i := sort.Search(len(db.ranges), func(i int) bool { return addr.Less(db.ranges[i].start) })
+	if i == 0 {
+		return location{}, false
+	}
+	rg := db.ranges[i-1]
+	if addr.Is4() != rg.start.Is4() || rg.end.Less(addr) {
+		return location{}, false
+	}
+	return rg.loc, true
+}
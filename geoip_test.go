@@ -0,0 +1,138 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testGeoIPCSV = `1.0.0.0,1.0.0.255,OC,AU,Queensland,Brisbane,-27.47,153.02
+81.2.69.0,81.2.69.255,EU,GB,England,London,51.51,-0.13,Europe/London
+2001:db8::,2001:db8::ffff,NA,US,Colorado,Denver,39.74,-104.98,America/Denver
+`
+
+func TestGeoIPLookup(t *testing.T) {
+	db, err := parseGeoIPCSV(strings.NewReader(testGeoIPCSV))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		ip   string
+		name string
+		zone string
+		ok   bool
+	}{
+		{"1.0.0.7", "Brisbane, AU", "", true},
+		{"81.2.69.142", "London, GB", "Europe/London", true},
+		{"::ffff:81.2.69.1", "London, GB", "Europe/London", true},
+		{"2001:db8::1", "Denver, US", "America/Denver", true},
+		{"1.0.1.0", "", "", false},
+		{"0.0.0.1", "", "", false},
+		{"not-an-ip", "", "", false},
+	}
+	for _, c := range cases {
+		loc, ok := db.lookup(c.ip)
+		if ok != c.ok || loc.Name != c.name || loc.Zone != c.zone {
+			t.Errorf("lookup(%s) = %+v, %v; want %s %s %v", c.ip, loc, ok, c.name, c.zone, c.ok)
+		}
+	}
+
+	var nilDB *geoIPDB
+	if _, ok := nilDB.lookup("1.0.0.7"); ok {
+		t.Error("nil database should never match")
+	}
+
+	if _, err := parseGeoIPCSV(strings.NewReader("1.0.0.0,1.0.0.255,OC,AU,Q,B,-27,153,Nowhere/Special\n")); err == nil {
+		t.Error("unknown zone column should be rejected")
+	}
+}
+
+// Test a location's offset follows DST and falls back to the zone at its coordinates
+func TestLocationZon(t *testing.T) {
+	mel := location{Lon: 144.96, Zone: "Australia/Melbourne"}
+	if got := mel.zon(time.Date(2026, 1, 15, 0, 0, 0, 0, time.UTC)); got != 11 {
+		t.Errorf("Melbourne January offset = %v, want 11", got)
+	}
+	if got := mel.zon(time.Date(2026, 7, 15, 0, 0, 0, 0, time.UTC)); got != 10 {
+		t.Errorf("Melbourne July offset = %v, want 10", got)
+	}
+	if got := (location{Lon: -104.98}).zon(time.Now()); got != -7 {
+		t.Errorf("nautical offset = %v, want -7", got)
+	}
+	if got := (location{Lat: 35.69, Lon: 139.69}).zon(time.Now()); got != 9 {
+		t.Errorf("Tokyo offset = %v, want 9", got)
+	}
+}
+
+// Test the zone lookup from coordinates, on land and at sea
+func TestZoneFor(t *testing.T) {
+	cases := []struct {
+		lat, lon float64
+		want     string
+	}{
+		{-37.81, 144.96, "Australia/Melbourne"},
+		{-31.95, 115.86, "Australia/Perth"},
+		{35.69, 139.69, "Asia/Tokyo"},
+		{40.71, -74.01, "America/New_York"},
+		{0, 0, "Etc/GMT"},
+		{-40, -150, "Etc/GMT+10"},
+		{-30, 170, "Etc/GMT-11"},
+	}
+	for _, c := range cases {
+		if got := zoneFor(c.lat, c.lon); got != c.want {
+			t.Errorf("zoneFor(%v, %v) = %q, want %q", c.lat, c.lon, got, c.want)
+		}
+	}
+}
+
+// Test that the calendar and index default to the GeoIP location
+func TestDefaultLocationFromGeoIP(t *testing.T) {
+	db, err := parseGeoIPCSV(strings.NewReader(testGeoIPCSV))
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev := geoip
+	geoip = db
+	t.Cleanup(func() { geoip = prev })
+
+	req := httptest.NewRequest("GET", "/calendar?year=2026&month=3", nil)
+	req.RemoteAddr = "81.2.69.142:5555"
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(calendar).ServeHTTP(rr, req)
+	if body := rr.Body.String(); !strings.Contains(body, "London, GB") || !strings.Contains(body, "Latitude: 51.51") {
+		t.Errorf("calendar should default to the GeoIP location")
+	}
+
+	req = httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "192.0.2.1:5555"
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(handleIndex).ServeHTTP(rr, req)
+	if body := rr.Body.String(); !strings.Contains(body, `data-default-tz="Australia/Melbourne"`) {
+		t.Errorf("index should fall back to the configured default location")
+	}
+
+	// Behind a proxy the client's IP comes from the configured header,
+	// and only then.
+	req = httptest.NewRequest("GET", "/calendar?year=2026&month=3", nil)
+	req.RemoteAddr = "192.0.2.1:5555"
+	req.Header.Set("CF-Connecting-IP", "81.2.69.142")
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(calendar).ServeHTTP(rr, req)
+	if strings.Contains(rr.Body.String(), "London, GB") {
+		t.Error("the header shouldn't be trusted unless client-ip-header is set")
+	}
+	prevHeader := cfg.ClientIPHeader
+	cfg.ClientIPHeader = "CF-Connecting-IP"
+	t.Cleanup(func() { cfg.ClientIPHeader = prevHeader })
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(calendar).ServeHTTP(rr, req)
+	if !strings.Contains(rr.Body.String(), "London, GB") {
+		t.Error("calendar should default to the GeoIP location of the header's IP")
+	}
+	req.Header.Set("CF-Connecting-IP", "not an ip")
+	if got := clientIP(req); got != "192.0.2.1" {
+		t.Errorf("clientIP with a malformed header = %q, want the connection's address", got)
+	}
+}
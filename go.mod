@@ -5,3 +5,7 @@ go 1.26.0
 require github.com/exploded/riseset v1.0.1-0.20260220080739-24891d86367a
 
 require github.com/exploded/monitor v0.0.0-20260331015627-909d7b304ae3
+
+require github.com/andybalholm/brotli v1.2.0
+
+require github.com/bradfitz/latlong v0.0.0-20170410180902-f3db6d0dff40
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+	_ "time/tzdata" // zone data for hosts without /usr/share/zoneinfo
+
+	"github.com/bradfitz/latlong"
+)
+
+// location is a named point on the Earth with its IANA time zone.
+type location struct {
+	Name string
+	Lat  float64
+	Lon  float64
+	Zone string // IANA name, e.g. "Australia/Melbourne"; empty if unknown
+}
+
+// zon returns the location's UTC offset in hours at t, in the decimal form
+// riseset expects. Without a known zone it uses the zone containing the
+// coordinates.
+func (l location) zon(t time.Time) float64 {
+	name := l.Zone
+	if name == "" {
+		name = zoneFor(l.Lat, l.Lon)
+	}
+	if tz, err := loadZone(name); err == nil {
+		_, off := t.In(tz).Zone()
+		return float64(off) / 3600
+	}
+	return nauticalZon(l.Lon)
+}
+
+// zoneFor returns the IANA zone containing lat, lon, from the boundary data
+// embedded by github.com/bradfitz/latlong. Where that has none, mostly at
+// sea, it returns the Etc zone for the nautical offset.
+func zoneFor(lat, lon float64) string {
+	name := latlong.LookupZoneName(lat, lon)
+	if canonical, ok := zoneAliases[name]; ok {
+		name = canonical
+	}
+	if validZone(name) {
+		return name
+	}
+	return nauticalZone(lon)
+}
+
+// zoneAliases maps the zones in latlong's (2017) boundary data that the tz
+// database has since made links to the zone.tab zone now covering them.
+var zoneAliases = map[string]string{
+	"America/Coral_Harbour": "America/Atikokan",
+	"America/Godthab":       "America/Nuuk",
+	"America/Montreal":      "America/Toronto",
+	"America/Nipigon":       "America/Toronto",
+	"America/Pangnirtung":   "America/Iqaluit",
+	"America/Thunder_Bay":   "America/Toronto",
+	"America/Yellowknife":   "America/Edmonton",
+	"Asia/Choibalsan":       "Asia/Ulaanbaatar",
+	"Asia/Chongqing":        "Asia/Shanghai",
+	"Asia/Harbin":           "Asia/Shanghai",
+	"Asia/Kashgar":          "Asia/Urumqi",
+	"Asia/Rangoon":          "Asia/Yangon",
+	"Australia/Currie":      "Australia/Hobart",
+	"Europe/Kiev":           "Europe/Kyiv",
+	"Europe/Uzhgorod":       "Europe/Kyiv",
+	"Europe/Zaporozhye":     "Europe/Kyiv",
+	"Pacific/Enderbury":     "Pacific/Kanton",
+	"Pacific/Yap":           "Pacific/Chuuk",
+}
+
+// nauticalZon is the whole-hour offset of the nautical time zone containing
+// lon.
+func nauticalZon(lon float64) float64 {
+	return math.Round(lon / 15)
+}
+
+// nauticalZone names the nautical time zone containing lon. Etc zone names
+// follow POSIX and invert the sign: Etc/GMT-10 is ten hours east of UTC.
+func nauticalZone(lon float64) string {
+	h := int(nauticalZon(lon))
+	if h == 0 {
+		return "Etc/GMT"
+	}
+	return fmt.Sprintf("Etc/GMT%+d", -h)
+}
+
+// formatOffset formats an offset in seconds east of UTC as "UTC+05:30".
+func formatOffset(secs int) string {
+	sign := '+'
+	if secs < 0 {
+		sign, secs = '-', -secs
+	}
+	return fmt.Sprintf("UTC%c%02d:%02d", sign, secs/3600, secs/60%60)
+}
+
+var zoneCache sync.Map // zone name -> *time.Location
+
+// loadZone is time.LoadLocation with a cache: LoadLocation re-reads and
+// parses the zone data on every call.
+func loadZone(name string) (*time.Location, error) {
+	if tz, ok := zoneCache.Load(name); ok {
+		return tz.(*time.Location), nil
+	}
+	tz, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, err
+	}
+	zoneCache.Store(name, tz)
+	return tz, nil
+}
+
+// validZone reports whether name is an IANA zone. LoadLocation's special
+// names, "" for UTC and "Local" for the server's zone, are not accepted.
+func validZone(name string) bool {
+	if name == "" || name == "Local" {
+		return false
+	}
+	_, err := loadZone(name)
+	return err == nil
+}
+
+// defaultLocationFor returns the location to use when a request doesn't
+// specify one: the first of the client's saved locations, the GeoIP match
+// for the client if a database is loaded and has one, otherwise the
+// configured default.
+func defaultLocationFor(r *http.Request) location {
+	if saved := savedLocations(r); len(saved) > 0 {
+		return saved[0]
+	}
+	if loc, ok := geoip.lookup(clientIP(r)); ok {
+		return loc
+	}
+	return cfg.defaultLocation()
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// coordReplacer turns degree, minute and second marks and separators into
+// spaces, so "37°48'49\"S, 144°57'47\"E" tokenises like "37 48 49 S 144 57 47 E".
+var coordReplacer = strings.NewReplacer(
+	"°", " ", "º", " ", "′", " ", "'", " ", "″", " ", `"`, " ",
+	",", " ", ";", " ", "−", "-",
+)
+
+// parseCoordinates reads a latitude and longitude written as decimal
+// degrees ("-37.81 144.96"), or as degrees and minutes, or degrees, minutes
+// and seconds, with or without N/S/E/W hemisphere letters before or after
+// each value ("37°48'49\"S 144°57'47\"E", "S37.81 E144.96"). Without
+// hemisphere letters the latitude comes first.
+func parseCoordinates(s string) (lat, lon float64, err error) {
+	type token struct {
+		num  string
+		hemi byte
+	}
+	var toks []token
+	for _, field := range strings.Fields(coordReplacer.Replace(strings.ToUpper(s))) {
+		// Split hemisphere letters from the numbers they touch: "37.8S".
+		for field != "" {
+			switch c := field[0]; {
+			case c == 'N' || c == 'S' || c == 'E' || c == 'W':
+				toks = append(toks, token{hemi: c})
+				field = field[1:]
+			default:
+				i := strings.IndexAny(field, "NSEW")
+				if i < 0 {
+					i = len(field)
+				}
+				toks = append(toks, token{num: field[:i]})
+				field = field[i:]
+			}
+		}
+	}
+	if len(toks) == 0 {
+		return 0, 0, errors.New("no coordinates")
+	}
+
+	// Group the numbers into two values. A hemisphere letter either starts
+	// (prefix style) or ends (suffix style) each group.
+	type group struct {
+		nums []string
+		hemi byte
+	}
+	var groups []group
+	if toks[0].hemi != 0 {
+		for _, t := range toks {
+			if t.hemi != 0 {
+				groups = append(groups, group{hemi: t.hemi})
+			} else {
+				groups[len(groups)-1].nums = append(groups[len(groups)-1].nums, t.num)
+			}
+		}
+	} else if slices.ContainsFunc(toks, func(t token) bool { return t.hemi != 0 }) {
+		var cur group
+		for _, t := range toks {
+			if t.hemi != 0 {
+				cur.hemi = t.hemi
+				groups = append(groups, cur)
+				cur = group{}
+			} else {
+				cur.nums = append(cur.nums, t.num)
+			}
+		}
+		if len(cur.nums) > 0 {
+			return 0, 0, errors.New("missing hemisphere letter")
+		}
+	} else {
+		n := len(toks)
+		if n != 2 && n != 4 && n != 6 {
+			return 0, 0, errors.New("expected a latitude and a longitude")
+		}
+		var nums []string
+		for _, t := range toks {
+			nums = append(nums, t.num)
+		}
+		groups = []group{{nums: nums[:n/2]}, {nums: nums[n/2:]}}
+	}
+	if len(groups) != 2 {
+		return 0, 0, errors.New("expected a latitude and a longitude")
+	}
+
+	vals := make([]float64, 2)
+	for i, g := range groups {
+		if vals[i], err = sexagesimal(g.nums); err != nil {
+			return 0, 0, err
+		}
+		if g.hemi == 'S' || g.hemi == 'W' {
+			if vals[i] < 0 {
+				return 0, 0, errors.New("negative value with a hemisphere letter")
+			}
+			vals[i] = -vals[i]
+		}
+	}
+	lat, lon = vals[0], vals[1]
+	switch h0, h1 := groups[0].hemi, groups[1].hemi; {
+	case h0 == 0 && h1 == 0:
+	case (h0 == 'E' || h0 == 'W') && (h1 == 'N' || h1 == 'S'):
+		lat, lon = lon, lat
+	case (h0 == 'N' || h0 == 'S') && (h1 == 'E' || h1 == 'W'):
+	default:
+		return 0, 0, errors.New("need one of N/S and one of E/W")
+	}
+	if lat < -90 || lat > 90 || lon < -180 || lon > 180 {
+		return 0, 0, errors.New("coordinates out of range")
+	}
+	return lat, lon, nil
+}
+
+// sexagesimal combines degrees, and optionally minutes and seconds, into
+// decimal degrees. Only the last component may have a fraction.
+func sexagesimal(nums []string) (float64, error) {
+	if len(nums) == 0 || len(nums) > 3 {
+		return 0, errors.New("expected degrees, minutes and seconds")
+	}
+	var total float64
+	neg := strings.HasPrefix(nums[0], "-")
+	for i, n := range nums {
+		v, err := strconv.ParseFloat(n, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid number %q", n)
+		}
+		if i > 0 && (v < 0 || v >= 60) {
+			return 0, fmt.Errorf("minutes and seconds must be 0-59, got %q", n)
+		}
+		if i < len(nums)-1 && strings.Contains(n, ".") {
+			return 0, fmt.Errorf("only the last component may have a fraction, got %q", n)
+		}
+		if i == 0 {
+			v = math.Abs(v)
+		}
+		total += v / [...]float64{1, 60, 3600}[i]
+	}
+	if neg {
+		total = -total
+	}
+	return total, nil
+}
+
+// parseMaidenhead decodes a Maidenhead grid locator of 4, 6 or 8
+// characters (e.g. "QF22le") to the centre of its square. Two-character
+// fields are rejected as too coarse, and to avoid clashing with place names.
+func parseMaidenhead(s string) (lat, lon float64, ok bool) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	if n := len(s); n != 4 && n != 6 && n != 8 {
+		return 0, 0, false
+	}
+	// Each pair refines longitude then latitude: fields A-R (20°x10°),
+	// squares 0-9, subsquares A-X, extended squares 0-9.
+	lonSize, latSize := 20.0, 10.0
+	lon, lat = -180, -90
+	for i := 0; i < len(s); i += 2 {
+		lo, la := s[i], s[i+1]
+		var base, count byte
+		switch i / 2 {
+		case 0:
+			base, count = 'A', 18
+		case 2:
+			base, count = 'A', 24
+		default:
+			base, count = '0', 10
+		}
+		if i > 0 {
+			lonSize /= float64(count)
+			latSize /= float64(count)
+		}
+		if lo < base || lo >= base+count || la < base || la >= base+count {
+			return 0, 0, false
+		}
+		lon += float64(lo-base) * lonSize
+		lat += float64(la-base) * latSize
+	}
+	return lat + latSize/2, lon + lonSize/2, true
+}
+
+// maxLocationChoices limits the near matches offered for a misspelt name.
+const maxLocationChoices = 8
+
+// resolveLocation interprets free-form location input: a Maidenhead
+// locator, coordinates, or a place name from the gazetteer. Place names
+// may match several places; coordinates resolve to one unnamed location.
+func resolveLocation(q string) ([]location, error) {
+	q = strings.TrimSpace(q)
+	if q == "" {
+		return nil, errors.New("enter coordinates, a grid locator or a place name")
+	}
+	if lat, lon, ok := parseMaidenhead(q); ok {
+		return []location{{Lat: lat, Lon: lon, Zone: zoneFor(lat, lon)}}, nil
+	}
+	if strings.IndexFunc(q, unicode.IsDigit) >= 0 {
+		lat, lon, err := parseCoordinates(q)
+		if err != nil {
+			return nil, fmt.Errorf("could not read coordinates: %w", err)
+		}
+		return []location{{Lat: lat, Lon: lon, Zone: zoneFor(lat, lon)}}, nil
+	}
+	// Exact names first; failing that, offer the closest search matches.
+	ps := findPlaces(q)
+	if len(ps) == 0 {
+		ps = searchPlaces(q, maxLocationChoices)
+	}
+	var out []location
+	for _, p := range ps {
+		out = append(out, p.location())
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("no place called %q in the gazetteer", q)
+	}
+	return out, nil
+}
+
+// calendarURL links to the calendar for l, by its zone if known and
+// otherwise its offset today.
+func calendarURL(l location, now time.Time) string {
+	v := url.Values{}
+	v.Set("lat", strconv.FormatFloat(l.Lat, 'f', -1, 64))
+	v.Set("lon", strconv.FormatFloat(l.Lon, 'f', -1, 64))
+	if l.Zone != "" {
+		v.Set("tz", l.Zone)
+	} else {
+		v.Set("zon", strconv.FormatFloat(l.zon(now), 'f', -1, 64))
+	}
+	return "/calendar?" + v.Encode()
+}
+
+// handleLocation is the map-free way in: it resolves ?q= and redirects to
+// the calendar, lists the candidates when a place name is ambiguous, or
+// shows the form (with any error) otherwise.
+func handleLocation(w http.ResponseWriter, r *http.Request) {
+	type choice struct {
+		Name string
+		URL  string
+	}
+	data := struct {
+		Query   string
+		Error   string
+		Choices []choice
+	}{Query: r.URL.Query().Get("q")}
+
+	status := http.StatusOK
+	if r.URL.Query().Has("q") {
+		locs, err := resolveLocation(data.Query)
+		switch {
+		case err != nil:
+			data.Error = err.Error()
+			status = http.StatusBadRequest
+		case len(locs) == 1:
+			http.Redirect(w, r, calendarURL(locs[0], time.Now()), http.StatusSeeOther)
+			return
+		default:
+			for _, l := range locs {
+				data.Choices = append(data.Choices, choice{l.Name, calendarURL(l, time.Now())})
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := executeTemplate(r.Context(), w, "location.html", data); err != nil {
+		slog.ErrorContext(r.Context(), "Error executing location template", "error", err)
+	}
+}
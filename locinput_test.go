@@ -0,0 +1,156 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func near(a, b float64) bool { return math.Abs(a-b) < 1e-3 }
+
+func TestParseCoordinates(t *testing.T) {
+	const lat, lon = -37.8136, 144.9631
+	good := map[string][2]float64{
+		"-37.8136, 144.9631":           {lat, lon},
+		"-37.8136 144.9631":            {lat, lon},
+		"37.8136S 144.9631E":           {lat, lon},
+		"37.8136 S, 144.9631 E":        {lat, lon},
+		"S37.8136 E144.9631":           {lat, lon},
+		"144.9631E 37.8136S":           {lat, lon},
+		`37°48'48.96"S 144°57'47.16"E`: {lat, lon},
+		"37°48′48.96″S 144°57′47.16″E": {lat, lon},
+		"37 48 48.96 S 144 57 47.16 E": {lat, lon},
+		"-37 48 48.96, 144 57 47.16":   {lat, lon},
+		"37°48.816'S 144°57.786'E":     {lat, lon},
+		"51.5074 n 0.1278 w":           {51.5074, -0.1278},
+		"−33.8688, 151.2093":           {-33.8688, 151.2093},
+	}
+	for in, want := range good {
+		gotLat, gotLon, err := parseCoordinates(in)
+		if err != nil || !near(gotLat, want[0]) || !near(gotLon, want[1]) {
+			t.Errorf("parseCoordinates(%q) = %v, %v, %v; want %v", in, gotLat, gotLon, err, want)
+		}
+	}
+	for _, in := range []string{
+		"",
+		"37.8",
+		"1 2 3",
+		"95, 10",
+		"10, 190",
+		"37 61 0 S 144 0 0 E",
+		"37.5 30 S 144 0 E",
+		"37S 144S",
+		"37S 144",
+		"-37S 144E",
+		"abc def",
+	} {
+		if lat, lon, err := parseCoordinates(in); err == nil {
+			t.Errorf("parseCoordinates(%q) = %v, %v; want error", in, lat, lon)
+		}
+	}
+}
+
+func TestParseMaidenhead(t *testing.T) {
+	cases := []struct {
+		in       string
+		lat, lon float64
+	}{
+		{"QF22", -37.5, 145},
+		{"QF22le", -37.8125, 144.958333},
+		{"qf22LE", -37.8125, 144.958333},
+		{"IO91wm", 51.520833, -0.125},
+		{"IO91wm48", 51.535417, -0.129167},
+	}
+	for _, c := range cases {
+		lat, lon, ok := parseMaidenhead(c.in)
+		if !ok || !near(lat, c.lat) || !near(lon, c.lon) {
+			t.Errorf("parseMaidenhead(%q) = %v, %v, %v; want %v, %v", c.in, lat, lon, ok, c.lat, c.lon)
+		}
+	}
+	for _, in := range []string{"QF", "QF2", "SF22", "QF22zz", "Rome", "Lima", "QF22le4"} {
+		if _, _, ok := parseMaidenhead(in); ok {
+			t.Errorf("parseMaidenhead(%q) should fail", in)
+		}
+	}
+}
+
+// Test the bundled gazetteer is loaded and searchable
+func TestFindPlaces(t *testing.T) {
+	if len(places) < 300 {
+		t.Fatalf("only %d places loaded", len(places))
+	}
+	for _, q := range []string{"Melbourne", "melbourne", "Melbourne, AU", "Melbourne, australia", " MELBOURNE ,au"} {
+		got := findPlaces(q)
+		if len(got) != 1 || got[0].Zone != "Australia/Melbourne" {
+			t.Errorf("findPlaces(%q) = %+v", q, got)
+		}
+	}
+	if got := findPlaces("Melbourne, US"); len(got) != 0 {
+		t.Errorf("country filter ignored: %+v", got)
+	}
+	if got := findPlaces("Tromso"); len(got) != 1 || got[0].Name != "Tromsø" {
+		t.Errorf("accents should be ignored: %+v", got)
+	}
+	for _, p := range places {
+		if _, err := loadZone(p.Zone); err != nil {
+			t.Errorf("%s: %v", p.Name, err)
+		}
+	}
+}
+
+// Test /location redirects, disambiguates and reports errors
+func TestHandleLocation(t *testing.T) {
+	prev := places
+	places = append([]place{
+		{Name: "Springfield", CountryCode: "US", Country: "United States", Lat: 39.8, Lon: -89.65, Zone: "America/Chicago"},
+		{Name: "Springfield", CountryCode: "US", Country: "United States", Lat: 42.1, Lon: -72.59, Zone: "America/New_York"},
+	}, prev...)
+	defer func() { places = prev }()
+
+	get := func(q string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		handleLocation(rr, httptest.NewRequest("GET", "/location?q="+url.QueryEscape(q), nil))
+		return rr
+	}
+
+	rr := get("QF22le")
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("locator: status %d", rr.Code)
+	}
+	loc, _ := url.Parse(rr.Header().Get("Location"))
+	if loc.Path != "/calendar" || loc.Query().Get("lat") != "-37.8125" || loc.Query().Get("tz") != "Australia/Melbourne" {
+		t.Errorf("locator redirect = %s", loc)
+	}
+
+	rr = get("Melbourne")
+	loc, _ = url.Parse(rr.Header().Get("Location"))
+	if rr.Code != http.StatusSeeOther || loc.Query().Get("lon") != "144.9633" || loc.Query().Get("tz") != "Australia/Melbourne" {
+		t.Errorf("place: status %d, redirect %s", rr.Code, loc)
+	}
+
+	rr = get("springfield")
+	if rr.Code != http.StatusOK || strings.Count(rr.Body.String(), `href="/calendar?`) != 2 {
+		t.Errorf("ambiguous place: status %d\n%s", rr.Code, rr.Body)
+	}
+
+	rr = get("springfeld")
+	if rr.Code != http.StatusOK || strings.Count(rr.Body.String(), `href="/calendar?`) < 2 {
+		t.Errorf("misspelt place should offer choices: status %d", rr.Code)
+	}
+
+	for _, q := range []string{"Qwxzvbn", "95, 200"} {
+		rr = get(q)
+		if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), `role="alert"`) {
+			t.Errorf("%q: status %d", q, rr.Code)
+		}
+	}
+
+	rr = httptest.NewRecorder()
+	handleLocation(rr, httptest.NewRequest("GET", "/location", nil))
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `name="q"`) {
+		t.Errorf("empty form: status %d", rr.Code)
+	}
+}
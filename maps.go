@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+)
+
+// Map providers for the index page.
+const (
+	mapGoogle  = "google"
+	mapLeaflet = "leaflet"
+)
+
+// mapView is what the index template needs to render the configured map.
+// Leaflet and its marker images are bundled under static/leaflet, so only
+// the tile server is external.
+type mapView struct {
+	Provider        string
+	GoogleMapsKey   string
+	TileURL         string
+	TileAttribution string
+}
+
+func currentMapView() mapView {
+	return mapView{
+		Provider:        cfg.MapProvider,
+		GoogleMapsKey:   cfg.GoogleMapsKey,
+		TileURL:         cfg.TileURL,
+		TileAttribution: cfg.TileAttribution,
+	}
+}
+
+// tileSource returns the CSP source expression covering a Leaflet tile URL
+// template, e.g. "https://*.tile.example.org" for
+// "https://{s}.tile.example.org/{z}/{x}/{y}.png".
+func tileSource(tileURL string) (string, error) {
+	for _, p := range []string{"{z}", "{x}", "{y}"} {
+		if !strings.Contains(tileURL, p) {
+			return "", errors.New("missing " + p)
+		}
+	}
+	u, err := url.Parse(strings.ReplaceAll(tileURL, "{s}", "s"))
+	if err != nil {
+		return "", err
+	}
+	if (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return "", errors.New("not an http(s) URL")
+	}
+	host := u.Host
+	_, rest, _ := strings.Cut(tileURL, "://")
+	if h, _, _ := strings.Cut(rest, "/"); strings.Contains(h, "{s}") {
+		// CSP only allows a wildcard as the leftmost label.
+		if !strings.HasPrefix(h, "{s}.") || strings.Count(h, "{s}") > 1 {
+			return "", errors.New("{s} is only supported as the first host label")
+		}
+		host = "*" + strings.TrimPrefix(h, "{s}")
+	}
+	return u.Scheme + "://" + host, nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTileSource(t *testing.T) {
+	cases := map[string]string{
+		"https://tile.openstreetmap.org/{z}/{x}/{y}.png":      "https://tile.openstreetmap.org",
+		"https://{s}.tile.opentopomap.org/{z}/{x}/{y}.png":    "https://*.tile.opentopomap.org",
+		"http://localhost:8080/tiles/{z}/{x}/{y}.png?key=abc": "http://localhost:8080",
+		"https://tile.openstreetmap.org/{z}/{x}.png":          "",
+		"ftp://tiles.example/{z}/{x}/{y}.png":                 "",
+		"https://tiles-{s}.example/{z}/{x}/{y}.png":           "",
+		"https://tile.example/{z}/{x}/{y}.png?sub={s}":        "https://tile.example",
+	}
+	for in, want := range cases {
+		got, err := tileSource(in)
+		if (err != nil) != (want == "") || got != want {
+			t.Errorf("tileSource(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+}
+
+// useMapProvider switches the map provider for the duration of the test.
+func useMapProvider(t *testing.T, provider string) {
+	t.Helper()
+	prev := cfg.MapProvider
+	cfg.MapProvider = provider
+	t.Cleanup(func() { cfg.MapProvider = prev })
+}
+
+// Test the index renders the configured map provider with a matching CSP
+func TestMapProviders(t *testing.T) {
+	cases := []struct {
+		provider   string
+		want, deny []string
+	}{
+		{mapGoogle,
+			[]string{"maps.googleapis.com/maps/api/js", assetURL("map-google.js")},
+			[]string{"leaflet", "data-tiles"}},
+		{mapLeaflet,
+			[]string{assetURL("leaflet/leaflet.js"), assetURL("leaflet/leaflet.css"), assetURL("map-leaflet.js"),
+				`data-tiles="https://tile.openstreetmap.org/{z}/{x}/{y}.png"`, assetURL("leaflet/images/marker-icon.png")},
+			[]string{"googleapis", "map-google.js"}},
+	}
+	handler := makeHTTPServer().Handler
+	for _, c := range cases {
+		useMapProvider(t, c.provider)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+		body := rr.Body.String()
+		for _, want := range c.want {
+			if !strings.Contains(body, want) {
+				t.Errorf("%s: page missing %s", c.provider, want)
+			}
+		}
+		for _, deny := range c.deny {
+			if strings.Contains(body, deny) {
+				t.Errorf("%s: page should not contain %s", c.provider, deny)
+			}
+		}
+
+		csp := rr.Header().Get("Content-Security-Policy")
+		if google := strings.Contains(csp, "maps.googleapis.com"); google != (c.provider == mapGoogle) {
+			t.Errorf("%s: CSP Google allowance = %v: %s", c.provider, google, csp)
+		}
+		if c.provider == mapLeaflet && (!strings.Contains(csp, "img-src 'self' data: https://tile.openstreetmap.org;") || strings.Contains(csp, "unsafe")) {
+			t.Errorf("leaflet CSP = %s", csp)
+		}
+	}
+}
+
+// Test the bundled Leaflet stylesheet points at hashed images
+func TestLeafletAssets(t *testing.T) {
+	css := string(assets.byName["leaflet/leaflet.css"].data)
+	hashed := assets.byName["leaflet/images/layers.png"].hashed
+	if !strings.Contains(css, "url(images/"+strings.TrimPrefix(hashed, "leaflet/images/")+")") {
+		t.Errorf("leaflet.css not rewritten to %s", hashed)
+	}
+}
+
+// Test map settings are validated
+func TestMapConfigValidation(t *testing.T) {
+	for _, args := range [][]string{
+		{"-map-provider", "bing"},
+		{"-map-provider", "leaflet", "-tile-url", "https://tiles.example/{z}.png"},
+	} {
+		if _, _, err := loadConfig(args, envMap(nil)); err == nil {
+			t.Errorf("%v: expected validation error", args)
+		}
+	}
+	if _, _, err := loadConfig([]string{"-map-provider", "leaflet"}, envMap(nil)); err != nil {
+		t.Errorf("leaflet with default tiles: %v", err)
+	}
+}
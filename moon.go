@@ -19,15 +19,24 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"embed"
+	"encoding/hex"
 	"encoding/json"
+	"flag"
+	"fmt"
 	"html/template"
+	"io/fs"
 	"log/slog"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -35,6 +44,15 @@ import (
 	"github.com/exploded/riseset"
 )
 
+// Templates, static files and the favicon are compiled into the binary so
+// it runs from any directory. In dev mode siteFS is switched to the working
+// directory so edits show up without a rebuild.
+//
+//go:embed templates static favicon.ico
+var embeddedFS embed.FS
+
+var siteFS fs.FS = embeddedFS
+
 // Template cache
 var templates *template.Template
 
@@ -46,17 +64,53 @@ var risetBasSource string
 // on every template being present, and a silent fallback would mask
 // configuration errors in prod.
 func init() {
-	var err error
-	templates, err = template.ParseGlob("templates/*.html")
-	if err != nil {
-		panic("failed to parse templates: " + err.Error())
+	if err := loadTemplates(siteFS); err != nil {
+		panic(err.Error())
 	}
+}
 
-	bas, err := os.ReadFile("templates/riset.bas")
+// loadTemplates parses the HTML templates and reads riset.bas from fsys.
+func loadTemplates(fsys fs.FS) error {
+	t, err := parseTemplates(fsys)
 	if err != nil {
-		panic("failed to read templates/riset.bas: " + err.Error())
+		return err
 	}
+	bas, err := fs.ReadFile(fsys, "templates/riset.bas")
+	if err != nil {
+		return fmt.Errorf("failed to read templates/riset.bas: %w", err)
+	}
+	templates = t
 	risetBasSource = string(bas)
+	return nil
+}
+
+func parseTemplates(fsys fs.FS) (*template.Template, error) {
+	t, err := template.New("").Funcs(templateFuncs).ParseFS(fsys, "templates/*.html")
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse templates: %w", err)
+	}
+	return t, nil
+}
+
+// currentTemplates returns the parsed templates. In dev mode these are the
+// latest reloaded by watchTemplates, or the error from parsing them.
+func currentTemplates() (*template.Template, error) {
+	if !cfg.Dev {
+		return templates, nil
+	}
+	devTemplates.RLock()
+	defer devTemplates.RUnlock()
+	return devTemplates.t, devTemplates.err
+}
+
+// currentRisetBas returns riset.bas, as last reloaded in dev mode.
+func currentRisetBas() string {
+	if !cfg.Dev {
+		return risetBasSource
+	}
+	devTemplates.RLock()
+	defer devTemplates.RUnlock()
+	return devTemplates.bas
 }
 
 // rateLimiter tracks request counts per IP using a sliding window.
@@ -114,14 +168,14 @@ func (rl *rateLimiter) allow(ip string) bool {
 }
 
 // rateLimit is HTTP middleware that returns 429 when an IP exceeds the limit.
+// The outcome is recorded on the request's requestInfo so requestLogger can
+// report it alongside the status code.
 func rateLimit(limiter *rateLimiter, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ip, _, err := net.SplitHostPort(r.RemoteAddr)
-		if err != nil {
-			ip = r.RemoteAddr
-		}
-		if !limiter.allow(ip) {
-			slog.Warn("rate limit exceeded", "ip", ip)
+		if !limiter.allow(clientIP(r)) {
+			if info := requestInfoFrom(r.Context()); info != nil {
+				info.RateLimited = true
+			}
 			http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
 			return
 		}
@@ -129,25 +183,186 @@ func rateLimit(limiter *rateLimiter, next http.Handler) http.Handler {
 	})
 }
 
-// Get Google Maps API key from environment variable
-func getGoogleMapsKey() string {
-	key := os.Getenv("GOOGLE_MAPS_API_KEY")
-	if key == "" {
-		slog.Warn("GOOGLE_MAPS_API_KEY not set in environment")
+// clientIP returns the client's IP address. With client-ip-header set it is
+// the IP in that header, as a proxy such as Cloudflare sets it; the header
+// is trusted, so the server must only be reachable through the proxy.
+// Otherwise, or if the header holds no IP, it is the host part of
+// r.RemoteAddr, or the whole value if it has no port.
+func clientIP(r *http.Request) string {
+	if cfg.ClientIPHeader != "" {
+		if ip := net.ParseIP(strings.TrimSpace(r.Header.Get(cfg.ClientIPHeader))); ip != nil {
+			return ip.String()
+		}
+	}
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
+
+// requestInfo carries per-request state set by the middleware chain and read
+// back by handlers and by requestLogger once the handler returns. Middleware
+// below requestLogger updates it in place rather than replacing the
+// request, since ServeMux records the matched pattern on the request it is
+// given and traceRequests reads it from its own.
+type requestInfo struct {
+	ID          string
+	RateLimited bool
+	Nonce       string // the CSP script nonce
+}
+
+type ctxKey int
+
+const requestInfoKey ctxKey = 0
+
+// requestInfoFrom returns the requestInfo stored by requestLogger, or nil
+// outside a logged request (e.g. handlers called directly from tests).
+func requestInfoFrom(ctx context.Context) *requestInfo {
+	info, _ := ctx.Value(requestInfoKey).(*requestInfo)
+	return info
+}
+
+// requestIDHeader is read from incoming requests (so an upstream proxy can
+// supply its own ID) and echoed on every response.
+const requestIDHeader = "X-Request-ID"
+
+// requestID returns the caller-supplied ID if it looks sane, otherwise a
+// fresh random one. Incoming IDs are restricted to a short, log-safe
+// alphabet so they can't be used to inject into log lines.
+func requestID(r *http.Request) string {
+	if id := r.Header.Get(requestIDHeader); id != "" && len(id) <= 64 {
+		valid := true
+		for _, c := range id {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+				valid = false
+				break
+			}
+		}
+		if valid {
+			return id
+		}
+	}
+	var b [8]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// statusRecorder wraps an http.ResponseWriter to capture the status code and
+// the number of body bytes written.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (sr *statusRecorder) WriteHeader(code int) {
+	if sr.status == 0 {
+		sr.status = code
 	}
-	return key
+	sr.ResponseWriter.WriteHeader(code)
 }
 
-// Add request logging
+func (sr *statusRecorder) Write(b []byte) (int, error) {
+	if sr.status == 0 {
+		sr.status = http.StatusOK
+	}
+	n, err := sr.ResponseWriter.Write(b)
+	sr.bytes += n
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (sr *statusRecorder) Unwrap() http.ResponseWriter {
+	return sr.ResponseWriter
+}
+
+var staticLogCount atomic.Uint64
+
+// shouldLogStatic reports whether a successful /static/ or favicon request
+// should be logged: cfg.LogStaticSample of 0 suppresses them, 1 logs every
+// one, N logs one in N. Errors are always logged.
+func shouldLogStatic() bool {
+	switch n := cfg.LogStaticSample; {
+	case n <= 0:
+		return false
+	case n == 1:
+		return true
+	default:
+		return staticLogCount.Add(1)%uint64(n) == 1
+	}
+}
+
+func isStaticPath(path string) bool {
+	return strings.HasPrefix(path, "/static/") || path == "/favicon.ico"
+}
+
+// requestLogger assigns each request an ID, then logs one line per request
+// with its status, size and rate-limit outcome. Client errors are logged at
+// warn and server errors at error so they reach the monitor portal.
 func requestLogger(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
-		next.ServeHTTP(w, r)
-		slog.Info("request", "method", r.Method, "uri", r.RequestURI, "duration", time.Since(start))
+		info := &requestInfo{ID: requestID(r)}
+		w.Header().Set(requestIDHeader, info.ID)
+		r = r.WithContext(context.WithValue(r.Context(), requestInfoKey, info))
+
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		level := slog.LevelInfo
+		switch {
+		case rec.status >= 500:
+			level = slog.LevelError
+		case rec.status >= 400:
+			level = slog.LevelWarn
+		case isStaticPath(r.URL.Path) && !shouldLogStatic():
+			return
+		}
+		slog.Log(r.Context(), level, "request",
+			"method", r.Method,
+			"uri", r.RequestURI,
+			"status", rec.status,
+			"bytes", rec.bytes,
+			"duration", time.Since(start),
+			"ip", clientIP(r),
+			"user_agent", r.UserAgent(),
+			"rate_limited", info.RateLimited,
+		)
 	})
 }
 
-// Add security headers to all responses
+// contextHandler adds the request ID (and trace ID, when tracing) from the
+// context to every record, so handler logs can be correlated with the
+// request line and its trace.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, rec slog.Record) error {
+	if info := requestInfoFrom(ctx); info != nil {
+		rec.AddAttrs(slog.String("request_id", info.ID))
+	}
+	if s := spanFrom(ctx); s != nil {
+		rec.AddAttrs(slog.String("trace_id", hex.EncodeToString(s.traceID[:])))
+	}
+	return h.Handler.Handle(ctx, rec)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// Add security headers to all responses. The Content-Security-Policy is
+// chosen per route and carries a fresh nonce, which handlers pass to their
+// templates via cspNonce for any <script> tags.
 func securityHeaders(isProd bool, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("X-Content-Type-Options", "nosniff")
@@ -156,94 +371,140 @@ func securityHeaders(isProd bool, next http.Handler) http.Handler {
 		if isProd {
 			w.Header().Set("Strict-Transport-Security", "max-age=63072000; includeSubDomains")
 		}
-		// CSP: all allowances beyond 'self' are driven by Google Maps
-		// (the only remaining external origin).
-		//   script-src: 'unsafe-eval' + blob: + maps.googleapis.com — required by Maps.
-		//   style-src:  fonts.googleapis.com — Maps injects a Roboto/Google Sans
-		//              stylesheet at runtime. 'unsafe-inline' — Maps sets inline
-		//              styles on markers/controls.
-		//   font-src:   fonts.gstatic.com — font files for the above stylesheet.
-		//   img-src:    *.googleapis.com / *.gstatic.com — Maps tiles and icons.
-		w.Header().Set("Content-Security-Policy", "default-src 'self'; script-src 'self' 'unsafe-eval' blob: https://maps.googleapis.com https://static.cloudflareinsights.com; style-src 'self' 'unsafe-inline' https://fonts.googleapis.com; img-src 'self' data: blob: https://*.googleapis.com https://*.gstatic.com; font-src 'self' https://fonts.gstatic.com; connect-src 'self' data: https://maps.googleapis.com https://*.gstatic.com https://cloudflareinsights.com; worker-src blob:")
-		next.ServeHTTP(w, r)
-	})
-}
-
-// Add caching headers for static assets
-func cacheStaticAssets(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Cache static assets for 7 days
-		w.Header().Set("Cache-Control", "public, max-age=604800, immutable")
+		nonce := newNonce()
+		w.Header().Set("Content-Security-Policy", contentSecurityPolicy(r.URL.Path, nonce))
+		w.Header().Set("Reporting-Endpoints", `csp="`+cspReportPath+`"`)
+		info := requestInfoFrom(r.Context())
+		if info == nil {
+			// Outside requestLogger, as in tests of this middleware alone.
+			info = &requestInfo{}
+			r = r.WithContext(context.WithValue(r.Context(), requestInfoKey, info))
+		}
+		info.Nonce = nonce
 		next.ServeHTTP(w, r)
 	})
 }
 
-// limiter allows 60 requests per minute per IP.
-var limiter = newRateLimiter(60, time.Minute)
-
-func makeServerFromMux(mux *http.ServeMux, isProd bool) *http.Server {
+func makeServerFromMux(mux *http.ServeMux) *http.Server {
 	// set timeouts so that a slow or malicious client doesn't
 	// hold resources forever
+	limiter := newRateLimiter(cfg.RateLimit, cfg.RateWindow)
 	return &http.Server{
-		ReadTimeout:  5 * time.Second,
-		WriteTimeout: 10 * time.Second,
-		IdleTimeout:  60 * time.Second,
-		Handler:      requestLogger(rateLimit(limiter, securityHeaders(isProd, mux))),
+		ReadTimeout:  cfg.ReadTimeout,
+		WriteTimeout: cfg.WriteTimeout,
+		IdleTimeout:  cfg.IdleTimeout,
+		Handler:      requestLogger(traceRequests(rateLimit(limiter, securityHeaders(cfg.Prod, compressResponses(mux))))),
 	}
 }
 
-func makeHTTPServer(isProd bool) *http.Server {
+func makeHTTPServer() *http.Server {
 	mux := &http.ServeMux{}
 	mux.HandleFunc("/", handleIndex)
 	mux.HandleFunc("/about", about)
 	mux.HandleFunc("/gettimes", gettimes)
 	mux.HandleFunc("/calendar", calendar)
+	mux.HandleFunc("/c/{slug}", handlePlacePermalink)
+	mux.HandleFunc("/c/{slug}/{month}", handlePlacePermalink)
+	mux.HandleFunc("/l/{hash}", handleGeohashPermalink)
+	mux.HandleFunc("/l/{hash}/{month}", handleGeohashPermalink)
+	mux.HandleFunc("/og/c/{slug}/{date}", handlePlaceCard)
+	mux.HandleFunc("/og/l/{hash}/{date}", handleGeohashCard)
+	mux.HandleFunc("/img/phase.svg", handlePhaseImage)
+	mux.HandleFunc("/img/phase.png", handlePhaseImage)
+	mux.HandleFunc("/img/altitude.svg", handleAltitudeChart)
+	mux.HandleFunc("/day", handleDay)
+	mux.HandleFunc("/compare", handleCompare)
+	mux.HandleFunc("/location", handleLocation)
+	mux.HandleFunc("/saved", handleSaved)
+	mux.HandleFunc("/api/places", handlePlaces)
+	mux.HandleFunc("/api/timezones", handleTimezones)
 	mux.HandleFunc("/archive", handleArchive)
 	mux.HandleFunc("/favicon.ico", handleFavicon)
-	path, _ := os.Getwd()
-	slog.Info("Working directory", "path", path)
-	fileServer := http.FileServer(http.Dir(path + "/static"))
-	mux.Handle("/static/", http.StripPrefix("/static/", cacheStaticAssets(fileServer)))
-	return makeServerFromMux(mux, isProd)
+	mux.HandleFunc(cspReportPath, handleCSPReport)
+	mux.Handle("/static/", http.StripPrefix("/static", staticHandler()))
+	return makeServerFromMux(mux)
 }
 
 func main() {
-	flgProduction := os.Getenv("PROD") == "True"
-
-	httpPort := os.Getenv("PORT")
-	if httpPort == "" {
-		httpPort = "8484"
+	c, printOnly, err := loadConfig(os.Args[1:], os.Getenv)
+	if err == flag.ErrHelp {
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "invalid configuration:", err)
+		os.Exit(2)
+	}
+	if printOnly {
+		if err := printConfig(os.Stdout, c); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	cfg = c
+	risetDays = newRisetCache(cfg)
+
+	if cfg.Dev {
+		// Serve from the working directory and watch the templates there;
+		// parse errors are reported in the browser until fixed.
+		siteFS = os.DirFS(".")
+		reloadTemplates(siteFS)
+		go watchTemplates(context.Background(), siteFS, templatePollInterval)
 	}
-	httpPort = ":" + httpPort
 
 	// Set up log shipping to monitor portal
-	monitorURL := os.Getenv("MONITOR_URL")
-	monitorKey := os.Getenv("MONITOR_API_KEY")
-
-	if monitorURL != "" && monitorKey != "" {
+	monitorURL := strings.TrimRight(cfg.MonitorURL, "/")
+	if monitorURL != "" {
 		ship := logship.New(logship.Options{
 			Endpoint: monitorURL + "/api/logs",
-			APIKey:   monitorKey,
+			APIKey:   cfg.MonitorAPIKey,
 			App:      "moon",
 			Level:    slog.LevelWarn,
 		})
 		defer ship.Shutdown()
 
-		logger := slog.New(logship.Multi(
+		logger := slog.New(contextHandler{logship.Multi(
 			slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelInfo}),
 			ship,
-		))
+		)})
 		slog.SetDefault(logger)
 		slog.Warn("moon app started, log shipping active", "endpoint", monitorURL+"/api/logs")
 	} else {
-		slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelInfo})))
+		slog.SetDefault(slog.New(contextHandler{slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelInfo})}))
 	}
 
-	slog.Info("Production", "enabled", flgProduction)
-	slog.Info("HTTP Port", "port", httpPort)
+	tr, err := newTracer(cfg)
+	if err != nil {
+		slog.Error("tracing setup failed", "error", err)
+		os.Exit(1)
+	}
+	tracing = tr
+	if tracing != nil {
+		slog.Info("Tracing enabled", "exporter", cfg.TracesExporter, "service", tracing.serviceName)
+	}
 
-	httpSrv := makeHTTPServer(flgProduction)
-	httpSrv.Addr = httpPort
+	if cfg.GeoIPDB != "" {
+		db, err := loadGeoIPDB(cfg.GeoIPDB)
+		if err != nil {
+			slog.Error("loading GeoIP database failed", "error", err)
+			os.Exit(1)
+		}
+		geoip = db
+		slog.Info("GeoIP database loaded", "path", cfg.GeoIPDB, "ranges", len(db.ranges))
+	}
+
+	cookieKey() // made now, so a missing cookie-key is warned about at startup
+
+	if cfg.MapProvider == mapGoogle && cfg.GoogleMapsKey == "" {
+		slog.Warn("GOOGLE_MAPS_API_KEY not set; the map on the index page will not load (use -map-provider leaflet to run without a key)")
+	}
+
+	slog.Info("Production", "enabled", cfg.Prod)
+	slog.Info("Dev mode", "enabled", cfg.Dev)
+	slog.Info("HTTP Port", "port", cfg.Port)
+
+	httpSrv := makeHTTPServer()
+	httpSrv.Addr = ":" + strconv.Itoa(cfg.Port)
 
 	// Start server in goroutine
 	go func() {
@@ -260,121 +521,313 @@ func main() {
 	<-quit
 	slog.Info("Shutting down server...")
 
-	// Give outstanding requests 5 seconds to complete
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	// Give outstanding requests time to complete
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
 	defer cancel()
 	if err := httpSrv.Shutdown(ctx); err != nil {
 		slog.Error("Server forced to shutdown", "error", err)
 	}
+	tracing.shutdown(ctx)
+	slog.Info("Rise/set cache", "hits", risetDays.hits.Load(), "misses", risetDays.misses.Load(), "entries", risetDays.len())
 
 	slog.Info("Server exited")
 }
 
 func about(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	if err := templates.ExecuteTemplate(w, "about.html", nil); err != nil {
-		slog.Error("Error executing about template", "error", err)
+	if err := executeTemplate(r.Context(), w, "about.html", nil); err != nil {
+		slog.ErrorContext(r.Context(), "Error executing about template", "error", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 	}
 }
 
-func calendar(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+// calendarView is a resolved calendar request: the location, its zone and
+// the month, week or range of days to show.
+type calendarView struct {
+	Name      string // shown with the coordinates; empty if they were given
+	Place     string // place= to keep in links, if it gave the coordinates
+	Slug      string // permalink slug, if the location is a gazetteer place
+	Lat       float64
+	Lon       float64
+	Zone      string  // IANA zone, or empty for a fixed offset
+	Zon       float64 // the fixed offset in hours, if Zone is empty
+	Year      int
+	Month     int
+	View      string    // viewWeek or viewRange, or empty for a month
+	From, To  time.Time // a week or range's first and last local dates
+	defaulted bool      // some of the location came from the caller's IP or cookie
+	permalink bool      // requested by a /c/ or /l/ path, so links use them too
+}
 
-	Lon, err := strconv.ParseFloat(r.URL.Query().Get("lon"), 64)
-	if err != nil || Lon < -180 || Lon > 180 {
-		Lon = 144 // Default
+// zonAt returns the view's UTC offset in hours at t.
+func (v calendarView) zonAt(t time.Time) float64 {
+	if v.Zone == "" {
+		return v.Zon
 	}
-	Lat, err := strconv.ParseFloat(r.URL.Query().Get("lat"), 64)
-	if err != nil || Lat < -90 || Lat > 90 {
-		Lat = -37 // Default
+	return location{Lat: v.Lat, Lon: v.Lon, Zone: v.Zone}.zon(t)
+}
+
+// localNow returns the current wall-clock time in the view's zone, as a UTC
+// time so the result doesn't depend on the server's local time zone.
+func (v calendarView) localNow() time.Time {
+	now := time.Now().UTC()
+	return now.Add(time.Duration(v.zonAt(now) * float64(time.Hour)))
+}
+
+// monthURL links to the calendar for the view's location in another month:
+// by permalink on permalink pages, otherwise by query string.
+func (v calendarView) monthURL(year, month int) string {
+	if v.permalink {
+		return v.permalinkPath(year, month)
 	}
-	Zon, err := strconv.ParseFloat(r.URL.Query().Get("zon"), 64)
-	if err != nil || Zon < -12 || Zon > 14 {
-		Zon = 10 // Default
+	q := v.query()
+	q.Set("year", strconv.Itoa(year))
+	q.Set("month", strconv.Itoa(month))
+	return "/calendar?" + q.Encode()
+}
+
+// query returns the query parameters giving the view's location and zone.
+func (v calendarView) query() url.Values {
+	q := url.Values{}
+	if v.Place != "" {
+		q.Set("place", v.Place)
+	} else {
+		q.Set("lat", strconv.FormatFloat(v.Lat, 'f', -1, 64))
+		q.Set("lon", strconv.FormatFloat(v.Lon, 'f', -1, 64))
+	}
+	if v.Zone != "" {
+		q.Set("tz", v.Zone)
+	} else {
+		q.Set("zon", strconv.FormatFloat(v.Zon, 'f', -1, 64))
 	}
+	return q
+}
 
-	// Determine which month to show, defaulting to the current month in the
-	// user's timezone. We shift from UTC explicitly so the result doesn't
-	// depend on the server's local time zone.
-	zondur := time.Hour * time.Duration(Zon)
-	now := time.Now().UTC().Add(zondur)
+// calendar serves /calendar from query parameters.
+func calendar(w http.ResponseWriter, r *http.Request) {
+	renderCalendar(w, r, calendarFromQuery(r))
+}
+
+// calendarFromQuery resolves the calendar's query parameters.
+func calendarFromQuery(r *http.Request) calendarView {
+	v := locationFromQuery(r)
+	v.setDays(r.URL.Query())
+	return v
+}
 
-	year, err := strconv.Atoi(r.URL.Query().Get("year"))
-	if err != nil || year < 1 || year > 9999 {
-		year = now.Year()
+// locationFromQuery resolves the location and zone parameters shared by the
+// calendar and day pages: place or saved, lat and lon, and tz or zon.
+func locationFromQuery(r *http.Request) calendarView {
+	// Missing or invalid parameters fall back to the named place or saved
+	// location, if any, and otherwise to the default location (saved, from
+	// GeoIP or config). Its name is only shown when both coordinates come
+	// from it.
+	q := r.URL.Query()
+	def := defaultLocationFor(r)
+	saved := savedLocations(r)
+	var fromPlace place
+	if name := q.Get("place"); name != "" {
+		if p, ok := lookupPlace(name); ok {
+			def, fromPlace = p.location(), p
+		}
+	} else if i, err := strconv.Atoi(q.Get("saved")); err == nil && i >= 0 && i < len(saved) {
+		def = saved[i]
 	}
-	month, err := strconv.Atoi(r.URL.Query().Get("month"))
-	if err != nil || month < 1 || month > 12 {
-		month = int(now.Month())
+	isPlace := fromPlace.Name != ""
+	v := calendarView{Name: def.Name}
+	var err error
+	v.Lon, err = strconv.ParseFloat(q.Get("lon"), 64)
+	if err != nil || v.Lon < -180 || v.Lon > 180 {
+		v.Lon = def.Lon
+		v.defaulted = v.defaulted || !isPlace
+	} else {
+		v.Name = ""
+	}
+	v.Lat, err = strconv.ParseFloat(q.Get("lat"), 64)
+	if err != nil || v.Lat < -90 || v.Lat > 90 {
+		v.Lat = def.Lat
+		v.defaulted = v.defaulted || !isPlace
+	} else {
+		v.Name = ""
+	}
+	if isPlace && v.Name != "" {
+		v.Place = q.Get("place")
+		v.Slug = placeSlug(fromPlace)
 	}
 
-	// Previous / next month navigation (handles year rollovers).
-	prevMonth, prevYear := month-1, year
-	if prevMonth < 1 {
-		prevMonth = 12
-		prevYear--
+	// With a zone each day gets its own offset, so times follow DST changes
+	// within the month; zon fixes one offset for the whole month. Without
+	// either, coordinates from the place or default location keep its zone,
+	// and others use the zone containing them.
+	if tz := q.Get("tz"); tz != "" && validZone(tz) {
+		v.Zone = tz
+	} else if z, err := strconv.ParseFloat(q.Get("zon"), 64); err == nil && z >= -12 && z <= 14 {
+		v.Zon = z
+	} else if v.Lat == def.Lat && v.Lon == def.Lon && def.Zone != "" {
+		v.Zone = def.Zone
+	} else {
+		v.Zone = zoneFor(v.Lat, v.Lon)
+	}
+	if v.Zone != def.Zone {
+		v.Slug = "" // the permalink would show another zone
 	}
-	nextMonth, nextYear := month+1, year
-	if nextMonth > 12 {
-		nextMonth = 1
-		nextYear++
+	if i := savedIndex(saved, v.Lat, v.Lon, v.Zone); v.Name == "" && i >= 0 {
+		v.Name, v.defaulted = saved[i].Name, true
 	}
+	return v
+}
+
+// calendarRow is one day of the calendar.
+type calendarRow struct {
+	Date     string
+	Moon     riseset.RiseSet
+	Sun      riseset.RiseSet
+	Phase    string
+	PhaseImg string
+	DayURL   string
+	IsToday  bool
+}
+
+// row returns the calendar row for the local date d. today is the current
+// local date as the calendar formats it.
+func (v calendarView) row(ctx context.Context, d time.Time, today string) calendarRow {
+	dateStr := d.Format("02-01-2006")
+	zon := v.zonAt(d.Add(12 * time.Hour))
+	start := d.Add(-time.Duration(zon * float64(time.Hour)))
+	return calendarRow{
+		Date:     dateStr,
+		Moon:     computeRiseset(ctx, riseset.Moon, d, v.Lon, v.Lat, zon),
+		Sun:      computeRiseset(ctx, riseset.Sun, d, v.Lon, v.Lat, zon),
+		Phase:    phaseLabel(start, start.Add(24*time.Hour), moonIllumination(start.Add(12*time.Hour))),
+		PhaseImg: phaseImageURL(d, v.Lat),
+		DayURL:   v.dayURL(d),
+		IsToday:  dateStr == today,
+	}
+}
+
+// renderCalendar writes the calendar for a resolved view.
+func renderCalendar(w http.ResponseWriter, r *http.Request, v calendarView) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+	now := v.localNow()
+	from, to := v.span()
 
 	// Today's date string in local time, used to highlight the current row.
 	today := now.Format("02-01-2006")
 
-	// Last day of the requested month: day 0 of the following month.
-	// time.Date normalizes month=13 to January of year+1, so December works
-	// without a special case.
-	lastDay := time.Date(year, time.Month(month+1), 0, 0, 0, 0, 0, time.UTC).Day()
-
-	type gridrow struct {
-		Date    string
-		Moon    riseset.RiseSet
-		Sun     riseset.RiseSet
-		IsToday bool
+	// The page depends only on the resolved parameters and, while it
+	// includes today, today's date (the highlighted row).
+	cacheControl, includesToday := spanCacheControl(now, from, to)
+	var highlight string
+	if includesToday {
+		highlight = today
+	}
+	// Saved locations are listed on the page, so it is the visitor's own.
+	saved := savedLocations(r)
+	w.Header().Add("Vary", "Cookie")
+	if v.defaulted || len(saved) > 0 {
+		cacheControl = cachePerLocation
+	}
+	site := siteURL()
+	etag := computedETag("calendar", v.Name, v.Place, v.Slug, v.Lon, v.Lat, v.Zone, v.Zon, v.View, from.Format(time.DateOnly), to.Format(time.DateOnly), v.permalink, site, highlight, saved)
+	if notModified(w, r, etag, cacheControl) {
+		return
 	}
 
 	type mypar struct {
-		Rows      []gridrow
-		Lon       float64
-		Lat       float64
-		Zon       float64
-		Year      int
-		Month     int
-		MonthName string
-		PrevYear  int
-		PrevMonth int
-		NextYear  int
-		NextMonth int
+		Rows        []calendarRow
+		Name        string
+		Lon         float64
+		Lat         float64
+		Zone        string // IANA zone, or empty for a fixed offset
+		Zon         float64
+		Year        int
+		Month       int
+		MonthName   string
+		Heading     string
+		View        string
+		MonthURL    string
+		WeekURL     string
+		CompareURL  string
+		Query       url.Values // the location, for the range form
+		Saved       []location
+		SavedIndex  int        // of the location shown in Saved, or -1
+		Days        url.Values // the month, week or range, for the saved form
+		SaveZone    string     // the zone to save the location with
+		Return      string     // this page, to come back to after saving
+		From, To    string
+		PrevURL     string
+		NextURL     string
+		Canonical   string
+		SiteURL     string
+		Image       string
+		Title       string
+		Description string
 	}
 
 	var Passme mypar
-	Passme.Lat = Lat
-	Passme.Lon = Lon
-	Passme.Zon = Zon
-	Passme.Year = year
-	Passme.Month = month
-	Passme.MonthName = time.Month(month).String()
-	Passme.PrevYear = prevYear
-	Passme.PrevMonth = prevMonth
-	Passme.NextYear = nextYear
-	Passme.NextMonth = nextMonth
-
-	for day := 1; day <= lastDay; day++ {
-		d := time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC)
-		dateStr := d.Format("02-01-2006")
-		Passme.Rows = append(Passme.Rows, gridrow{
-			Date:    dateStr,
-			Moon:    riseset.Riseset(riseset.Moon, d, Lon, Lat, Zon),
-			Sun:     riseset.Riseset(riseset.Sun, d, Lon, Lat, Zon),
-			IsToday: dateStr == today,
-		})
+	Passme.Name = v.Name
+	Passme.Lat = v.Lat
+	Passme.Lon = v.Lon
+	Passme.Zone = v.Zone
+	Passme.Zon = v.zonAt(time.Now())
+	Passme.Year = v.Year
+	Passme.Month = v.Month
+	Passme.MonthName = time.Month(v.Month).String()
+	Passme.Heading = Passme.MonthName + " " + strconv.Itoa(v.Year)
+	if v.View != "" {
+		Passme.Heading = spanLabel(from, to)
+	}
+	Passme.View = v.View
+	Passme.Query = v.query()
+	Passme.From, Passme.To = from.Format(time.DateOnly), to.Format(time.DateOnly)
+	Passme.PrevURL, Passme.NextURL = v.prevNextURLs()
+	Passme.SaveZone = v.Zone
+	if v.Zone == "" {
+		Passme.SaveZone = zoneFor(v.Lat, v.Lon)
+	}
+	Passme.Saved = saved
+	Passme.SavedIndex = savedIndex(saved, v.Lat, v.Lon, v.Zone)
+	Passme.Days = url.Values{}
+	v.spanParams(Passme.Days)
+	Passme.Return = r.URL.RequestURI()
+
+	// The card shows today if it is on the page, otherwise the first day.
+	cardDate := from
+	if highlight != "" {
+		cardDate = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	}
+	Passme.MonthURL = v.monthURL(cardDate.Year(), int(cardDate.Month()))
+	Passme.WeekURL = v.weekURL(cardDate)
+	Passme.CompareURL = v.compareURL()
+	if v.View == "" {
+		Passme.Canonical = site + v.permalinkPath(v.Year, v.Month)
+	} else {
+		Passme.Canonical = site + v.spanURL()
+	}
+	Passme.SiteURL = site
+	Passme.Image = site + v.cardPath(cardDate)
+
+	where, in := v.Name, "in"
+	if where == "" {
+		where, in = coordLabel(v.Lat, v.Lon), "at"
+	}
+	Passme.Title = fmt.Sprintf("Moon rise and set times for %s, %s", where, Passme.Heading)
+	if v.View == "" {
+		Passme.Description = fmt.Sprintf("Moonrise and moonset for every day of %s %s %s.", Passme.Heading, in, where)
+	} else {
+		Passme.Description = fmt.Sprintf("Moonrise and moonset for each day of %s %s %s.", Passme.Heading, in, where)
+	}
+	if phases := v.phaseSummary(); phases != "" {
+		Passme.Description += " " + phases + "."
+	}
+
+	for d := from; !d.After(to); d = d.AddDate(0, 0, 1) {
+		Passme.Rows = append(Passme.Rows, v.row(r.Context(), d, today))
 	}
 
-	if err := templates.ExecuteTemplate(w, "calendar.html", &Passme); err != nil {
-		slog.Error("Error executing calendar template", "error", err)
+	if err := executeTemplate(r.Context(), w, "calendar.html", &Passme); err != nil {
+		slog.ErrorContext(r.Context(), "Error executing calendar template", "error", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 	}
 }
@@ -388,26 +841,57 @@ func handleIndex(w http.ResponseWriter, r *http.Request) {
 	}
 
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Add("Vary", "Cookie")
+
+	def := defaultLocationFor(r)
+	saved := savedLocations(r)
+	now := time.Now().UTC()
+	now = now.Add(time.Duration(def.zon(now) * float64(time.Hour)))
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	data := struct {
+		Map     mapView
+		Default location
+		Saved   []location // the first is the default
+		SiteURL string
+		Image   string
+		Nonce   string
+	}{
+		Map:     currentMapView(),
+		Default: def,
+		Saved:   saved,
+		SiteURL: siteURL(),
+		Image:   siteURL() + locationCardPath(def, today),
+		Nonce:   cspNonce(r.Context()),
+	}
 
-	data := struct{ GoogleMapsKey string }{GoogleMapsKey: getGoogleMapsKey()}
-
-	if err := templates.ExecuteTemplate(w, "index.html", data); err != nil {
-		slog.Error("Error executing index template", "error", err)
+	if err := executeTemplate(r.Context(), w, "index.html", data); err != nil {
+		slog.ErrorContext(r.Context(), "Error executing index template", "error", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 	}
 }
 
 // timesResponse is the JSON shape returned by /gettimes. On success the
-// riseset.RiseSet fields are populated; on error, Error is set and the
-// rest are zero-valued.
+// riseset.RiseSet fields are populated, along with the zone used (empty for
+// a fixed zon) and the resolved place when the request named one; on
+// error, Error is set and the rest are zero-valued.
 type timesResponse struct {
-	Rise        string `json:",omitempty"`
-	Set         string `json:",omitempty"`
-	AlwaysAbove bool   `json:",omitempty"`
-	AlwaysBelow bool   `json:",omitempty"`
-	Error       string `json:",omitempty"`
+	Rise        string  `json:",omitempty"`
+	Set         string  `json:",omitempty"`
+	AlwaysAbove bool    `json:",omitempty"`
+	AlwaysBelow bool    `json:",omitempty"`
+	Name        string  `json:",omitempty"`
+	Lat         float64 `json:",omitempty"`
+	Lon         float64 `json:",omitempty"`
+	Zone        string  `json:",omitempty"`
+	Date        string  `json:",omitempty"` // the local date, YYYY-MM-DD
+	Phase       string  `json:",omitempty"` // e.g. "Waxing crescent, 38% lit"
+	Error       string  `json:",omitempty"`
 }
 
+// gettimes returns today's moonrise and moonset. The location is given by
+// lon and lat, or by place (a gazetteer name such as "Melbourne, AU"); the
+// time zone by tz (an IANA name), zon (a fixed offset in hours) or, failing
+// both, the named place's zone or the zone containing the coordinates.
 func gettimes(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	enc := json.NewEncoder(w)
@@ -416,58 +900,102 @@ func gettimes(w http.ResponseWriter, r *http.Request) {
 		_ = enc.Encode(timesResponse{Error: msg})
 	}
 
-	a := r.URL.Query().Get("lon")
-	b := r.URL.Query().Get("lat")
-	c := r.URL.Query().Get("zon")
-	if a == "" || b == "" || c == "" {
-		writeErr("missing lon, lat, or zon parameter")
-		return
-	}
-	lon, err := strconv.ParseFloat(a, 64)
-	if err != nil || lon < -180 || lon > 180 {
-		writeErr("invalid lon")
-		return
-	}
-	lat, err := strconv.ParseFloat(b, 64)
-	if err != nil || lat < -90 || lat > 90 {
-		writeErr("invalid lat")
-		return
+	q := r.URL.Query()
+	var resp timesResponse
+	var loc location
+	if name := q.Get("place"); name != "" {
+		p, ok := lookupPlace(name)
+		if !ok {
+			writeErr("unknown place")
+			return
+		}
+		loc = p.location()
+		resp.Name, resp.Lat, resp.Lon, resp.Zone = loc.Name, loc.Lat, loc.Lon, loc.Zone
+	} else {
+		a, b := q.Get("lon"), q.Get("lat")
+		if a == "" || b == "" {
+			writeErr("missing lon and lat, or place parameter")
+			return
+		}
+		var err error
+		loc.Lon, err = strconv.ParseFloat(a, 64)
+		if err != nil || loc.Lon < -180 || loc.Lon > 180 {
+			writeErr("invalid lon")
+			return
+		}
+		loc.Lat, err = strconv.ParseFloat(b, 64)
+		if err != nil || loc.Lat < -90 || loc.Lat > 90 {
+			writeErr("invalid lat")
+			return
+		}
 	}
-	zon, err := strconv.ParseFloat(c, 64)
-	if err != nil || zon < -12 || zon > 14 {
-		writeErr("invalid zon")
-		return
+
+	var zon float64
+	switch tz, c := q.Get("tz"), q.Get("zon"); {
+	case tz != "":
+		if !validZone(tz) {
+			writeErr("invalid tz")
+			return
+		}
+		loc.Zone = tz
+		zon = loc.zon(time.Now())
+	case c != "":
+		var err error
+		zon, err = strconv.ParseFloat(c, 64)
+		if err != nil || zon < -12 || zon > 14 {
+			writeErr("invalid zon")
+			return
+		}
+		loc.Zone = ""
+	default:
+		if loc.Zone == "" {
+			loc.Zone = zoneFor(loc.Lat, loc.Lon)
+		}
+		zon = loc.zon(time.Now())
 	}
+	resp.Zone = loc.Zone
 
 	// Shift UTC "now" by the client's timezone offset so the date portion
 	// matches the client's local wall-clock date. riseset uses only the date.
-	newdate := time.Now().UTC().Add(time.Hour * time.Duration(zon))
-	rs := riseset.Riseset(riseset.Moon, newdate, lon, lat, zon)
-	_ = enc.Encode(timesResponse{
-		Rise:        rs.Rise,
-		Set:         rs.Set,
-		AlwaysAbove: rs.AlwaysAbove,
-		AlwaysBelow: rs.AlwaysBelow,
-	})
+	newdate := time.Now().UTC().Add(time.Duration(zon * float64(time.Hour)))
+
+	// The answer is fixed for the client's local day.
+	etag := computedETag("gettimes", loc.Name, loc.Lon, loc.Lat, zon, newdate.Format(time.DateOnly))
+	if notModified(w, r, etag, cacheFor(untilMidnight(newdate))) {
+		return
+	}
+	rs := computeRiseset(r.Context(), riseset.Moon, newdate, loc.Lon, loc.Lat, zon)
+	resp.Rise, resp.Set = rs.Rise, rs.Set
+	resp.AlwaysAbove, resp.AlwaysBelow = rs.AlwaysAbove, rs.AlwaysBelow
+	resp.Date = newdate.Format(time.DateOnly)
+	start := newdate.Truncate(24 * time.Hour).Add(-time.Duration(zon * float64(time.Hour)))
+	resp.Phase = phaseLabel(start, start.Add(24*time.Hour), moonIllumination(start.Add(12*time.Hour)))
+	_ = enc.Encode(resp)
 }
 
 func handleArchive(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	data := struct{ Code string }{Code: risetBasSource}
-	if err := templates.ExecuteTemplate(w, "archive.html", data); err != nil {
-		slog.Error("Error executing archive template", "error", err)
+	data := struct {
+		Code  string
+		Nonce string
+	}{
+		Code:  currentRisetBas(),
+		Nonce: cspNonce(r.Context()),
+	}
+	if err := executeTemplate(r.Context(), w, "archive.html", data); err != nil {
+		slog.ErrorContext(r.Context(), "Error executing archive template", "error", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 	}
 }
 
 func handleFavicon(w http.ResponseWriter, r *http.Request) {
-	http.ServeFile(w, r, "favicon.ico")
+	http.ServeFileFS(w, r, siteFS, "favicon.ico")
 }
 
 func handle404(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	w.WriteHeader(http.StatusNotFound)
-	if err := templates.ExecuteTemplate(w, "404.html", nil); err != nil {
-		slog.Error("Error executing 404 template", "error", err)
+	if err := executeTemplate(r.Context(), w, "404.html", nil); err != nil {
+		slog.ErrorContext(r.Context(), "Error executing 404 template", "error", err)
 	}
 }
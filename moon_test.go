@@ -1,11 +1,15 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"regexp"
 	"strings"
 	"testing"
+	"time"
 )
 
 // Test the gettimes handler with valid parameters
@@ -41,7 +45,7 @@ func TestGettimesValid(t *testing.T) {
 
 // Test the gettimes handler with missing parameters
 func TestGettimesMissingParams(t *testing.T) {
-	req, err := http.NewRequest("GET", "/gettimes?lon=144&lat=-37", nil)
+	req, err := http.NewRequest("GET", "/gettimes?lon=144", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -150,23 +154,67 @@ func TestSecurityHeadersProd(t *testing.T) {
 	}
 }
 
-// Test cache headers middleware
-func TestCacheStaticAssets(t *testing.T) {
-	handler := cacheStaticAssets(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-	}))
+// Test hashed static URLs are immutable and plain URLs revalidate
+func TestStaticAssetCaching(t *testing.T) {
+	handler := http.StripPrefix("/static", staticHandler())
 
-	req, err := http.NewRequest("GET", "/static/script.js", nil)
-	if err != nil {
-		t.Fatal(err)
+	url := assetURL("script.js")
+	if !strings.HasPrefix(url, "/static/script.") || url == "/static/script.js" {
+		t.Fatalf("assetURL(script.js) = %q, want a hashed name", url)
 	}
 
 	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", url, nil))
+	if got := rr.Header().Get("Cache-Control"); got != "public, max-age=31536000, immutable" {
+		t.Errorf("hashed Cache-Control = %q", got)
+	}
+	if !strings.Contains(rr.Body.String(), "setupMap") {
+		t.Errorf("hashed URL should serve script.js")
+	}
+	etag := rr.Header().Get("ETag")
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/static/script.js", nil))
+	if got := rr.Header().Get("Cache-Control"); got != "public, max-age=0, must-revalidate" {
+		t.Errorf("plain Cache-Control = %q", got)
+	}
+	if rr.Header().Get("ETag") != etag || rr.Header().Get("Content-Type") != "text/javascript; charset=utf-8" {
+		t.Errorf("plain URL headers = %v", rr.Header())
+	}
+
+	req := httptest.NewRequest("GET", "/static/script.js", nil)
+	req.Header.Set("If-None-Match", etag)
+	rr = httptest.NewRecorder()
 	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotModified {
+		t.Errorf("revalidation returned %d, want 304", rr.Code)
+	}
 
-	cacheControl := rr.Header().Get("Cache-Control")
-	if cacheControl != "public, max-age=604800, immutable" {
-		t.Errorf("Cache-Control = %v, want 'public, max-age=604800, immutable'", cacheControl)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/static/nope.js", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("unknown asset returned %d, want 404", rr.Code)
+	}
+}
+
+// Test stylesheet url() references are rewritten to hashed names
+func TestManifestRewritesCSS(t *testing.T) {
+	css := assets.byName["styles.css"]
+	img := assets.byName["moon.jpg"]
+	if !strings.Contains(string(css.data), "url('"+img.hashed+"')") {
+		t.Errorf("styles.css should reference %s", img.hashed)
+	}
+	if !strings.Contains(string(css.data), "url(\"data:image/svg+xml") {
+		t.Errorf("data: URIs should be left alone")
+	}
+}
+
+// Test pages reference hashed asset URLs
+func TestTemplatesUseHashedAssets(t *testing.T) {
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(about).ServeHTTP(rr, httptest.NewRequest("GET", "/about", nil))
+	if want := `href="` + assetURL("styles.css") + `"`; !strings.Contains(rr.Body.String(), want) {
+		t.Errorf("about page missing %s", want)
 	}
 }
 
@@ -186,7 +234,8 @@ func TestHandleIndex(t *testing.T) {
 	}
 
 	body := rr.Body.String()
-	expectedStrings := []string{"Moon Rise and Set", "mapholder", "Latitude", "Longitude"}
+	expectedStrings := []string{"Moon Rise and Set", "mapholder", "Latitude", "Longitude",
+		`<option value="Australia/Melbourne"`, `list="place-suggestions"`}
 	for _, expected := range expectedStrings {
 		if !strings.Contains(body, expected) {
 			t.Errorf("response body should contain %q", expected)
@@ -273,7 +322,7 @@ func TestArchive(t *testing.T) {
 		"DECLARE FUNCTION hm",
 		`id="copy-bas"`,
 		`id="bas-code"`,
-		`src="/static/archive.js"`,
+		`src="` + assetURL("archive.js") + `"`,
 	} {
 		if !strings.Contains(body, want) {
 			t.Errorf("archive body missing %q", want)
@@ -281,6 +330,92 @@ func TestArchive(t *testing.T) {
 	}
 }
 
+// Test gettimes resolves place= and tz= and reports the place it used
+func TestGettimesPlace(t *testing.T) {
+	get := func(url string) timesResponse {
+		rr := httptest.NewRecorder()
+		gettimes(rr, httptest.NewRequest("GET", url, nil))
+		var result timesResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &result); err != nil {
+			t.Fatalf("%s: invalid JSON: %v", url, err)
+		}
+		return result
+	}
+
+	got := get("/gettimes?place=Melbourne,+AU")
+	if got.Error != "" || got.Name != "Melbourne, Australia" || got.Zone != "Australia/Melbourne" || got.Lat != -37.814 {
+		t.Errorf("place: %+v", got)
+	}
+	if got := get("/gettimes?place=melborne&zon=10"); got.Name != "Melbourne, Australia" || got.Zone != "" {
+		t.Errorf("misspelt place with fixed offset: %+v", got)
+	}
+	if got := get("/gettimes?lon=144&lat=-37&tz=Australia/Melbourne"); got.Error != "" || got.Name != "" {
+		t.Errorf("tz: %+v", got)
+	}
+	// Without tz or zon the zone comes from the coordinates.
+	for url, zone := range map[string]string{
+		"/gettimes?lon=139.69&lat=35.69":  "Asia/Tokyo",
+		"/gettimes?lon=-0.13&lat=51.51":   "Europe/London",
+		"/gettimes?lon=-150&lat=-40":      "Etc/GMT+10", // South Pacific
+		"/gettimes?lon=144&lat=-37&zon=9": "",
+	} {
+		if got := get(url); got.Error != "" || got.Zone != zone {
+			t.Errorf("%s: zone %q, want %q (%+v)", url, got.Zone, zone, got)
+		}
+	}
+	for _, url := range []string{
+		"/gettimes?place=Qwxzvbn",
+		"/gettimes?lon=144&lat=-37&tz=Mars/Olympus_Mons",
+		"/gettimes?lon=144&lat=-37&tz=Local",
+	} {
+		if got := get(url); got.Error == "" {
+			t.Errorf("%s: expected Error field, got %+v", url, got)
+		}
+	}
+}
+
+// Test the calendar follows DST within a month for a zone and keeps place= in its links
+func TestCalendarZone(t *testing.T) {
+	get := func(url string) string {
+		rr := httptest.NewRecorder()
+		calendar(rr, httptest.NewRequest("GET", url, nil))
+		return rr.Body.String()
+	}
+	// Melbourne leaves DST on 5 April 2026, so moonrise on the 4th and 5th
+	// differ by about an hour less with a zone than with a fixed offset.
+	rise := func(body, date string) time.Time {
+		m := regexp.MustCompile(`>` + date + `</a></td>\s*<td><img[^>]*></td>\s*<td>(\d\d:\d\d)`).FindStringSubmatch(body)
+		if m == nil {
+			t.Fatalf("no moonrise for %s", date)
+		}
+		tm, _ := time.Parse("15:04", m[1])
+		return tm
+	}
+	zoned := get("/calendar?lat=-37&lon=144&tz=Australia/Melbourne&year=2026&month=4")
+	fixed := get("/calendar?lat=-37&lon=144&zon=11&year=2026&month=4")
+	if !rise(zoned, "04-04-2026").Equal(rise(fixed, "04-04-2026")) {
+		t.Error("zoned and fixed +11 differ before the DST change")
+	}
+	if d := rise(fixed, "05-04-2026").Sub(rise(zoned, "05-04-2026")); d != time.Hour {
+		t.Errorf("after the DST change fixed +11 is %v ahead of zoned, want 1h", d)
+	}
+	if !strings.Contains(zoned, "month=5&amp;tz=Australia%2FMelbourne&amp;year=2026") {
+		t.Error("next link should keep tz")
+	}
+	// Without tz or zon the zone is looked up from the coordinates.
+	if looked := get("/calendar?lat=-37&lon=144&year=2026&month=4"); !strings.Contains(looked, "tz=Australia%2FMelbourne") ||
+		!rise(looked, "05-04-2026").Equal(rise(zoned, "05-04-2026")) {
+		t.Error("calendar without tz should use Australia/Melbourne")
+	}
+
+	body := get("/calendar?place=Melbourne,+AU&year=2026&month=4")
+	for _, want := range []string{"Melbourne, Australia", "place=Melbourne%2C&#43;AU&amp;tz=Australia%2FMelbourne"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("place calendar missing %q", want)
+		}
+	}
+}
+
 // Test that calendar handles invalid params by falling back to defaults
 func TestCalendarInvalidParams(t *testing.T) {
 	req, err := http.NewRequest("GET", "/calendar?lat=abc&lon=xyz&zon=99&year=0&month=13", nil)
@@ -319,3 +454,110 @@ func TestContentTypeHeaders(t *testing.T) {
 		}
 	}
 }
+
+// captureLogs redirects the default slog logger to a buffer for the
+// duration of the test.
+func captureLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	prev := slog.Default()
+	slog.SetDefault(slog.New(contextHandler{slog.NewTextHandler(&buf, nil)}))
+	t.Cleanup(func() { slog.SetDefault(prev) })
+	return &buf
+}
+
+// Test the request logger records status, size and request ID
+func TestRequestLogger(t *testing.T) {
+	logs := captureLogs(t)
+	handler := requestLogger(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		slog.InfoContext(r.Context(), "inside handler")
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("nope"))
+	}))
+
+	req := httptest.NewRequest("GET", "/missing", nil)
+	req.Header.Set("X-Request-ID", "abc-123")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if got := rr.Header().Get("X-Request-ID"); got != "abc-123" {
+		t.Errorf("X-Request-ID = %q, want %q", got, "abc-123")
+	}
+	out := logs.String()
+	for _, want := range []string{"level=WARN msg=request", "status=404", "bytes=4", "request_id=abc-123", "rate_limited=false"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("log output missing %q:\n%s", want, out)
+		}
+	}
+	if !strings.Contains(out, `msg="inside handler" request_id=abc-123`) {
+		t.Errorf("handler log should carry the request ID:\n%s", out)
+	}
+}
+
+// Test that unsafe incoming request IDs are replaced
+func TestRequestIDRejectsUnsafe(t *testing.T) {
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Request-ID", "bad id\ninjected=1")
+	if id := requestID(req); strings.ContainsAny(id, " \n=") || len(id) != 16 {
+		t.Errorf("requestID = %q, want a fresh 16-char hex ID", id)
+	}
+}
+
+// Test that rate-limited requests are flagged in the request log
+func TestRequestLoggerRateLimited(t *testing.T) {
+	logs := captureLogs(t)
+	rl := newRateLimiter(1, time.Minute)
+	handler := requestLogger(rateLimit(rl, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+
+	for i := 0; i < 2; i++ {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	}
+	if out := logs.String(); !strings.Contains(out, "status=429") || !strings.Contains(out, "rate_limited=true") {
+		t.Errorf("expected a rate-limited 429 log line:\n%s", out)
+	}
+}
+
+// Test static-asset log sampling
+func TestStaticLogSampling(t *testing.T) {
+	logs := captureLogs(t)
+	prev := cfg.LogStaticSample
+	t.Cleanup(func() { cfg.LogStaticSample = prev })
+	handler := requestLogger(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	cfg.LogStaticSample = 0
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/static/script.js", nil))
+	if logs.Len() != 0 {
+		t.Errorf("static request should not be logged when suppressed:\n%s", logs)
+	}
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/about", nil))
+	if !strings.Contains(logs.String(), "uri=/about") {
+		t.Errorf("non-static request should still be logged:\n%s", logs)
+	}
+}
+
+// Test that templates and static files are served from the embedded copy,
+// independent of the working directory
+func TestEmbeddedAssets(t *testing.T) {
+	t.Chdir(t.TempDir())
+	if err := loadTemplates(siteFS); err != nil {
+		t.Fatalf("loadTemplates from embedded FS: %v", err)
+	}
+	handler := makeHTTPServer().Handler
+
+	for path, want := range map[string]string{
+		"/static/script.js":          "setupMap",
+		"/static/map-google.js":      "initMap",
+		"/static/leaflet/leaflet.js": "Leaflet",
+		"/favicon.ico":               "",
+		"/about":                     "About Moon Rise and Set",
+	} {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+		if rr.Code != http.StatusOK || rr.Body.Len() == 0 {
+			t.Errorf("%s: status %d, %d bytes", path, rr.Code, rr.Body.Len())
+		}
+		if !strings.Contains(rr.Body.String(), want) {
+			t.Errorf("%s: body missing %q", path, want)
+		}
+	}
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Calendar permalinks are short paths that resolve to the calendar:
+//
+//	/c/melbourne-au/2026-10   a gazetteer place by slug
+//	/l/r1r0fsn/2026-10        any point by geohash
+//
+// The month may be left off for the current month. Every calendar page
+// names its permalink as the canonical URL.
+
+// permalinkPrecision is the geohash length used in /l/ links: 7 characters
+// is a cell about 150 m across, finer than the rise/set cache rounding.
+const permalinkPrecision = 7
+
+const geohashAlphabet = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+// geohashEncode returns the geohash of lat, lon with the given number of
+// characters.
+func geohashEncode(lat, lon float64, precision int) string {
+	latLo, latHi, lonLo, lonHi := -90.0, 90.0, -180.0, 180.0
+	var b strings.Builder
+	bit, ch, even := 0, 0, true
+	for b.Len() < precision {
+		if even {
+			mid := (lonLo + lonHi) / 2
+			if lon >= mid {
+				ch = ch<<1 | 1
+				lonLo = mid
+			} else {
+				ch <<= 1
+				lonHi = mid
+			}
+		} else {
+			mid := (latLo + latHi) / 2
+			if lat >= mid {
+				ch = ch<<1 | 1
+				latLo = mid
+			} else {
+				ch <<= 1
+				latHi = mid
+			}
+		}
+		even = !even
+		if bit++; bit == 5 {
+			b.WriteByte(geohashAlphabet[ch])
+			bit, ch = 0, 0
+		}
+	}
+	return b.String()
+}
+
+// geohashDecode returns the centre of the geohash cell and its half-size in
+// degrees of latitude and longitude. Hashes are case-insensitive and at
+// most 12 characters.
+func geohashDecode(hash string) (lat, lon, latErr, lonErr float64, err error) {
+	if hash == "" || len(hash) > 12 {
+		return 0, 0, 0, 0, errors.New("geohash must be 1 to 12 characters")
+	}
+	latLo, latHi, lonLo, lonHi := -90.0, 90.0, -180.0, 180.0
+	even := true
+	for _, c := range strings.ToLower(hash) {
+		v := strings.IndexRune(geohashAlphabet, c)
+		if v < 0 {
+			return 0, 0, 0, 0, fmt.Errorf("invalid geohash character %q", c)
+		}
+		for mask := 16; mask > 0; mask >>= 1 {
+			if even {
+				mid := (lonLo + lonHi) / 2
+				if v&mask != 0 {
+					lonLo = mid
+				} else {
+					lonHi = mid
+				}
+			} else {
+				mid := (latLo + latHi) / 2
+				if v&mask != 0 {
+					latLo = mid
+				} else {
+					latHi = mid
+				}
+			}
+			even = !even
+		}
+	}
+	return (latLo + latHi) / 2, (lonLo + lonHi) / 2, (latHi - latLo) / 2, (lonHi - lonLo) / 2, nil
+}
+
+// roundWithin rounds x to the fewest decimal places that keep it within
+// err, so decoded coordinates don't show spurious precision.
+func roundWithin(x, err float64) float64 {
+	places := max(0, math.Ceil(-math.Log10(err)))
+	scale := math.Pow(10, places)
+	return math.Round(x*scale) / scale
+}
+
+// slugify turns a place name and country code into a permalink slug:
+// "Saint-Étienne", "FR" becomes "saint-etienne-fr".
+func slugify(name, countryCode string) string {
+	return strings.ReplaceAll(foldName(name), " ", "-") + "-" + strings.ToLower(countryCode)
+}
+
+// placeSlugs maps slugs to gazetteer places. Where a country has two places
+// of the same name the first, larger one gets the slug; the other is only
+// reachable by geohash.
+var placeSlugs = sync.OnceValue(func() map[string]place {
+	m := make(map[string]place, len(places))
+	for _, p := range places {
+		slug := slugify(p.Name, p.CountryCode)
+		if _, dup := m[slug]; !dup {
+			m[slug] = p
+		}
+	}
+	return m
+})
+
+// placeSlug returns the slug that resolves to p, or "" if it has none.
+func placeSlug(p place) string {
+	slug := slugify(p.Name, p.CountryCode)
+	if q, ok := placeSlugs()[slug]; ok && q.Lat == p.Lat && q.Lon == p.Lon {
+		return slug
+	}
+	return ""
+}
+
+// permalinkWhere returns the location part of the view's permalinks: by
+// slug for a gazetteer place, otherwise by geohash.
+func (v calendarView) permalinkWhere() string {
+	if v.Slug != "" {
+		return "/c/" + v.Slug
+	}
+	return "/l/" + geohashEncode(v.Lat, v.Lon, permalinkPrecision)
+}
+
+// permalinkPath returns the permalink for the view's location and the given
+// month.
+func (v calendarView) permalinkPath(year, month int) string {
+	return fmt.Sprintf("%s/%04d-%02d", v.permalinkWhere(), year, month)
+}
+
+// setMonth sets the view's month from a permalink's "2006-01" segment, or to
+// the current month in the view's zone if s is empty.
+func (v *calendarView) setMonth(s string) error {
+	if s == "" {
+		now := v.localNow()
+		v.Year, v.Month = now.Year(), int(now.Month())
+		return nil
+	}
+	t, err := time.Parse("2006-01", s)
+	if err != nil || t.Year() < 1 {
+		return errors.New("month must be YYYY-MM")
+	}
+	v.Year, v.Month = t.Year(), int(t.Month())
+	return nil
+}
+
+// placeView resolves a /c/ slug to a view of that place.
+func placeView(slug string) (calendarView, bool) {
+	slug = strings.ToLower(slug)
+	p, ok := placeSlugs()[slug]
+	if !ok {
+		return calendarView{}, false
+	}
+	loc := p.location()
+	return calendarView{Name: loc.Name, Place: loc.Name, Slug: slug, Lat: p.Lat, Lon: p.Lon, Zone: p.Zone, permalink: true}, true
+}
+
+// geohashView resolves an /l/ geohash to a view of its centre, in the zone
+// containing it.
+func geohashView(hash string) (calendarView, bool) {
+	lat, lon, latErr, lonErr, err := geohashDecode(hash)
+	if err != nil {
+		return calendarView{}, false
+	}
+	lat, lon = roundWithin(lat, latErr), roundWithin(lon, lonErr)
+	return calendarView{Lat: lat, Lon: lon, Zone: zoneFor(lat, lon), permalink: true}, true
+}
+
+// handlePlacePermalink serves /c/{slug} and /c/{slug}/{month}.
+func handlePlacePermalink(w http.ResponseWriter, r *http.Request) {
+	v, ok := placeView(r.PathValue("slug"))
+	servePermalink(w, r, v, ok)
+}
+
+// handleGeohashPermalink serves /l/{hash} and /l/{hash}/{month}.
+func handleGeohashPermalink(w http.ResponseWriter, r *http.Request) {
+	v, ok := geohashView(r.PathValue("hash"))
+	servePermalink(w, r, v, ok)
+}
+
+func servePermalink(w http.ResponseWriter, r *http.Request, v calendarView, ok bool) {
+	if !ok {
+		handle404(w, r)
+		return
+	}
+	if err := v.setMonth(r.PathValue("month")); err != nil {
+		handle404(w, r)
+		return
+	}
+	renderCalendar(w, r, v)
+}
+
+// siteURL returns the configured site-url without a trailing slash, or ""
+// if there is none. The request's Host header is never used: it is chosen
+// by the client, and pages naming it couldn't be cached publicly. Without
+// site-url, canonical links are relative and Open Graph URLs are left out.
+func siteURL() string {
+	return strings.TrimSuffix(cfg.SiteURL, "/")
+}
+
+// coordLabel formats a position as "37.81°S 144.96°E".
+func coordLabel(lat, lon float64) string {
+	ns, ew := "N", "E"
+	if lat < 0 {
+		ns, lat = "S", -lat
+	}
+	if lon < 0 {
+		ew, lon = "W", -lon
+	}
+	return fmt.Sprintf("%.2f°%s %.2f°%s", lat, ns, lon, ew)
+}
+
+// phaseSummary lists the principal phases in the view's days by local
+// date, e.g. "Last quarter 3 Oct, new moon 10 Oct, full moon 26 Oct".
+func (v calendarView) phaseSummary() string {
+	from, to := v.span()
+	end := to.AddDate(0, 0, 1)
+	// Search a day either side in UTC, then keep the phases whose local
+	// date falls in the view.
+	var parts []string
+	for _, e := range moonPhases(from.AddDate(0, 0, -1), end.AddDate(0, 0, 1)) {
+		local := e.Time.Add(time.Duration(v.zonAt(e.Time) * float64(time.Hour)))
+		if local.Before(from) || !local.Before(end) {
+			continue
+		}
+		name := e.Name
+		if len(parts) > 0 {
+			name = strings.ToLower(name)
+		}
+		parts = append(parts, name+" "+local.Format("2 Jan"))
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Test geohashes encode known points and decode back within their cell
+func TestGeohash(t *testing.T) {
+	cases := []struct {
+		lat, lon float64
+		hash     string
+	}{
+		{42.6, -5.6, "ezs42"},
+		{57.64911, 10.40744, "u4pruydqqvj"},
+		{-37.8136, 144.9631, "r1r0fsn"},
+	}
+	for _, c := range cases {
+		if got := geohashEncode(c.lat, c.lon, len(c.hash)); got != c.hash {
+			t.Errorf("geohashEncode(%v, %v) = %q, want %q", c.lat, c.lon, got, c.hash)
+		}
+		lat, lon, latErr, lonErr, err := geohashDecode(strings.ToUpper(c.hash))
+		if err != nil || math.Abs(lat-c.lat) > latErr || math.Abs(lon-c.lon) > lonErr {
+			t.Errorf("geohashDecode(%q) = %v ±%v, %v ±%v, %v", c.hash, lat, latErr, lon, lonErr, err)
+		}
+	}
+	for _, bad := range []string{"", "r1r0fsa", "r1r0fsnr1r0fs"} {
+		if _, _, _, _, err := geohashDecode(bad); err == nil {
+			t.Errorf("geohashDecode(%q) should fail", bad)
+		}
+	}
+}
+
+// Test slugs fold accents and resolve back to the first place of that name
+func TestPlaceSlug(t *testing.T) {
+	if got := slugify("Saint-Étienne", "FR"); got != "saint-etienne-fr" {
+		t.Errorf("slugify = %q", got)
+	}
+	p, ok := placeSlugs()["melbourne-au"]
+	if !ok || p.Zone != "Australia/Melbourne" {
+		t.Fatalf("melbourne-au = %+v, %v", p, ok)
+	}
+	if got := placeSlug(p); got != "melbourne-au" {
+		t.Errorf("placeSlug(Melbourne) = %q", got)
+	}
+	if got := placeSlug(place{Name: "Melbourne", CountryCode: "AU", Lat: 1, Lon: 2}); got != "" {
+		t.Errorf("placeSlug of another Melbourne = %q, want none", got)
+	}
+}
+
+// Test permalinks render the calendar with canonical and Open Graph tags
+func TestPermalinks(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/c/{slug}", handlePlacePermalink)
+	mux.HandleFunc("/c/{slug}/{month}", handlePlacePermalink)
+	mux.HandleFunc("/l/{hash}", handleGeohashPermalink)
+	mux.HandleFunc("/l/{hash}/{month}", handleGeohashPermalink)
+	get := func(path string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+		return rr
+	}
+	old := cfg.SiteURL
+	cfg.SiteURL = "https://moon.example.com"
+	defer func() { cfg.SiteURL = old }()
+
+	body := get("/c/Melbourne-AU/2026-10").Body.String()
+	for _, want := range []string{
+		`<title>Moon rise and set times for Melbourne, Australia, October 2026</title>`,
+		`<link rel="canonical" href="https://moon.example.com/c/melbourne-au/2026-10">`,
+		`<meta property="og:url" content="https://moon.example.com/c/melbourne-au/2026-10">`,
+		`Last quarter 3 Oct, new moon 11 Oct, first quarter 19 Oct, full moon 26 Oct.`,
+		`href="/c/melbourne-au/2026-09" rel="prev"`,
+		`href="/c/melbourne-au/2026-11" rel="next"`,
+		`">31-10-2026</a></td>`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("/c/ page missing %q", want)
+		}
+	}
+
+	body = get("/l/r1r0fsn/2026-04").Body.String()
+	for _, want := range []string{
+		`Latitude: -37.8143 Longitude: 144.9625`,
+		`Australia/Melbourne`,
+		`<link rel="canonical" href="https://moon.example.com/l/r1r0fsn/2026-04">`,
+		`every day of April 2026 at 37.81°S 144.96°E.`,
+		`href="/l/r1r0fsn/2026-05" rel="next"`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("/l/ page missing %q", want)
+		}
+	}
+
+	if rr := get("/c/melbourne-au"); rr.Code != http.StatusOK {
+		t.Errorf("/c/ without month = %d, want 200", rr.Code)
+	}
+	for _, path := range []string{"/c/atlantis-xx/2026-10", "/c/melbourne-au/2026-13", "/l/r1r0fsa/2026-10", "/l/r1r0fsn/october"} {
+		if rr := get(path); rr.Code != http.StatusNotFound {
+			t.Errorf("%s = %d, want 404", path, rr.Code)
+		}
+	}
+}
+
+// Test calendar pages name the permalink as canonical, absolute only with
+// site-url
+func TestCalendarCanonical(t *testing.T) {
+	get := func(url string) string {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", url, nil)
+		req.Host = "evil.example"
+		calendar(rr, req)
+		return rr.Body.String()
+	}
+	body := get("/calendar?place=Melbourne&year=2026&month=10")
+	if !strings.Contains(body, `<link rel="canonical" href="/c/melbourne-au/2026-10">`) {
+		t.Error("place calendar should be canonical at its slug")
+	}
+	if strings.Contains(body, "evil.example") || strings.Contains(body, "og:url") || strings.Contains(body, "og:image") {
+		t.Error("without site-url the page shouldn't name the request's host or carry Open Graph URLs")
+	}
+	// Another zone than the place's can't be expressed by the slug.
+	if body := get("/calendar?place=Melbourne&tz=UTC&year=2026&month=10"); !strings.Contains(body, `href="/l/r1r0fsp/2026-10"`) {
+		t.Error("place calendar in another zone should be canonical by geohash")
+	}
+
+	old := cfg.SiteURL
+	cfg.SiteURL = "https://moon.example.com/"
+	defer func() { cfg.SiteURL = old }()
+	if body := get("/calendar?lat=-37.8136&lon=144.9631&year=2026&month=10"); !strings.Contains(body, `<link rel="canonical" href="https://moon.example.com/l/r1r0fsn/2026-10">`) {
+		t.Error("canonical should use site-url")
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"image"
+	"image/color"
+	"image/png"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Moon colours for the phase images and social cards.
+var (
+	moonLitColor  = color.RGBA{0xf2, 0xef, 0xe2, 0xff}
+	moonDarkColor = color.RGBA{0x2b, 0x34, 0x52, 0xff}
+)
+
+// moonAppearance is the Moon as drawn for an observer: its lit fraction
+// and the angle on screen of its bright limb, in degrees clockwise from
+// the right.
+type moonAppearance struct {
+	Lit  float64
+	Limb float64
+}
+
+// moonAppearanceAt returns the Moon's appearance at t from the given
+// hemisphere. Facing the Moon from the north, celestial north is up and
+// east to the left; from the south the Moon is seen the other way up, so
+// the image is turned half a turn and a waxing crescent is lit on the left.
+func moonAppearanceAt(t time.Time, south bool) moonAppearance {
+	// The position angle runs anticlockwise from north (up) through east
+	// (left); screen angles run clockwise from the right.
+	limb := -90 - moonBrightLimb(t)
+	if south {
+		limb += 180
+	}
+	return moonAppearance{Lit: moonIllumination(t), Limb: norm360(limb)}
+}
+
+// litAt reports whether the point x, y of the unit disc, y down, is lit.
+// Turned so the bright limb is to the right, the terminator is a
+// half-ellipse from the top to the bottom of the disc, whose semi-axis runs
+// from 1 (new) through 0 (quarter) to -1 (full).
+func (m moonAppearance) litAt(x, y float64) bool {
+	s, c := math.Sincos(m.Limb * deg)
+	x, y = x*c+y*s, y*c-x*s
+	return x > (1-2*m.Lit)*math.Sqrt(1-y*y)
+}
+
+// drawMoonDisc draws the Moon centred at c with radius r over what is
+// already in img. Edge pixels are supersampled.
+func drawMoonDisc(img *image.RGBA, c image.Point, r int, m moonAppearance) {
+	const n = 4 // samples per pixel in each direction
+	rf := float64(r)
+	for py := c.Y - r; py < c.Y+r; py++ {
+		for px := c.X - r; px < c.X+r; px++ {
+			inDisc, inLit := 0, 0
+			for sy := 0; sy < n; sy++ {
+				for sx := 0; sx < n; sx++ {
+					x := (float64(px-c.X) + (float64(sx)+0.5)/n) / rf
+					y := (float64(py-c.Y) + (float64(sy)+0.5)/n) / rf
+					if x*x+y*y > 1 {
+						continue
+					}
+					inDisc++
+					if m.litAt(x, y) {
+						inLit++
+					}
+				}
+			}
+			if inDisc == 0 {
+				continue
+			}
+			// Composite the dark and lit colours, weighted by their
+			// coverage, over the premultiplied background.
+			bg := img.RGBAAt(px, py)
+			disc, lit := float64(inDisc)/(n*n), float64(inLit)/(n*n)
+			over := func(b, d, l uint8) uint8 {
+				return uint8(math.Round(float64(b)*(1-disc) + float64(d)*(disc-lit) + float64(l)*lit))
+			}
+			img.SetRGBA(px, py, color.RGBA{
+				over(bg.R, moonDarkColor.R, moonLitColor.R),
+				over(bg.G, moonDarkColor.G, moonLitColor.G),
+				over(bg.B, moonDarkColor.B, moonLitColor.B),
+				over(bg.A, 0xff, 0xff),
+			})
+		}
+	}
+}
+
+// phaseSVG returns an SVG of the Moon, size pixels square, with label as
+// its title.
+func phaseSVG(m moonAppearance, size int, label string) []byte {
+	// The bright half of the limb, then back along the terminator: it
+	// bulges toward the bright limb before the quarter and away after it.
+	sweep := 0
+	if m.Lit > 0.5 {
+		sweep = 1
+	}
+	var b bytes.Buffer
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="-1 -1 2 2" width="%d" height="%d" role="img">`, size, size)
+	b.WriteString("<title>" + html.EscapeString(label) + "</title>")
+	fmt.Fprintf(&b, `<circle r="1" fill="#%02x%02x%02x"/>`, moonDarkColor.R, moonDarkColor.G, moonDarkColor.B)
+	fmt.Fprintf(&b, `<path transform="rotate(%.1f)" fill="#%02x%02x%02x" d="M0,-1A1,1 0 0 1 0,1A%.4f,1 0 0 %d 0,-1Z"/>`,
+		m.Limb, moonLitColor.R, moonLitColor.G, moonLitColor.B, math.Abs(1-2*m.Lit), sweep)
+	b.WriteString("</svg>\n")
+	return b.Bytes()
+}
+
+// phaseLabel describes the Moon's phase on the day [start, end) with its
+// lit fraction at noon, e.g. "Waxing crescent, 38% lit".
+func phaseLabel(start, end time.Time, lit float64) string {
+	return fmt.Sprintf("%s, %d%% lit", dayPhase(start, end), int(math.Round(lit*100)))
+}
+
+// Size limits for the phase images, in pixels.
+const (
+	defaultPhaseSize = 128
+	minPhaseSize     = 16
+	maxPhaseSize     = 512
+)
+
+// phaseImageURL returns the path of the phase image for date at latitude
+// lat.
+func phaseImageURL(date time.Time, lat float64) string {
+	return "/img/phase.svg?date=" + date.Format(time.DateOnly) + "&lat=" + strconv.FormatFloat(lat, 'f', -1, 64)
+}
+
+// handlePhaseImage serves /img/phase.svg and /img/phase.png: the Moon at
+// noon UTC on date (default today) as seen from the hemisphere of lat
+// (default north), size pixels square (default 128). The PNG is
+// transparent around the disc.
+func handlePhaseImage(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	now := time.Now().UTC()
+	date := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	cacheControl := cacheFor(untilMidnight(now))
+	if s := q.Get("date"); s != "" {
+		d, err := time.Parse(time.DateOnly, s)
+		if err != nil {
+			http.Error(w, "date must be YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+		date, cacheControl = d, cacheImmutable
+	}
+	var lat float64
+	if s := q.Get("lat"); s != "" {
+		var err error
+		lat, err = strconv.ParseFloat(s, 64)
+		if err != nil || lat < -90 || lat > 90 {
+			http.Error(w, "lat must be between -90 and 90", http.StatusBadRequest)
+			return
+		}
+	}
+	isPNG := r.URL.Path == "/img/phase.png"
+	size := defaultPhaseSize
+	if s := q.Get("size"); s != "" {
+		var err error
+		size, err = strconv.Atoi(s)
+		if err != nil || size < minPhaseSize || size > maxPhaseSize {
+			http.Error(w, fmt.Sprintf("size must be %d to %d", minPhaseSize, maxPhaseSize), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Only the hemisphere matters, so all latitudes on one side share a tag.
+	south := lat < 0
+	etag := computedETag("phase", r.URL.Path, date.Format(time.DateOnly), south, size)
+	if notModified(w, r, etag, cacheControl) {
+		return
+	}
+	noon := date.Add(12 * time.Hour)
+	m := moonAppearanceAt(noon, south)
+	if !isPNG {
+		w.Header().Set("Content-Type", "image/svg+xml")
+		w.Write(phaseSVG(m, size, phaseLabel(date, date.AddDate(0, 0, 1), m.Lit)))
+		return
+	}
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	drawMoonDisc(img, image.Pt(size/2, size/2), size/2, m)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		slog.ErrorContext(r.Context(), "Error encoding phase image", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Write(buf.Bytes())
+}
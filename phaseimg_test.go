@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test the lit side follows the phase and is mirrored in the south
+func TestMoonAppearance(t *testing.T) {
+	litSide := func(m moonAppearance) string {
+		left, right := m.litAt(-0.9, 0), m.litAt(0.9, 0)
+		switch {
+		case left && right:
+			return "both"
+		case left:
+			return "left"
+		case right:
+			return "right"
+		}
+		return "neither"
+	}
+	noon := func(y int, m time.Month, d int) time.Time { return time.Date(y, m, d, 12, 0, 0, 0, time.UTC) }
+	cases := []struct {
+		t           time.Time
+		north, want string
+	}{
+		{noon(2024, 1, 14), "right", "left"}, // waxing crescent
+		{noon(2024, 2, 6), "left", "right"},  // waning crescent
+		{noon(2024, 1, 25), "both", "both"},  // full
+		{noon(2024, 1, 11), "neither", "neither"},
+	}
+	for _, c := range cases {
+		if got := litSide(moonAppearanceAt(c.t, false)); got != c.north {
+			t.Errorf("%v from the north: lit %s, want %s", c.t, got, c.north)
+		}
+		if got := litSide(moonAppearanceAt(c.t, true)); got != c.want {
+			t.Errorf("%v from the south: lit %s, want %s", c.t, got, c.want)
+		}
+	}
+
+	// drawMoonDisc shades what litAt says, over a transparent background.
+	m := moonAppearanceAt(noon(2024, 1, 14), false)
+	img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	drawMoonDisc(img, image.Pt(50, 50), 40, m)
+	if img.RGBAAt(85, 50) != moonLitColor || img.RGBAAt(15, 50) != moonDarkColor || img.RGBAAt(2, 2).A != 0 {
+		t.Errorf("disc pixels: right %v, left %v, corner %v", img.RGBAAt(85, 50), img.RGBAAt(15, 50), img.RGBAAt(2, 2))
+	}
+}
+
+// Test the phase image endpoints serve SVG and PNG and validate parameters
+func TestHandlePhaseImage(t *testing.T) {
+	get := func(url string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		handlePhaseImage(rr, httptest.NewRequest("GET", url, nil))
+		return rr
+	}
+
+	rr := get("/img/phase.svg?date=2024-01-14&lat=-37.8")
+	body := rr.Body.String()
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "image/svg+xml" || rr.Header().Get("Cache-Control") != cacheImmutable {
+		t.Fatalf("svg = %d %v", rr.Code, rr.Header())
+	}
+	if !strings.Contains(body, "<title>Waxing crescent, 13% lit</title>") || !regexp.MustCompile(`rotate\((1[5-9]\d|2[0-2]\d)\.\d\)`).MatchString(body) {
+		t.Errorf("svg for a southern waxing crescent: %s", body)
+	}
+	// All latitudes in a hemisphere share an ETag.
+	if get("/img/phase.svg?date=2024-01-14&lat=-10").Header().Get("ETag") != rr.Header().Get("ETag") {
+		t.Error("southern latitudes should share an ETag")
+	}
+	if get("/img/phase.svg?date=2024-01-14&lat=10").Header().Get("ETag") == rr.Header().Get("ETag") {
+		t.Error("hemispheres should differ")
+	}
+
+	rr = get("/img/phase.png?date=2024-01-25&size=64")
+	img, err := png.Decode(bytes.NewReader(rr.Body.Bytes()))
+	if rr.Code != http.StatusOK || err != nil || img.Bounds().Dx() != 64 {
+		t.Fatalf("png = %d, %v", rr.Code, err)
+	}
+	if _, _, _, a := img.At(0, 0).RGBA(); a != 0 {
+		t.Error("png corner should be transparent")
+	}
+
+	if rr := get("/img/phase.svg"); rr.Code != http.StatusOK || rr.Header().Get("Cache-Control") == cacheImmutable {
+		t.Errorf("today's image = %d, %q", rr.Code, rr.Header().Get("Cache-Control"))
+	}
+	for _, url := range []string{"/img/phase.svg?date=14-01-2024", "/img/phase.svg?lat=91", "/img/phase.png?size=8", "/img/phase.png?size=big"} {
+		if rr := get(url); rr.Code != http.StatusBadRequest {
+			t.Errorf("%s = %d, want 400", url, rr.Code)
+		}
+	}
+}
+
+// Test the calendar and gettimes show the phase
+func TestPhaseInPages(t *testing.T) {
+	rr := httptest.NewRecorder()
+	calendar(rr, httptest.NewRequest("GET", "/calendar?place=Melbourne&year=2026&month=10", nil))
+	for _, want := range []string{
+		`src="/img/phase.svg?date=2026-10-26&amp;lat=-37.814"`,
+		`alt="Full moon, 100% lit"`,
+	} {
+		if !strings.Contains(rr.Body.String(), want) {
+			t.Errorf("calendar missing %q", want)
+		}
+	}
+
+	rr = httptest.NewRecorder()
+	gettimes(rr, httptest.NewRequest("GET", "/gettimes?lat=-37.8&lon=144.9&tz=Australia/Melbourne", nil))
+	var resp timesResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil || resp.Date == "" || !strings.Contains(resp.Phase, "% lit") {
+		t.Errorf("gettimes = %+v, %v", resp, err)
+	}
+}
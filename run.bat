@@ -38,4 +38,4 @@ echo Starting server on http://localhost:8484
 echo Press Ctrl+C to stop the server
 echo.
 
-moon.exe
+moon.exe -dev
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Saved locations (home, a dark site, a cabin) are kept in the browser, in
+// a cookie holding their JSON and an HMAC of it, so the server needs no
+// database and rejects values it didn't write. The first saved location
+// replaces the configured and GeoIP defaults. They are added, removed and
+// reordered by POSTing a form to /saved, and chosen on the calendar with
+//
+//	/calendar?saved=1
+//
+// Calendar and day pages for a saved location's coordinates show its name.
+
+// savedCookieName names the cookie holding the saved locations.
+const savedCookieName = "saved"
+
+// Limits on the saved locations. Browsers keep cookies of up to 4096
+// bytes, including the name and attributes.
+const (
+	maxSavedLocations   = 8
+	maxSavedNameLen     = 40 // characters
+	maxSavedCookieBytes = 3072
+	savedCookieMaxAge   = 400 * 24 * time.Hour // the most browsers allow
+)
+
+// minCookieKeyLen is the shortest cookie-key accepted.
+const minCookieKeyLen = 32
+
+// errTooManySaved is returned when saving would exceed the limits.
+var errTooManySaved = errors.New("too many saved locations; remove one first")
+
+// cookieKey returns the key signing the cookie: the configured cookie-key,
+// or a random one made on first use, which main makes at startup.
+var cookieKey = sync.OnceValue(func() []byte {
+	if cfg.CookieKey != "" {
+		return []byte(cfg.CookieKey)
+	}
+	slog.Warn("COOKIE_KEY not set; saved locations will be lost when the server restarts")
+	key := make([]byte, 32)
+	rand.Read(key)
+	return key
+})
+
+// savedMAC returns the signature of payload.
+func savedMAC(payload string) []byte {
+	mac := hmac.New(sha256.New, cookieKey())
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+// encodeSaved returns the cookie value for locs.
+func encodeSaved(locs []location) (string, error) {
+	b, err := json.Marshal(locs)
+	if err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(b)
+	value := payload + "." + base64.RawURLEncoding.EncodeToString(savedMAC(payload))
+	if len(savedCookieName)+1+len(value) > maxSavedCookieBytes {
+		return "", errTooManySaved
+	}
+	return value, nil
+}
+
+// decodeSaved returns the locations in a cookie value, or an error if it
+// wasn't signed with the current key or holds an invalid location.
+func decodeSaved(value string) ([]location, error) {
+	payload, sig, ok := strings.Cut(value, ".")
+	if !ok {
+		return nil, errors.New("unsigned")
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(mac, savedMAC(payload)) {
+		return nil, errors.New("bad signature")
+	}
+	b, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, err
+	}
+	var locs []location
+	if err := json.Unmarshal(b, &locs); err != nil {
+		return nil, err
+	}
+	if len(locs) > maxSavedLocations {
+		return nil, errTooManySaved
+	}
+	for _, l := range locs {
+		if err := checkSaved(l); err != nil {
+			return nil, err
+		}
+	}
+	return locs, nil
+}
+
+// checkSaved reports whether l can be saved.
+func checkSaved(l location) error {
+	switch {
+	case l.Name == "":
+		return errors.New("a name is required")
+	case utf8.RuneCountInString(l.Name) > maxSavedNameLen:
+		return fmt.Errorf("names are limited to %d characters", maxSavedNameLen)
+	case l.Lat < -90 || l.Lat > 90:
+		return errors.New("latitude must be between -90 and 90")
+	case l.Lon < -180 || l.Lon > 180:
+		return errors.New("longitude must be between -180 and 180")
+	case !validZone(l.Zone):
+		return fmt.Errorf("unknown time zone %q", l.Zone)
+	}
+	return nil
+}
+
+// savedLocations returns the request's saved locations, default first. A
+// missing, tampered or invalid cookie counts as none.
+func savedLocations(r *http.Request) []location {
+	c, err := r.Cookie(savedCookieName)
+	if err != nil {
+		return nil
+	}
+	locs, err := decodeSaved(c.Value)
+	if err != nil {
+		slog.DebugContext(r.Context(), "Ignoring saved locations cookie", "error", err)
+		return nil
+	}
+	return locs
+}
+
+// setSavedLocations sets the cookie to hold locs, deleting it if there are
+// none.
+func setSavedLocations(w http.ResponseWriter, locs []location) error {
+	c := &http.Cookie{
+		Name:     savedCookieName,
+		Path:     "/",
+		MaxAge:   int(savedCookieMaxAge / time.Second),
+		HttpOnly: true,
+		Secure:   cfg.Prod,
+		SameSite: http.SameSiteLaxMode,
+	}
+	if len(locs) == 0 {
+		c.MaxAge = -1
+	} else {
+		value, err := encodeSaved(locs)
+		if err != nil {
+			return err
+		}
+		c.Value = value
+	}
+	http.SetCookie(w, c)
+	return nil
+}
+
+// savedIndex returns the index of the saved location at lat, lon in zone,
+// or -1 if there is none.
+func savedIndex(saved []location, lat, lon float64, zone string) int {
+	for i, s := range saved {
+		if s.Lat == lat && s.Lon == lon && s.Zone == zone {
+			return i
+		}
+	}
+	return -1
+}
+
+// localPath returns s if it is a path on this site, otherwise fallback.
+// Paths starting "//" or containing a backslash could name another host,
+// as could ones with whitespace or control characters, which browsers
+// strip: "/\t/evil.example" is followed as "//evil.example". The decoded
+// path is held to the same rules.
+func localPath(s, fallback string) string {
+	u, err := url.Parse(s)
+	if err != nil || u.Scheme != "" || u.Host != "" || !strings.HasPrefix(s, "/") {
+		return fallback
+	}
+	for _, p := range []string{s, u.Path} {
+		if strings.HasPrefix(p, "//") || strings.Contains(p, `\`) || strings.IndexFunc(p, notInPath) >= 0 {
+			return fallback
+		}
+	}
+	return s
+}
+
+// notInPath reports whether r is whitespace or a control character.
+func notInPath(r rune) bool {
+	return unicode.IsSpace(r) || unicode.IsControl(r)
+}
+
+// savedCrossOrigin rejects forms posted from other sites, which could
+// otherwise replace a visitor's saved locations.
+var savedCrossOrigin = http.NewCrossOriginProtection()
+
+// handleSaved serves POST /saved, changing the saved locations and then
+// returning to the page given by return. The action is one of:
+//
+//   - save: add name, lat, lon and tz, replacing any location of that name
+//   - delete: remove the location at index
+//   - default: move the location at index to the front
+func handleSaved(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := savedCrossOrigin.Check(r); err != nil {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, 4096)
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	locs := savedLocations(r)
+	var err error
+	switch r.PostForm.Get("action") {
+	case "save":
+		locs, err = saveLocation(locs, r.PostForm)
+	case "delete", "default":
+		i, convErr := strconv.Atoi(r.PostForm.Get("index"))
+		if convErr != nil || i < 0 || i >= len(locs) {
+			err = errors.New("no such saved location")
+			break
+		}
+		l := locs[i]
+		locs = slices.Delete(locs, i, i+1)
+		if r.PostForm.Get("action") == "default" {
+			locs = slices.Insert(locs, 0, l)
+		}
+	default:
+		err = errors.New("unknown action")
+	}
+	if err == nil {
+		err = setSavedLocations(w, locs)
+	}
+	if err != nil {
+		http.Error(w, "Bad Request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	http.Redirect(w, r, localPath(r.PostForm.Get("return"), "/calendar"), http.StatusSeeOther)
+}
+
+// saveLocation returns saved with the location in form added, or replacing
+// the one with the same name. Without a valid tz it takes the zone
+// containing the coordinates.
+func saveLocation(saved []location, form url.Values) ([]location, error) {
+	get := func(k string) string { return strings.TrimSpace(form.Get(k)) }
+	l := location{Name: get("name"), Zone: get("tz")}
+	var err1, err2 error
+	l.Lat, err1 = strconv.ParseFloat(get("lat"), 64)
+	l.Lon, err2 = strconv.ParseFloat(get("lon"), 64)
+	if err1 != nil || err2 != nil {
+		return nil, errors.New("latitude and longitude must be numbers")
+	}
+	if !validZone(l.Zone) && l.Lat >= -90 && l.Lat <= 90 && l.Lon >= -180 && l.Lon <= 180 {
+		l.Zone = zoneFor(l.Lat, l.Lon)
+	}
+	if err := checkSaved(l); err != nil {
+		return nil, err
+	}
+	if i := slices.IndexFunc(saved, func(s location) bool { return strings.EqualFold(s.Name, l.Name) }); i >= 0 {
+		saved[i] = l
+		return saved, nil
+	}
+	if len(saved) == maxSavedLocations {
+		return nil, errTooManySaved
+	}
+	return append(saved, l), nil
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+var testSaved = []location{
+	{Name: "Home", Lat: -37.8136, Lon: 144.9631, Zone: "Australia/Melbourne"},
+	{Name: "Dark site", Lat: -36.95, Lon: 144.05, Zone: "Australia/Melbourne"},
+	{Name: "Cabin", Lat: 61.2, Lon: -149.9, Zone: "America/Anchorage"},
+}
+
+// savedCookie returns a request cookie holding locs.
+func savedCookie(t *testing.T, locs []location) *http.Cookie {
+	t.Helper()
+	value, err := encodeSaved(locs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &http.Cookie{Name: savedCookieName, Value: value}
+}
+
+// Test the cookie round-trips and rejects tampered or invalid values
+func TestSavedCookie(t *testing.T) {
+	value, err := encodeSaved(testSaved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	locs, err := decodeSaved(value)
+	if err != nil || len(locs) != 3 || locs[2] != testSaved[2] {
+		t.Fatalf("decodeSaved = %+v, %v", locs, err)
+	}
+
+	payload, sig, _ := strings.Cut(value, ".")
+	forged, _ := encodeSaved([]location{{Name: "Elsewhere", Lat: 10, Lon: 10, Zone: "UTC"}})
+	forgedPayload, _, _ := strings.Cut(forged, ".")
+	invalid, _ := encodeSaved([]location{{Name: "Mars", Lat: 10, Lon: 10, Zone: "Mars/Olympus"}})
+	for _, bad := range []string{"", payload, payload + ".", forgedPayload + "." + sig, payload + "x." + sig, invalid} {
+		if locs, err := decodeSaved(bad); err == nil {
+			t.Errorf("decodeSaved(%q) = %+v, want an error", bad, locs)
+		}
+	}
+
+	var many []location
+	for range 40 {
+		many = append(many, location{Name: strings.Repeat("x", maxSavedNameLen), Lat: -37.8136, Lon: 144.9631, Zone: "America/Argentina/ComodRivadavia"})
+	}
+	if _, err := encodeSaved(many); err != errTooManySaved {
+		t.Errorf("encodeSaved of %d locations = %v", len(many), err)
+	}
+}
+
+// Test saving, replacing, reordering and removing saved locations
+func TestHandleSaved(t *testing.T) {
+	var cookie *http.Cookie
+	post := func(form url.Values) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest("POST", "/saved", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		rr := httptest.NewRecorder()
+		handleSaved(rr, req)
+		if cs := rr.Result().Cookies(); len(cs) == 1 {
+			cookie = cs[0]
+		}
+		return rr
+	}
+	names := func() string {
+		locs, _ := decodeSaved(cookie.Value)
+		var ns []string
+		for _, l := range locs {
+			ns = append(ns, l.Name)
+		}
+		return strings.Join(ns, ",")
+	}
+
+	rr := post(url.Values{"action": {"save"}, "name": {" Home "}, "lat": {"-37.8136"}, "lon": {"144.9631"}, "tz": {"Australia/Melbourne"}, "return": {"/calendar?year=2026"}})
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/calendar?year=2026" {
+		t.Fatalf("save = %d %v", rr.Code, rr.Header())
+	}
+	if !cookie.HttpOnly || cookie.SameSite != http.SameSiteLaxMode || cookie.Path != "/" || cookie.MaxAge <= 0 {
+		t.Errorf("cookie = %+v", cookie)
+	}
+	// Without a zone the coordinates' zone is saved.
+	post(url.Values{"action": {"save"}, "name": {"Cabin"}, "lat": {"61.2"}, "lon": {"-149.9"}})
+	if locs, _ := decodeSaved(cookie.Value); len(locs) != 2 || locs[1].Zone != "America/Anchorage" {
+		t.Errorf("saved = %+v", locs)
+	}
+	// The same name replaces a location in place.
+	post(url.Values{"action": {"save"}, "name": {"home"}, "lat": {"-37.9"}, "lon": {"145"}, "tz": {"Australia/Melbourne"}})
+	if got := names(); got != "home,Cabin" {
+		t.Errorf("after replacing: %s", got)
+	}
+
+	post(url.Values{"action": {"default"}, "index": {"1"}})
+	if got := names(); got != "Cabin,home" {
+		t.Errorf("after default: %s", got)
+	}
+	post(url.Values{"action": {"delete"}, "index": {"0"}})
+	if got := names(); got != "home" {
+		t.Errorf("after delete: %s", got)
+	}
+
+	for _, bad := range []url.Values{
+		{"action": {"save"}, "name": {""}, "lat": {"1"}, "lon": {"1"}},
+		{"action": {"save"}, "name": {strings.Repeat("x", maxSavedNameLen+1)}, "lat": {"1"}, "lon": {"1"}},
+		{"action": {"save"}, "name": {"Pole"}, "lat": {"91"}, "lon": {"1"}},
+		{"action": {"save"}, "name": {"Nowhere"}, "lat": {"north"}, "lon": {"1"}},
+		{"action": {"delete"}, "index": {"5"}},
+		{"action": {"rename"}},
+	} {
+		if rr := post(bad); rr.Code != http.StatusBadRequest {
+			t.Errorf("%v = %d", bad, rr.Code)
+		}
+	}
+	for i := len(strings.Split(names(), ",")); i < maxSavedLocations; i++ {
+		post(url.Values{"action": {"save"}, "name": {"Site " + string(rune('A'+i))}, "lat": {"1"}, "lon": {"1"}})
+	}
+	if rr := post(url.Values{"action": {"save"}, "name": {"One too many"}, "lat": {"1"}, "lon": {"1"}}); rr.Code != http.StatusBadRequest {
+		t.Errorf("saving %d locations = %d", maxSavedLocations+1, rr.Code)
+	}
+
+	// Only local return paths are followed.
+	for _, ret := range []string{"//evil.example/", "/\\evil.example", "/\t/evil.example", "/\n/evil.example", " //evil.example", "/%09/evil.example", "/%2F/evil.example", "https://evil.example/"} {
+		rr = post(url.Values{"action": {"default"}, "index": {"0"}, "return": {ret}})
+		if rr.Header().Get("Location") != "/calendar" {
+			t.Errorf("return %q redirected to %q", ret, rr.Header().Get("Location"))
+		}
+	}
+	// As the raw form sends it, %09 decoding to a tab.
+	req := httptest.NewRequest("POST", "/saved", strings.NewReader("action=default&index=0&return=/%09/evil.example"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(cookie)
+	rr = httptest.NewRecorder()
+	handleSaved(rr, req)
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/calendar" {
+		t.Errorf("raw %%09 return = %d %q", rr.Code, rr.Header().Get("Location"))
+	}
+	if got := localPath("/calendar?year=2026&month=3", "/"); got != "/calendar?year=2026&month=3" {
+		t.Errorf("localPath of a local path = %q", got)
+	}
+
+	for range maxSavedLocations {
+		post(url.Values{"action": {"delete"}, "index": {"0"}})
+	}
+	if cookie.MaxAge >= 0 {
+		t.Errorf("removing every location should delete the cookie: %+v", cookie)
+	}
+
+	req = httptest.NewRequest("POST", "/saved", strings.NewReader("action=delete&index=0"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Sec-Fetch-Site", "cross-site")
+	rr = httptest.NewRecorder()
+	handleSaved(rr, req)
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("cross-site post = %d", rr.Code)
+	}
+	rr = httptest.NewRecorder()
+	handleSaved(rr, httptest.NewRequest("GET", "/saved", nil))
+	if rr.Code != http.StatusMethodNotAllowed || rr.Header().Get("Allow") != "POST" {
+		t.Errorf("GET = %d %v", rr.Code, rr.Header())
+	}
+}
+
+// Test the first saved location is the default and the others can be chosen
+func TestSavedDefault(t *testing.T) {
+	get := func(handler http.HandlerFunc, url string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", url, nil)
+		req.AddCookie(savedCookie(t, testSaved))
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+		return rr
+	}
+
+	rr := get(calendar, "/calendar?year=2026&month=3")
+	body := rr.Body.String()
+	if rr.Header().Get("Cache-Control") != cachePerLocation || rr.Header().Get("Vary") != "Cookie" {
+		t.Errorf("calendar headers = %v", rr.Header())
+	}
+	for _, want := range []string{
+		`Home &mdash; Latitude: -37.8136 Longitude: 144.9631`,
+		`<option value="0" selected>Home</option><option value="1">Dark site</option>`,
+		`<input type="hidden" name="month" value="3"><input type="hidden" name="year" value="2026">`,
+		`<input type="hidden" name="return" value="/calendar?year=2026&amp;month=3">`,
+		`Home (default) <button type="submit" name="action" value="delete">Remove</button>`,
+		`Cabin <button type="submit" name="action" value="default">Make default</button>`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("calendar missing %q", want)
+		}
+	}
+
+	// By index, and by coordinates, which keep the saved name.
+	for _, u := range []string{"/calendar?saved=2", "/calendar?lat=61.2&lon=-149.9&tz=America/Anchorage"} {
+		body := get(calendar, u).Body.String()
+		if !strings.Contains(body, "Cabin &mdash; Latitude: 61.2 Longitude: -149.9 Timezone America/Anchorage") ||
+			!strings.Contains(body, `<option value="2" selected>Cabin</option>`) {
+			t.Errorf("%s doesn't show Cabin", u)
+		}
+	}
+	if body := get(calendar, "/calendar?lat=61.2&lon=-149.9&tz=UTC").Body.String(); strings.Contains(body, "Cabin &mdash;") {
+		t.Error("another zone shouldn't take the saved name")
+	}
+
+	rr = get(handleIndex, "/")
+	body = rr.Body.String()
+	for _, want := range []string{
+		`data-default-name="Home" data-default-lat="-37.8136" data-default-lon="144.9631" data-default-tz="Australia/Melbourne" data-saved>`,
+		`<option value="2" data-lat="61.2" data-lon="-149.9" data-tz="America/Anchorage">Cabin</option>`,
+		`<input id="saveLat" type="hidden" name="lat" value="-37.8136">`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("index missing %q", want)
+		}
+	}
+
+	rr = get(handleCompare, "/compare?year=2026&month=3")
+	body = rr.Body.String()
+	if rr.Header().Get("Cache-Control") != cachePerLocation ||
+		!strings.Contains(body, `Cabin<div class="compare-zone">America/Anchorage</div>`) ||
+		!strings.Contains(body, `href="/compare?month=4&amp;year=2026" rel="next"`) {
+		t.Errorf("compare of saved locations = %v\n%s", rr.Header(), body)
+	}
+
+	// Without the cookie the page is the same for everyone.
+	rr = httptest.NewRecorder()
+	calendar(rr, httptest.NewRequest("GET", "/calendar?lat=61.2&lon=-149.9&tz=America/Anchorage&year=2020&month=1", nil))
+	if rr.Header().Get("Cache-Control") != cacheFor(maxDynamicAge) || strings.Contains(rr.Body.String(), "saved-list") {
+		t.Errorf("calendar without saved locations = %v", rr.Header())
+	}
+}
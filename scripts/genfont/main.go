@@ -0,0 +1,147 @@
+// Command genfont writes the bitmap fonts in data/fonts used to draw the
+// social cards. Each font is a PNG atlas of antialiased glyphs, rendered
+// from the Go fonts (BSD licensed, see golang.org/x/image/font/gofont), and
+// a text file of glyph metrics. The server reads them with the standard
+// image packages alone.
+//
+// It is its own module, so golang.org/x/image is not a dependency of the
+// server. Run it with go generate from the repository root.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"image"
+	"image/png"
+	"log"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// faces are the fonts written: the name is also the file name.
+var faces = []struct {
+	name  string
+	title string
+	ttf   []byte
+	size  float64
+}{
+	{"go-bold-60", "Go Bold", gobold.TTF, 60},
+	{"go-regular-36", "Go Regular", goregular.TTF, 36},
+	{"go-regular-26", "Go Regular", goregular.TTF, 26},
+}
+
+// runes returns the characters in each font: ASCII, Latin-1 and Latin
+// Extended-A, which cover the gazetteer's names, and common punctuation.
+func runes() []rune {
+	var rs []rune
+	for r := rune(0x20); r <= 0x7e; r++ {
+		rs = append(rs, r)
+	}
+	for r := rune(0xa0); r <= 0x17f; r++ {
+		rs = append(rs, r)
+	}
+	return append(rs, '–', '—', '‘', '’', '“', '”', '•', '…', '−')
+}
+
+// atlasWidth is the width of each atlas; glyphs are packed in rows.
+const atlasWidth = 1024
+
+func main() {
+	out := flag.String("out", "data/fonts", "output directory")
+	flag.Parse()
+	for _, f := range faces {
+		if err := write(*out, f.name, f.title, f.ttf, f.size); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+type glyph struct {
+	r       rune
+	x, y    int
+	bounds  image.Rectangle // relative to the pen on the baseline
+	advance fixed.Int26_6
+	alpha   *image.Alpha
+}
+
+func write(dir, name, title string, ttf []byte, size float64) error {
+	ft, err := opentype.Parse(ttf)
+	if err != nil {
+		return err
+	}
+	face, err := opentype.NewFace(ft, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull})
+	if err != nil {
+		return err
+	}
+	defer face.Close()
+
+	// Render each glyph into its own image, then pack them left to right
+	// in rows of the tallest glyph so far.
+	var glyphs []glyph
+	x, y, rowHeight := 0, 0, 0
+	for _, r := range runes() {
+		dr, mask, maskp, advance, ok := face.Glyph(fixed.Point26_6{}, r)
+		if !ok {
+			return fmt.Errorf("%s has no glyph for %q", title, r)
+		}
+		g := glyph{r: r, bounds: dr, advance: advance, alpha: image.NewAlpha(image.Rect(0, 0, dr.Dx(), dr.Dy()))}
+		for py := 0; py < dr.Dy(); py++ {
+			for px := 0; px < dr.Dx(); px++ {
+				_, _, _, a := mask.At(maskp.X+px, maskp.Y+py).RGBA()
+				g.alpha.Pix[py*g.alpha.Stride+px] = uint8(a >> 8)
+			}
+		}
+		if x+dr.Dx() > atlasWidth {
+			x, y, rowHeight = 0, y+rowHeight+1, 0
+		}
+		g.x, g.y = x, y
+		x += dr.Dx() + 1
+		rowHeight = max(rowHeight, dr.Dy())
+		glyphs = append(glyphs, g)
+	}
+
+	atlas := image.NewGray(image.Rect(0, 0, atlasWidth, y+rowHeight))
+	for _, g := range glyphs {
+		for py := 0; py < g.alpha.Rect.Dy(); py++ {
+			copy(atlas.Pix[(g.y+py)*atlas.Stride+g.x:], g.alpha.Pix[py*g.alpha.Stride:(py+1)*g.alpha.Stride])
+		}
+	}
+	pf, err := os.Create(filepath.Join(dir, name+".png"))
+	if err != nil {
+		return err
+	}
+	enc := png.Encoder{CompressionLevel: png.BestCompression}
+	if err := enc.Encode(pf, atlas); err != nil {
+		pf.Close()
+		return err
+	}
+	if err := pf.Close(); err != nil {
+		return err
+	}
+
+	tf, err := os.Create(filepath.Join(dir, name+".txt"))
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(tf)
+	m := face.Metrics()
+	fmt.Fprintf(w, "# Generated by scripts/genfont from %s at %gpx; DO NOT EDIT.\n", title, size)
+	fmt.Fprintf(w, "# ascent descent, in pixels\n%d %d\n", m.Ascent.Ceil(), m.Descent.Ceil())
+	fmt.Fprintln(w, "# rune x y width height left top advance: the glyph's box in the atlas,")
+	fmt.Fprintln(w, "# its offset from the pen on the baseline, and the advance in 1/64 pixel")
+	for _, g := range glyphs {
+		fmt.Fprintf(w, "%d %d %d %d %d %d %d %d\n", g.r, g.x, g.y, g.bounds.Dx(), g.bounds.Dy(), g.bounds.Min.X, g.bounds.Min.Y, g.advance)
+	}
+	if err := w.Flush(); err != nil {
+		tf.Close()
+		return err
+	}
+	return tf.Close()
+}
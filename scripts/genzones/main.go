@@ -0,0 +1,110 @@
+// Command genzones writes data/zones.txt, the list of time zones served by
+// /api/timezones. It reads the zoneinfo.zip shipped with the Go toolchain,
+// which is the data time/tzdata embeds in the binary, and keeps one name
+// for each distinct zone in the geographic areas and Etc. Names whose data
+// is identical are aliases: those the gazetteer uses are all kept (zone.tab
+// names a zone per country, so Europe/Bratislava stays beside
+// Europe/Prague), and otherwise only the shortest.
+//
+// Run it from the repository root with go generate after updating Go.
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"sort"
+	"strings"
+)
+
+var areas = []string{"Africa", "America", "Antarctica", "Arctic", "Asia", "Atlantic", "Australia", "Europe", "Etc", "Indian", "Pacific"}
+
+func main() {
+	zr, err := zip.OpenReader(filepath.Join(runtime.GOROOT(), "lib", "time", "zoneinfo.zip"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer zr.Close()
+
+	preferred, err := gazetteerZones("data/places.csv")
+	if err != nil {
+		log.Fatal(err)
+	}
+	preferred["Etc/UTC"] = true // rather than its alias Etc/UCT
+
+	groups := map[[32]byte][]string{}
+	for _, f := range zr.File {
+		area, _, _ := strings.Cut(f.Name, "/")
+		if !slices.Contains(areas, area) || !strings.Contains(f.Name, "/") {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			log.Fatal(err)
+		}
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			log.Fatal(err)
+		}
+		sum := sha256.Sum256(data)
+		groups[sum] = append(groups[sum], f.Name)
+	}
+
+	var names []string
+	for _, group := range groups {
+		kept := false
+		for _, name := range group {
+			if preferred[name] {
+				names = append(names, name)
+				kept = true
+			}
+		}
+		if !kept {
+			sort.Slice(group, func(i, j int) bool {
+				if len(group[i]) != len(group[j]) {
+					return len(group[i]) < len(group[j])
+				}
+				return group[i] < group[j]
+			})
+			names = append(names, group[0])
+		}
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "# Generated by scripts/genzones from the zoneinfo.zip in %s. DO NOT EDIT.\n", runtime.Version())
+	for _, name := range names {
+		fmt.Fprintln(&buf, name)
+	}
+	if err := os.WriteFile("data/zones.txt", buf.Bytes(), 0o644); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("wrote %d zones", len(names))
+}
+
+// gazetteerZones returns the set of zones named in the gazetteer CSV.
+func gazetteerZones(path string) (map[string]bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	recs, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	zones := map[string]bool{}
+	for _, rec := range recs[1:] {
+		zones[rec[len(rec)-1]] = true
+	}
+	return zones, nil
+}
@@ -0,0 +1,71 @@
+'use strict';
+
+// Google Maps implementation of mapProvider. The Maps API is loaded after
+// this script and calls initMap when ready.
+
+let googleMap;
+let googleMarker;
+
+mapProvider = {
+	show(lat, lon, onMove) {
+		try {
+			const latlon = new google.maps.LatLng(lat, lon);
+			const mapholder = document.getElementById('mapholder');
+
+			if (!mapholder) {
+				return;
+			}
+
+			// Map sizing - now fixed height from CSS
+			mapholder.style.width = '100%';
+
+			googleMap = new google.maps.Map(mapholder, {
+				center: latlon,
+				zoom: 8,
+				mapTypeId: google.maps.MapTypeId.ROADMAP,
+				mapTypeControl: true,
+				zoomControl: true,
+				streetViewControl: false,
+				fullscreenControl: true,
+				mapId: 'f992b8b594ad429e83d19e2f'
+			});
+
+			googleMarker = new google.maps.marker.AdvancedMarkerElement({
+				position: latlon,
+				map: googleMap,
+				title: "Move marker to your location.",
+				gmpDraggable: true
+			});
+
+			// Detect when the marker is moved or dragged by the user
+			google.maps.event.addListener(googleMarker, 'dragend', (event) => {
+				onMove(event.latLng.lat(), event.latLng.lng());
+			});
+		} catch (error) {
+			showErrorMessage('Failed to load map. Please refresh the page.');
+		}
+	},
+
+	move(lat, lon) {
+		if (!googleMarker || !googleMap) {
+			return;
+		}
+		const latlon = new google.maps.LatLng(lat, lon);
+
+		// AdvancedMarkerElement doesn't have setVisible, just update position and pan
+		googleMarker.position = latlon;
+		googleMap.panTo(latlon);
+	}
+};
+
+// Callback function for Google Maps API
+function initMap() {
+	setupMap();
+}
+
+// Handle window resize for responsive map
+window.addEventListener('resize', () => {
+	if (googleMap) {
+		google.maps.event.trigger(googleMap, 'resize');
+	}
+});
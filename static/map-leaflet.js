@@ -0,0 +1,61 @@
+'use strict';
+
+// Leaflet implementation of mapProvider. Leaflet is bundled under
+// /static/leaflet/; the tile URL, attribution and marker images come from
+// data attributes the server renders on #mapholder.
+
+let leafletMap;
+let leafletMarker;
+
+mapProvider = {
+	show(lat, lon, onMove) {
+		const mapholder = document.getElementById('mapholder');
+		if (!mapholder) {
+			return;
+		}
+		if (typeof L === 'undefined') {
+			showErrorMessage('Failed to load map. Please refresh the page.');
+			return;
+		}
+		const opts = mapholder.dataset;
+
+		leafletMap = L.map(mapholder).setView([lat, lon], 8);
+		L.tileLayer(opts.tiles, {
+			attribution: opts.attribution,
+			maxZoom: 19
+		}).addTo(leafletMap);
+
+		// Icon URLs are given explicitly: Leaflet's own detection looks for
+		// "marker-icon.png", but the bundled images are served at hashed URLs.
+		const icon = L.icon({
+			iconUrl: opts.markerIcon,
+			iconRetinaUrl: opts.markerRetina,
+			shadowUrl: opts.markerShadow,
+			iconSize: [25, 41],
+			iconAnchor: [12, 41],
+			popupAnchor: [1, -34],
+			shadowSize: [41, 41]
+		});
+		leafletMarker = L.marker([lat, lon], {
+			icon: icon,
+			draggable: true,
+			title: "Move marker to your location."
+		}).addTo(leafletMap);
+
+		// Detect when the marker is moved or dragged by the user
+		leafletMarker.on('dragend', () => {
+			const pos = leafletMarker.getLatLng();
+			onMove(pos.lat, pos.lng);
+		});
+	},
+
+	move(lat, lon) {
+		if (!leafletMarker || !leafletMap) {
+			return;
+		}
+		leafletMarker.setLatLng([lat, lon]);
+		leafletMap.panTo([lat, lon]);
+	}
+};
+
+document.addEventListener('DOMContentLoaded', setupMap);
@@ -1,133 +1,114 @@
 'use strict';
 
-let marker;
-let myMap;
-let mylat = -37;
-let mylon = 144;
-let myzon = 10;
-
-// Comprehensive timezone list with UTC offsets
-const timezones = [
-	{ name: "Pacific/Midway", label: "(UTC-11:00) Midway Island, American Samoa", offset: -11 },
-	{ name: "Pacific/Honolulu", label: "(UTC-10:00) Hawaii", offset: -10 },
-	{ name: "America/Anchorage", label: "(UTC-09:00) Alaska", offset: -9 },
-	{ name: "America/Los_Angeles", label: "(UTC-08:00) Pacific Time (US & Canada)", offset: -8 },
-	{ name: "America/Tijuana", label: "(UTC-08:00) Tijuana, Baja California", offset: -8 },
-	{ name: "America/Denver", label: "(UTC-07:00) Mountain Time (US & Canada)", offset: -7 },
-	{ name: "America/Phoenix", label: "(UTC-07:00) Arizona", offset: -7 },
-	{ name: "America/Chihuahua", label: "(UTC-07:00) Chihuahua, La Paz, Mazatlan", offset: -7 },
-	{ name: "America/Chicago", label: "(UTC-06:00) Central Time (US & Canada)", offset: -6 },
-	{ name: "America/Mexico_City", label: "(UTC-06:00) Mexico City", offset: -6 },
-	{ name: "America/Regina", label: "(UTC-06:00) Saskatchewan", offset: -6 },
-	{ name: "America/New_York", label: "(UTC-05:00) Eastern Time (US & Canada)", offset: -5 },
-	{ name: "America/Bogota", label: "(UTC-05:00) Bogota, Lima, Quito", offset: -5 },
-	{ name: "America/Caracas", label: "(UTC-04:00) Caracas", offset: -4 },
-	{ name: "America/Halifax", label: "(UTC-04:00) Atlantic Time (Canada)", offset: -4 },
-	{ name: "America/Santiago", label: "(UTC-04:00) Santiago", offset: -4 },
-	{ name: "America/St_Johns", label: "(UTC-03:30) Newfoundland", offset: -3.5 },
-	{ name: "America/Sao_Paulo", label: "(UTC-03:00) Brasilia", offset: -3 },
-	{ name: "America/Argentina/Buenos_Aires", label: "(UTC-03:00) Buenos Aires", offset: -3 },
-	{ name: "America/Godthab", label: "(UTC-03:00) Greenland", offset: -3 },
-	{ name: "Atlantic/South_Georgia", label: "(UTC-02:00) Mid-Atlantic", offset: -2 },
-	{ name: "Atlantic/Azores", label: "(UTC-01:00) Azores", offset: -1 },
-	{ name: "Atlantic/Cape_Verde", label: "(UTC-01:00) Cape Verde Islands", offset: -1 },
-	{ name: "Europe/London", label: "(UTC+00:00) London, Dublin, Lisbon", offset: 0 },
-	{ name: "Europe/Paris", label: "(UTC+01:00) Paris, Brussels, Madrid", offset: 1 },
-	{ name: "Europe/Berlin", label: "(UTC+01:00) Berlin, Rome, Amsterdam", offset: 1 },
-	{ name: "Africa/Lagos", label: "(UTC+01:00) West Central Africa", offset: 1 },
-	{ name: "Europe/Athens", label: "(UTC+02:00) Athens, Istanbul, Helsinki", offset: 2 },
-	{ name: "Africa/Cairo", label: "(UTC+02:00) Cairo", offset: 2 },
-	{ name: "Africa/Johannesburg", label: "(UTC+02:00) Johannesburg, Pretoria", offset: 2 },
-	{ name: "Europe/Moscow", label: "(UTC+03:00) Moscow, St. Petersburg", offset: 3 },
-	{ name: "Asia/Baghdad", label: "(UTC+03:00) Baghdad", offset: 3 },
-	{ name: "Asia/Kuwait", label: "(UTC+03:00) Kuwait, Riyadh", offset: 3 },
-	{ name: "Asia/Tehran", label: "(UTC+03:30) Tehran", offset: 3.5 },
-	{ name: "Asia/Dubai", label: "(UTC+04:00) Abu Dhabi, Muscat", offset: 4 },
-	{ name: "Asia/Baku", label: "(UTC+04:00) Baku, Tbilisi, Yerevan", offset: 4 },
-	{ name: "Asia/Kabul", label: "(UTC+04:30) Kabul", offset: 4.5 },
-	{ name: "Asia/Karachi", label: "(UTC+05:00) Islamabad, Karachi", offset: 5 },
-	{ name: "Asia/Kolkata", label: "(UTC+05:30) Mumbai, Kolkata, New Delhi", offset: 5.5 },
-	{ name: "Asia/Kathmandu", label: "(UTC+05:45) Kathmandu", offset: 5.75 },
-	{ name: "Asia/Dhaka", label: "(UTC+06:00) Dhaka, Astana", offset: 6 },
-	{ name: "Asia/Yangon", label: "(UTC+06:30) Yangon (Rangoon)", offset: 6.5 },
-	{ name: "Asia/Bangkok", label: "(UTC+07:00) Bangkok, Hanoi, Jakarta", offset: 7 },
-	{ name: "Asia/Hong_Kong", label: "(UTC+08:00) Hong Kong, Beijing, Singapore", offset: 8 },
-	{ name: "Asia/Shanghai", label: "(UTC+08:00) Shanghai, Taipei", offset: 8 },
-	{ name: "Australia/Perth", label: "(UTC+08:00) Perth", offset: 8 },
-	{ name: "Asia/Tokyo", label: "(UTC+09:00) Tokyo, Seoul, Osaka", offset: 9 },
-	{ name: "Australia/Adelaide", label: "(UTC+09:30) Adelaide", offset: 9.5 },
-	{ name: "Australia/Darwin", label: "(UTC+09:30) Darwin", offset: 9.5 },
-	{ name: "Australia/Sydney", label: "(UTC+10:00) Sydney, Melbourne, Brisbane", offset: 10 },
-	{ name: "Australia/Hobart", label: "(UTC+10:00) Hobart", offset: 10 },
-	{ name: "Pacific/Guam", label: "(UTC+10:00) Guam, Port Moresby", offset: 10 },
-	{ name: "Pacific/Noumea", label: "(UTC+11:00) Solomon Islands, New Caledonia", offset: 11 },
-	{ name: "Pacific/Auckland", label: "(UTC+12:00) Auckland, Wellington", offset: 12 },
-	{ name: "Pacific/Fiji", label: "(UTC+12:00) Fiji, Kamchatka", offset: 12 },
-	{ name: "Pacific/Tongatapu", label: "(UTC+13:00) Nuku'alofa", offset: 13 }
-];
-
-// Populate timezone dropdown and auto-detect user's timezone
-function initializeTimezones() {
-	const select = document.getElementById('timezone');
-	select.innerHTML = '';
-	
-	// Add all timezones to dropdown
-	timezones.forEach(tz => {
-		const option = document.createElement('option');
-		option.value = tz.name;
-		option.textContent = tz.label;
-		option.dataset.offset = tz.offset;
-		select.appendChild(option);
-	});
-	
-	// Try to detect and select user's timezone
+// Set by map-google.js or map-leaflet.js, whichever the server rendered:
+// { show(lat, lon, onMove), move(lat, lon) }.
+let mapProvider = null;
+
+// The server injects the default location (saved, from config or GeoIP)
+// as data attributes on <body>; it is used until the browser reports a
+// position. A saved default is kept without asking the browser.
+const defaults = document.body.dataset;
+let mylat = parseFloat(defaults.defaultLat);
+let mylon = parseFloat(defaults.defaultLon);
+let mytz = defaults.defaultTz || '';
+
+// The zone options come from /api/timezones, with each zone's offset and
+// DST status for the browser's date. Until they arrive the selector holds
+// only the default location's zone.
+const zonesLoaded = loadTimezones();
+
+async function loadTimezones() {
+	const d = new Date();
+	const date = `${d.getFullYear()}-${String(d.getMonth() + 1).padStart(2, '0')}-${String(d.getDate()).padStart(2, '0')}`;
 	try {
-		const userTimezone = Intl.DateTimeFormat().resolvedOptions().timeZone;
-		const matchingOption = Array.from(select.options).find(opt => opt.value === userTimezone);
-		
-		if (matchingOption) {
-			select.value = userTimezone;
-		} else {
-			// Fallback: find timezone by offset if exact match not found
-			const d = new Date();
-			const offsetHours = -d.getTimezoneOffset() / 60;
-			const closestTz = timezones.find(tz => Math.abs(tz.offset - offsetHours) < 0.1);
-			if (closestTz) {
-				select.value = closestTz.name;
-			}
+		const resp = await fetch(`api/timezones?date=${date}`);
+		if (!resp.ok) {
+			throw new Error(`HTTP ${resp.status}`);
 		}
+		const json = await resp.json();
+		const options = json.Zones.map(z => {
+			const option = document.createElement('option');
+			option.value = z.Name;
+			option.textContent = z.DST ? `${z.Label} (${z.Abbr}, daylight saving)` : z.Label;
+			option.dataset.offset = z.Offset;
+			return option;
+		});
+		document.getElementById('timezone').replaceChildren(...options);
+		if (mytz) {
+			selectZone(mytz);
+		}
+	} catch (err) {
+		// Keep the default zone; times can still be looked up by coordinates
+	}
+}
+
+// Select the browser's zone if it is listed, otherwise the default
+// location's, otherwise the first zone with the browser's current offset.
+async function initializeTimezones() {
+	await zonesLoaded;
+	const select = document.getElementById('timezone');
+	const options = Array.from(select.options);
+	const hasOption = name => options.some(opt => opt.value === name);
+
+	let userTimezone = '';
+	try {
+		userTimezone = Intl.DateTimeFormat().resolvedOptions().timeZone;
 	} catch (e) {
-		// If detection fails, use first option
-		if (select.options.length > 0) {
-			select.selectedIndex = 0;
+		// Leave it to the fallbacks below
+	}
+
+	if (hasOption(userTimezone)) {
+		select.value = userTimezone;
+	} else if (defaults.defaultTz && hasOption(defaults.defaultTz)) {
+		select.value = defaults.defaultTz;
+	} else {
+		const offsetHours = -new Date().getTimezoneOffset() / 60;
+		const match = options.find(opt => Math.abs(parseFloat(opt.dataset.offset) - offsetHours) < 0.01);
+		if (match) {
+			select.value = match.value;
 		}
 	}
-	
-	// Update the hidden offset field
+
 	timezoneChanged();
 }
 
+// Select zone in the dropdown, adding it if it isn't listed (the server
+// may pick an Etc/GMT zone for a point at sea).
+function selectZone(zone) {
+	const select = document.getElementById('timezone');
+	if (!Array.from(select.options).some(opt => opt.value === zone)) {
+		const option = document.createElement('option');
+		option.value = zone;
+		option.textContent = zone;
+		select.appendChild(option);
+	}
+	select.value = zone;
+	mytz = zone;
+	updateCalLink();
+}
+
 // Called when timezone dropdown changes
 function timezoneChanged() {
 	const select = document.getElementById('timezone');
-	const selectedOption = select.options[select.selectedIndex];
-	if (selectedOption && selectedOption.dataset.offset) {
-		const offset = parseFloat(selectedOption.dataset.offset);
-		myzon = offset;
-		document.getElementById('zon').value = offset;
+	if (select.value) {
+		mytz = select.value;
 		getTimes();
+		updateCalLink();
 	}
 }
 
 
-// Callback function for Google Maps API
-function initMap() {
-	setupMap();
-}
-
+// Called by the map provider script once its library is ready
 function setupMap() {
-	getLocationFromBrowser();
-	showGoogleMaps();
+	if ('saved' in defaults) {
+		getTimes();
+		updateCalLink();
+	} else {
+		getLocationFromBrowser();
+	}
+	if (mapProvider) {
+		mapProvider.show(mylat, mylon, markerMoved);
+	}
 }
 
 function getLocationFromBrowser() {
@@ -168,8 +149,7 @@ function showgeolocation(position) {
 	updateInputField("lon", mylon);
 	moveMarker();
 	updateCalLink();
-	displayTimeZone();
-	getTimes();
+	getTimes(true);
 }
 
 function updateInputField(fieldId, value) {
@@ -180,15 +160,9 @@ function updateInputField(fieldId, value) {
 }
 
 function moveMarker() {
-	if (!marker || !myMap) {
-		return;
+	if (mapProvider) {
+		mapProvider.move(mylat, mylon);
 	}
-	
-	const myLatLon = new google.maps.LatLng(mylat, mylon);
-	
-	// AdvancedMarkerElement doesn't have setVisible, just update position and pan
-	marker.position = myLatLon;
-	myMap.panTo(myLatLon);
 }
 
 function refresh() {
@@ -199,55 +173,15 @@ function displayTimeZone() {
 	initializeTimezones();
 }
 
-function showGoogleMaps() {
-	try {
-		const latlon = new google.maps.LatLng(mylat, mylon);
-		const mapholder = document.getElementById('mapholder');
-		
-		if (!mapholder) {
-			return;
-		}
-		
-		// Map sizing - now fixed height from CSS
-		mapholder.style.width = '100%';
-		
-		const myOptions = {
-			center: latlon,
-			zoom: 8,
-			mapTypeId: google.maps.MapTypeId.ROADMAP,
-			mapTypeControl: true,
-			zoomControl: true,
-			streetViewControl: false,
-			fullscreenControl: true,
-			mapId: 'f992b8b594ad429e83d19e2f'
-		};
-		
-		myMap = new google.maps.Map(mapholder, myOptions);
-		
-		marker = new google.maps.marker.AdvancedMarkerElement({
-			position: latlon,
-			map: myMap,
-			title: "Move marker to your location.",
-			gmpDraggable: true
-		});
-		
-		addListener(marker);
-	} catch (error) {
-		showErrorMessage('Failed to load map. Please refresh the page.');
-	}
-}
+// Called by the map provider when the user drags the marker
+function markerMoved(lat, lon) {
+	mylat = parseFloat(lat).toFixed(4);
+	mylon = parseFloat(lon).toFixed(4);
 
-// Detect when the marker is moved or dragged by the user
-function addListener(marker) {
-	google.maps.event.addListener(marker, 'dragend', (event) => {
-		mylat = parseFloat(event.latLng.lat()).toFixed(4);
-		mylon = parseFloat(event.latLng.lng()).toFixed(4);
-		
-		updateInputField("lat", mylat);
-		updateInputField("lon", mylon);
-		getTimes();
-		updateCalLink();
-	});
+	updateInputField("lat", mylat);
+	updateInputField("lon", mylon);
+	getTimes(true);
+	updateCalLink();
 }
 
 // Detect when user changed lat or long input, and move the marker in the map
@@ -265,7 +199,7 @@ function SpinnersChanged() {
 	mylon = newLon;
 
 	moveMarker();
-	getTimes();
+	getTimes(true);
 	updateCalLink();
 	clearErrorMessage();
 }
@@ -276,7 +210,7 @@ function SpinnersChanged() {
 function updateCalLink() {
 	const calendarLink = document.getElementById("callink");
 	if (calendarLink) {
-		calendarLink.href = `calendar?lat=${mylat}&lon=${mylon}&zon=${myzon}`;
+		calendarLink.href = `calendar?lat=${mylat}&lon=${mylon}&tz=${encodeURIComponent(mytz)}`;
 	}
 }
 
@@ -288,17 +222,26 @@ function updateSpinners() {
 	updateCalLink();
 }
 
-// Get the rise and set times from the server
-const getTimes = async function () {
-	const zon = document.getElementById('zon').value;
-	myzon = zon;
-
+// Get the rise and set times from the server. With lookupZone the server
+// picks the zone for the coordinates, and the selector follows it;
+// otherwise the selected zone is used.
+const getTimes = async function (lookupZone = false) {
+	if (!lookupZone && !mytz) {
+		return;
+	}
+	let url = `gettimes?lon=${mylon}&lat=${mylat}`;
+	if (!lookupZone) {
+		url += `&tz=${encodeURIComponent(mytz)}`;
+	}
 	try {
-		const resp = await fetch(`gettimes?lon=${mylon}&lat=${mylat}&zon=${zon}`);
+		const resp = await fetch(url);
 		if (!resp.ok) {
 			throw new Error(`HTTP ${resp.status}`);
 		}
 		const json = await resp.json();
+		if (lookupZone && json.Zone) {
+			selectZone(json.Zone);
+		}
 
 		if (json.Error) {
 			showErrorMessage('Unable to calculate moon times for this location.');
@@ -315,11 +258,28 @@ const getTimes = async function () {
 			updateInputField("Set", json.Set);
 			clearErrorMessage();
 		}
+		if (json.Phase) {
+			showPhase(json.Date, json.Phase);
+		}
 	} catch (err) {
 		showErrorMessage('Failed to get moon rise/set times. Please try again.');
 	}
 };
 
+// Show the moon's phase for the given local date as seen from the
+// current latitude's hemisphere.
+function showPhase(date, label) {
+	const img = document.getElementById("phaseImg");
+	const name = document.getElementById("phaseName");
+	if (img) {
+		img.src = `img/phase.svg?date=${date}&lat=${mylat}`;
+		img.alt = label;
+	}
+	if (name) {
+		name.textContent = label;
+	}
+}
+
 function showError(error) {
 	let message = '';
 	
@@ -366,12 +326,57 @@ function clearErrorMessage() {
 	}
 }
 
-// Handle window resize for responsive map
-window.addEventListener('resize', () => {
-	if (myMap) {
-		google.maps.event.trigger(myMap, 'resize');
+// Show the location chosen from the saved locations dropdown
+function savedChosen() {
+	const option = document.getElementById('savedSelect').selectedOptions[0];
+	if (!option || !option.value) {
+		return;
+	}
+	mylat = parseFloat(option.dataset.lat);
+	mylon = parseFloat(option.dataset.lon);
+	updateInputField("lat", mylat);
+	updateInputField("lon", mylon);
+	moveMarker();
+	selectZone(option.dataset.tz);
+	getTimes();
+	clearErrorMessage();
+}
+
+// Save the location currently shown, not the one the page loaded with
+function fillSaveForm() {
+	updateInputField("saveLat", mylat);
+	updateInputField("saveLon", mylon);
+	updateInputField("saveTz", mytz);
+}
+
+// Offer gazetteer matches as the user types a place name in the "No map?"
+// form. Requests are debounced, and input with digits (coordinates or a
+// grid locator) is left alone.
+let placeTimer = null;
+function suggestPlaces() {
+	clearTimeout(placeTimer);
+	const q = document.getElementById('q').value.trim();
+	if (q.length < 2 || /\d/.test(q)) {
+		return;
 	}
-});
+	placeTimer = setTimeout(async () => {
+		try {
+			const resp = await fetch(`api/places?q=${encodeURIComponent(q)}&limit=8`);
+			if (!resp.ok) {
+				return;
+			}
+			const json = await resp.json();
+			const options = json.Places.map(p => {
+				const option = document.createElement('option');
+				option.value = `${p.Name}, ${p.Country}`;
+				return option;
+			});
+			document.getElementById('place-suggestions').replaceChildren(...options);
+		} catch (err) {
+			// Suggestions are optional; the form still works without them
+		}
+	}, 200);
+}
 
 // Attach event listeners without relying on inline on* handlers,
 // so the CSP can forbid 'unsafe-inline' for script-src.
@@ -380,9 +385,15 @@ document.addEventListener('DOMContentLoaded', () => {
 	const lonEl = document.getElementById('lon');
 	const tzEl = document.getElementById('timezone');
 	const locBtn = document.getElementById('useMyLocationBtn');
+	const placeEl = document.getElementById('q');
+	const savedEl = document.getElementById('savedSelect');
+	const saveForm = document.getElementById('saveForm');
 
 	if (latEl) latEl.addEventListener('change', SpinnersChanged);
 	if (lonEl) lonEl.addEventListener('change', SpinnersChanged);
 	if (tzEl) tzEl.addEventListener('change', timezoneChanged);
 	if (locBtn) locBtn.addEventListener('click', refresh);
+	if (placeEl) placeEl.addEventListener('input', suggestPlaces);
+	if (savedEl) savedEl.addEventListener('change', savedChosen);
+	if (saveForm) saveForm.addEventListener('submit', fillSaveForm);
 });
\ No newline at end of file
@@ -0,0 +1,496 @@
+package main
+
+// Minimal OpenTelemetry-compatible tracing. Spans are recorded around each
+// HTTP handler, template execution and riseset calculation, and exported
+// either to stdout (for local use) or to an OTLP/HTTP collector using the
+// JSON encoding. The settings are part of config, read from the standard
+// OTEL_* environment variables so the usual collector setups work
+// unchanged:
+//
+//	OTEL_TRACES_EXPORTER                 none (default), console, or otlp
+//	OTEL_EXPORTER_OTLP_ENDPOINT          base URL, default http://localhost:4318
+//	OTEL_EXPORTER_OTLP_TRACES_ENDPOINT   full URL, overrides the above
+//	OTEL_EXPORTER_OTLP_HEADERS           comma-separated key=value pairs
+//	OTEL_SERVICE_NAME                    default "moon"
+//
+// When tracing is disabled startSpan returns a nil *span and every span
+// method is a no-op, so call sites don't need to check.
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/exploded/riseset"
+)
+
+// tracing is the process-wide tracer, nil when tracing is disabled. It is
+// set once in main before the server starts.
+var tracing *tracer
+
+type traceID [16]byte
+type spanID [8]byte
+
+// span is a single timed operation. Spans nest through the context.
+type span struct {
+	tracer   *tracer
+	traceID  traceID
+	spanID   spanID
+	parentID spanID
+	name     string
+	kind     int
+	start    time.Time
+	end      time.Time
+	attrs    []spanAttr
+	errMsg   string
+}
+
+type spanAttr struct {
+	Key   string
+	Value any
+}
+
+// OTLP span kinds used here.
+const (
+	spanKindInternal = 1
+	spanKindServer   = 2
+)
+
+type spanKey struct{}
+
+// spanFrom returns the active span in ctx, or nil.
+func spanFrom(ctx context.Context) *span {
+	s, _ := ctx.Value(spanKey{}).(*span)
+	return s
+}
+
+// startSpan starts a child of the span in ctx (or a new trace if there is
+// none) and returns a context carrying it. Call finish on the result.
+func startSpan(ctx context.Context, name string, attrs ...spanAttr) (context.Context, *span) {
+	if tracing == nil {
+		return ctx, nil
+	}
+	s := &span{
+		tracer: tracing,
+		name:   name,
+		kind:   spanKindInternal,
+		start:  time.Now(),
+		attrs:  attrs,
+	}
+	if parent := spanFrom(ctx); parent != nil {
+		s.traceID = parent.traceID
+		s.parentID = parent.spanID
+	} else {
+		_, _ = rand.Read(s.traceID[:])
+	}
+	_, _ = rand.Read(s.spanID[:])
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+func (s *span) setAttr(key string, value any) {
+	if s == nil {
+		return
+	}
+	s.attrs = append(s.attrs, spanAttr{key, value})
+}
+
+func (s *span) recordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.errMsg = err.Error()
+}
+
+func (s *span) finish() {
+	if s == nil {
+		return
+	}
+	s.end = time.Now()
+	s.tracer.exporter.export(s)
+}
+
+// spanExporter receives finished spans.
+type spanExporter interface {
+	export(s *span)
+	shutdown(ctx context.Context)
+}
+
+type tracer struct {
+	serviceName string
+	exporter    spanExporter
+}
+
+// newTracer builds a tracer from c's OTEL settings. It returns nil when
+// tracing is disabled.
+func newTracer(c config) (*tracer, error) {
+	service := c.ServiceName
+	if service == "" {
+		service = "moon"
+	}
+	switch exp := c.TracesExporter; exp {
+	case "", "none":
+		return nil, nil
+	case "console":
+		return &tracer{serviceName: service, exporter: &consoleExporter{w: os.Stdout}}, nil
+	case "otlp":
+		endpoint := c.OTLPTracesEndpoint
+		if endpoint == "" {
+			base := c.OTLPEndpoint
+			if base == "" {
+				base = "http://localhost:4318"
+			}
+			endpoint = strings.TrimRight(base, "/") + "/v1/traces"
+		}
+		headers, err := parseOTLPHeaders(c.OTLPHeaders)
+		if err != nil {
+			return nil, err
+		}
+		return &tracer{serviceName: service, exporter: newOTLPExporter(endpoint, headers, service)}, nil
+	default:
+		return nil, fmt.Errorf("unsupported OTEL_TRACES_EXPORTER %q (want none, console or otlp)", exp)
+	}
+}
+
+// parseOTLPHeaders parses the "k1=v1,k2=v2" form used by
+// OTEL_EXPORTER_OTLP_HEADERS.
+func parseOTLPHeaders(s string) (map[string]string, error) {
+	headers := map[string]string{}
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(pair, "=")
+		if !ok || strings.TrimSpace(k) == "" {
+			return nil, fmt.Errorf("invalid OTEL_EXPORTER_OTLP_HEADERS entry %q", pair)
+		}
+		headers[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+	return headers, nil
+}
+
+func (t *tracer) shutdown(ctx context.Context) {
+	if t != nil {
+		t.exporter.shutdown(ctx)
+	}
+}
+
+// consoleExporter writes one JSON object per finished span.
+type consoleExporter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (e *consoleExporter) export(s *span) {
+	attrs := make(map[string]any, len(s.attrs))
+	for _, a := range s.attrs {
+		attrs[a.Key] = a.Value
+	}
+	rec := struct {
+		Name     string         `json:"name"`
+		TraceID  string         `json:"trace_id"`
+		SpanID   string         `json:"span_id"`
+		ParentID string         `json:"parent_id,omitempty"`
+		Start    time.Time      `json:"start"`
+		Duration string         `json:"duration"`
+		Attrs    map[string]any `json:"attrs,omitempty"`
+		Error    string         `json:"error,omitempty"`
+	}{
+		Name:     s.name,
+		TraceID:  hex.EncodeToString(s.traceID[:]),
+		SpanID:   hex.EncodeToString(s.spanID[:]),
+		Start:    s.start,
+		Duration: s.end.Sub(s.start).String(),
+		Attrs:    attrs,
+		Error:    s.errMsg,
+	}
+	if s.parentID != (spanID{}) {
+		rec.ParentID = hex.EncodeToString(s.parentID[:])
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_ = json.NewEncoder(e.w).Encode(rec)
+}
+
+func (e *consoleExporter) shutdown(context.Context) {}
+
+// otlpExporter batches spans and POSTs them to an OTLP/HTTP collector.
+// Spans are dropped (with a warning) rather than blocking requests if the
+// queue fills up because the collector is slow or down.
+type otlpExporter struct {
+	endpoint string
+	headers  map[string]string
+	service  string
+	client   *http.Client
+
+	queue chan *span
+	flush chan chan struct{}
+}
+
+const (
+	otlpQueueSize     = 4096
+	otlpBatchSize     = 512
+	otlpFlushInterval = 5 * time.Second
+)
+
+func newOTLPExporter(endpoint string, headers map[string]string, service string) *otlpExporter {
+	e := &otlpExporter{
+		endpoint: endpoint,
+		headers:  headers,
+		service:  service,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		queue:    make(chan *span, otlpQueueSize),
+		flush:    make(chan chan struct{}),
+	}
+	go e.run()
+	return e
+}
+
+func (e *otlpExporter) export(s *span) {
+	select {
+	case e.queue <- s:
+	default:
+		slog.Warn("trace export queue full, dropping span", "span", s.name)
+	}
+}
+
+func (e *otlpExporter) run() {
+	ticker := time.NewTicker(otlpFlushInterval)
+	defer ticker.Stop()
+	var batch []*span
+	send := func() {
+		if len(batch) > 0 {
+			e.send(batch)
+			batch = nil
+		}
+	}
+	for {
+		select {
+		case s := <-e.queue:
+			batch = append(batch, s)
+			if len(batch) >= otlpBatchSize {
+				send()
+			}
+		case <-ticker.C:
+			send()
+		case ack := <-e.flush:
+			for len(e.queue) > 0 {
+				batch = append(batch, <-e.queue)
+			}
+			send()
+			close(ack)
+			return
+		}
+	}
+}
+
+// shutdown flushes queued spans, waiting at most until ctx is done.
+func (e *otlpExporter) shutdown(ctx context.Context) {
+	ack := make(chan struct{})
+	select {
+	case e.flush <- ack:
+	case <-ctx.Done():
+		return
+	}
+	select {
+	case <-ack:
+	case <-ctx.Done():
+	}
+}
+
+func (e *otlpExporter) send(batch []*span) {
+	body, err := json.Marshal(otlpPayload(e.service, batch))
+	if err != nil {
+		slog.Warn("trace export encode failed", "error", err)
+		return
+	}
+	req, err := http.NewRequest(http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		slog.Warn("trace export request failed", "error", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		slog.Warn("trace export failed", "endpoint", e.endpoint, "error", err)
+		return
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		slog.Warn("trace export rejected", "endpoint", e.endpoint, "status", resp.StatusCode, "spans", len(batch))
+	}
+}
+
+// otlpPayload builds an ExportTraceServiceRequest in the OTLP/JSON
+// encoding: IDs are hex strings and 64-bit integers are decimal strings.
+func otlpPayload(service string, spans []*span) map[string]any {
+	out := make([]map[string]any, 0, len(spans))
+	for _, s := range spans {
+		js := map[string]any{
+			"traceId":           hex.EncodeToString(s.traceID[:]),
+			"spanId":            hex.EncodeToString(s.spanID[:]),
+			"name":              s.name,
+			"kind":              s.kind,
+			"startTimeUnixNano": strconv.FormatInt(s.start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(s.end.UnixNano(), 10),
+			"attributes":        otlpAttrs(s.attrs),
+		}
+		if s.parentID != (spanID{}) {
+			js["parentSpanId"] = hex.EncodeToString(s.parentID[:])
+		}
+		if s.errMsg != "" {
+			js["status"] = map[string]any{"code": 2, "message": s.errMsg}
+		}
+		out = append(out, js)
+	}
+	return map[string]any{
+		"resourceSpans": []any{map[string]any{
+			"resource": map[string]any{
+				"attributes": otlpAttrs([]spanAttr{{"service.name", service}}),
+			},
+			"scopeSpans": []any{map[string]any{
+				"scope": map[string]any{"name": "moon"},
+				"spans": out,
+			}},
+		}},
+	}
+}
+
+func otlpAttrs(attrs []spanAttr) []map[string]any {
+	out := make([]map[string]any, 0, len(attrs))
+	for _, a := range attrs {
+		var v map[string]any
+		switch x := a.Value.(type) {
+		case string:
+			v = map[string]any{"stringValue": x}
+		case bool:
+			v = map[string]any{"boolValue": x}
+		case int:
+			v = map[string]any{"intValue": strconv.Itoa(x)}
+		case float64:
+			v = map[string]any{"doubleValue": x}
+		default:
+			v = map[string]any{"stringValue": fmt.Sprint(x)}
+		}
+		out = append(out, map[string]any{"key": a.Key, "value": v})
+	}
+	return out
+}
+
+// parseTraceparent extracts the trace and parent span IDs from a W3C
+// traceparent header ("00-<32 hex>-<16 hex>-<2 hex>").
+func parseTraceparent(h string) (traceID, spanID, bool) {
+	var tid traceID
+	var sid spanID
+	parts := strings.Split(h, "-")
+	if len(parts) != 4 || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return tid, sid, false
+	}
+	if _, err := hex.Decode(tid[:], []byte(parts[1])); err != nil || tid == (traceID{}) {
+		return tid, sid, false
+	}
+	if _, err := hex.Decode(sid[:], []byte(parts[2])); err != nil || sid == (spanID{}) {
+		return tid, sid, false
+	}
+	return tid, sid, true
+}
+
+// traceRequests wraps each request in a server span, continuing the trace
+// from an incoming traceparent header when present. The span is named
+// after the matched ServeMux pattern once routing has happened.
+func traceRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if tracing == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx := r.Context()
+		if tid, sid, ok := parseTraceparent(r.Header.Get("traceparent")); ok {
+			ctx = context.WithValue(ctx, spanKey{}, &span{traceID: tid, spanID: sid})
+		}
+		ctx, s := startSpan(ctx, "HTTP "+r.Method,
+			spanAttr{"http.request.method", r.Method},
+			spanAttr{"url.path", r.URL.Path},
+		)
+		s.kind = spanKindServer
+		if info := requestInfoFrom(ctx); info != nil {
+			s.setAttr("request.id", info.ID)
+		}
+		r = r.WithContext(ctx)
+
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		if r.Pattern != "" {
+			s.name = "HTTP " + r.Method + " " + r.Pattern
+			s.setAttr("http.route", r.Pattern)
+		}
+		s.setAttr("http.response.status_code", rec.status)
+		if rec.status >= 500 {
+			s.errMsg = http.StatusText(rec.status)
+		}
+		s.finish()
+	})
+}
+
+// executeTemplate renders the named template inside a span. In dev mode,
+// while the templates fail to parse, a response gets the error report
+// instead and nil is returned, as the report is the whole response.
+func executeTemplate(ctx context.Context, w io.Writer, name string, data any) error {
+	_, s := startSpan(ctx, "template "+name)
+	t, err := currentTemplates()
+	if err == nil {
+		err = t.ExecuteTemplate(w, name, data)
+	} else if rw, ok := w.(http.ResponseWriter); ok && cfg.Dev {
+		s.recordError(err)
+		s.finish()
+		writeTemplateError(rw, err)
+		return nil
+	}
+	s.recordError(err)
+	s.finish()
+	return err
+}
+
+// computeRiseset is riseset.Riseset behind risetDays, wrapped in a span
+// recording whether the cache answered.
+func computeRiseset(ctx context.Context, object riseset.Object, date time.Time, lon, lat, zon float64) riseset.RiseSet {
+	_, s := startSpan(ctx, "riseset.Riseset",
+		spanAttr{"riseset.object", objectName(object)},
+		spanAttr{"riseset.date", date.Format("2006-01-02")},
+	)
+	key := risetDays.key(object, date, lon, lat, zon)
+	rs, outcome := risetDays.get(key, key.compute)
+	s.setAttr("riseset.cache", outcome)
+	s.finish()
+	return rs
+}
+
+func objectName(o riseset.Object) string {
+	switch o {
+	case riseset.Moon:
+		return "moon"
+	case riseset.Sun:
+		return "sun"
+	case riseset.Twilight:
+		return "twilight"
+	}
+	return strconv.Itoa(int(o))
+}
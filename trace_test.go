@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useTracer installs t as the process tracer for the duration of the test.
+func useTracer(t *testing.T, tr *tracer) {
+	t.Helper()
+	prev := tracing
+	tracing = tr
+	t.Cleanup(func() { tracing = prev })
+}
+
+// Test that spans are no-ops when tracing is disabled
+func TestStartSpanDisabled(t *testing.T) {
+	useTracer(t, nil)
+	ctx, s := startSpan(context.Background(), "noop")
+	if s != nil || spanFrom(ctx) != nil {
+		t.Fatalf("expected no span when tracing is disabled")
+	}
+	s.setAttr("k", "v")
+	s.finish()
+}
+
+// Test that the calendar handler produces nested template and riseset spans
+func TestCalendarSpans(t *testing.T) {
+	var buf bytes.Buffer
+	useTracer(t, &tracer{serviceName: "moon", exporter: &consoleExporter{w: &buf}})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/calendar", calendar)
+	req := httptest.NewRequest("GET", "/calendar?lat=-37&lon=144&zon=10&year=2026&month=2", nil)
+	traceRequests(mux).ServeHTTP(httptest.NewRecorder(), req)
+
+	type rec struct {
+		Name     string `json:"name"`
+		TraceID  string `json:"trace_id"`
+		ParentID string `json:"parent_id"`
+	}
+	var spans []rec
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var r rec
+		if err := dec.Decode(&r); err != nil {
+			t.Fatal(err)
+		}
+		spans = append(spans, r)
+	}
+
+	counts := map[string]int{}
+	for _, s := range spans {
+		counts[s.Name]++
+		if s.TraceID != spans[0].TraceID {
+			t.Errorf("span %q has trace %s, want %s", s.Name, s.TraceID, spans[0].TraceID)
+		}
+	}
+	// February 2026: 28 days x (moon + sun).
+	if counts["riseset.Riseset"] != 56 {
+		t.Errorf("got %d riseset spans, want 56", counts["riseset.Riseset"])
+	}
+	if counts["template calendar.html"] != 1 {
+		t.Errorf("missing template span: %v", counts)
+	}
+	root := spans[len(spans)-1]
+	if root.Name != "HTTP GET /calendar" || root.ParentID != "" {
+		t.Errorf("last span = %+v, want root server span", root)
+	}
+}
+
+// Test the server span is named after the route through the full
+// middleware chain, and the CSP nonce still reaches the templates
+func TestServerSpanRoute(t *testing.T) {
+	var buf bytes.Buffer
+	useTracer(t, &tracer{serviceName: "moon", exporter: &consoleExporter{w: &buf}})
+	handler := makeHTTPServer().Handler
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/c/melbourne-au/2026-02", nil))
+	type rec struct {
+		Name  string         `json:"name"`
+		Attrs map[string]any `json:"attrs"`
+	}
+	var root rec // the server span finishes last
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		root = rec{}
+		if err := dec.Decode(&root); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if root.Name != "HTTP GET /c/{slug}/{month}" || root.Attrs["http.route"] != "/c/{slug}/{month}" {
+		t.Errorf("server span = %+v, want the route", root)
+	}
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+	nonce := regexp.MustCompile(`'nonce-([^']+)'`).FindStringSubmatch(rr.Header().Get("Content-Security-Policy"))
+	if nonce == nil || !strings.Contains(rr.Body.String(), `<script nonce="`+nonce[1]+`"`) {
+		t.Errorf("index scripts don't carry the CSP nonce %v", nonce)
+	}
+}
+
+// Test that an incoming traceparent header is continued
+func TestTraceparentPropagation(t *testing.T) {
+	var buf bytes.Buffer
+	useTracer(t, &tracer{serviceName: "moon", exporter: &consoleExporter{w: &buf}})
+
+	req := httptest.NewRequest("GET", "/about", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	traceRequests(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(httptest.NewRecorder(), req)
+
+	out := buf.String()
+	if !strings.Contains(out, `"trace_id":"4bf92f3577b34da6a3ce929d0e0e4736"`) ||
+		!strings.Contains(out, `"parent_id":"00f067aa0ba902b7"`) {
+		t.Errorf("span did not continue incoming trace: %s", out)
+	}
+
+	for _, bad := range []string{"", "00-abc-def-01", "00-00000000000000000000000000000000-00f067aa0ba902b7-01"} {
+		if _, _, ok := parseTraceparent(bad); ok {
+			t.Errorf("parseTraceparent(%q) should fail", bad)
+		}
+	}
+}
+
+// Test the OTLP exporter posts JSON batches to the collector on shutdown
+func TestOTLPExporter(t *testing.T) {
+	got := make(chan []byte, 1)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" || r.Header.Get("X-Api-Key") != "secret" {
+			t.Errorf("unexpected request %s with headers %v", r.URL.Path, r.Header)
+		}
+		b, _ := io.ReadAll(r.Body)
+		got <- b
+	}))
+	defer collector.Close()
+
+	c := defaultConfig()
+	c.TracesExporter, c.OTLPEndpoint, c.OTLPHeaders = "otlp", collector.URL, "X-Api-Key=secret"
+	tr, err := newTracer(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	useTracer(t, tr)
+
+	_, s := startSpan(context.Background(), "work", spanAttr{"n", 3})
+	s.finish()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	tr.shutdown(ctx)
+
+	select {
+	case body := <-got:
+		for _, want := range []string{`"service.name"`, `"name":"work"`, `"intValue":"3"`} {
+			if !bytes.Contains(body, []byte(want)) {
+				t.Errorf("payload missing %s: %s", want, body)
+			}
+		}
+	default:
+		t.Fatal("collector received nothing")
+	}
+}
+
+// Test exporter configuration errors, which config validation also reports
+func TestNewTracerErrors(t *testing.T) {
+	cases := []map[string]string{
+		{"OTEL_TRACES_EXPORTER": "zipkin"},
+		{"OTEL_TRACES_EXPORTER": "otlp", "OTEL_EXPORTER_OTLP_HEADERS": "novalue"},
+	}
+	for _, env := range cases {
+		c := defaultConfig()
+		c.TracesExporter, c.OTLPHeaders = env["OTEL_TRACES_EXPORTER"], env["OTEL_EXPORTER_OTLP_HEADERS"]
+		if _, err := newTracer(c); err == nil {
+			t.Errorf("newTracer(%v) should fail", env)
+		}
+		if _, _, err := loadConfig(nil, envMap(env)); err == nil || !strings.Contains(err.Error(), "otel-") {
+			t.Errorf("loadConfig(%v) = %v, want an otel error", env, err)
+		}
+	}
+}
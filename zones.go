@@ -0,0 +1,135 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+//go:generate go run ./scripts/genzones
+
+// zonesTxt lists the zones offered by /api/timezones, one per line: every
+// distinct zone in the tzdata that time/tzdata embeds in the binary, plus
+// the per-country aliases the gazetteer uses. Regenerate it with go
+// generate after updating Go.
+//
+//go:embed data/zones.txt
+var zonesTxt string
+
+// zoneNames is parsed from zonesTxt at startup.
+var zoneNames = parseZoneNames(zonesTxt)
+
+// parseZoneNames reads one zone name per line, skipping blank lines and
+// # comments.
+func parseZoneNames(data string) []string {
+	var names []string
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			names = append(names, line)
+		}
+	}
+	return names
+}
+
+// zoneInfo describes a zone on a particular date.
+type zoneInfo struct {
+	Name   string  // IANA zone, e.g. "Australia/Melbourne"
+	Label  string  // e.g. "(UTC+11:00) Melbourne, Geelong"
+	Offset float64 // hours east of UTC
+	Abbr   string  // e.g. "AEDT", or "+0545" where the zone has none
+	DST    bool
+}
+
+// zoneCities maps each zone in the gazetteer to its largest cities, which
+// name it in zone labels.
+var zoneCities = sync.OnceValue(func() map[string][]string {
+	const perZone = 3
+	m := map[string][]string{}
+	for _, p := range places {
+		if len(m[p.Zone]) < perZone {
+			m[p.Zone] = append(m[p.Zone], p.Name)
+		}
+	}
+	return m
+})
+
+// zoneLabel names a zone by its largest cities, or failing that by the
+// city in its name ("America/Argentina/La_Rioja" is "La Rioja"). Etc zones
+// keep their full names.
+func zoneLabel(name string) string {
+	if cities := zoneCities()[name]; len(cities) > 0 {
+		return strings.Join(cities, ", ")
+	}
+	if strings.HasPrefix(name, "Etc/") {
+		return name
+	}
+	return strings.ReplaceAll(path.Base(name), "_", " ")
+}
+
+// zoneList describes every zone at t, ordered by offset and then by label.
+func zoneList(t time.Time) []zoneInfo {
+	zones := make([]zoneInfo, 0, len(zoneNames))
+	for _, name := range zoneNames {
+		tz, err := loadZone(name)
+		if err != nil {
+			continue
+		}
+		lt := t.In(tz)
+		abbr, off := lt.Zone()
+		zones = append(zones, zoneInfo{
+			Name:   name,
+			Label:  "(" + formatOffset(off) + ") " + zoneLabel(name),
+			Offset: float64(off) / 3600,
+			Abbr:   abbr,
+			DST:    lt.IsDST(),
+		})
+	}
+	sort.Slice(zones, func(i, j int) bool {
+		if zones[i].Offset != zones[j].Offset {
+			return zones[i].Offset < zones[j].Offset
+		}
+		return zones[i].Label < zones[j].Label
+	})
+	return zones
+}
+
+// timezonesResponse is the JSON shape returned by /api/timezones.
+type timezonesResponse struct {
+	Date  string `json:",omitempty"`
+	Zones []zoneInfo
+	Error string `json:",omitempty"`
+}
+
+// handleTimezones serves /api/timezones?date=YYYY-MM-DD: every zone with
+// its offset and DST status at noon UTC on date (by default, today in
+// UTC). A given date's answer only changes with the build; today's expires
+// at midnight UTC.
+func handleTimezones(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	now := time.Now().UTC()
+	date := now
+	cacheControl := cacheFor(untilMidnight(now))
+	if s := r.URL.Query().Get("date"); s != "" {
+		d, err := time.Parse(time.DateOnly, s)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(timezonesResponse{Error: "invalid date, want YYYY-MM-DD"})
+			return
+		}
+		date, cacheControl = d, cacheFor(maxDynamicAge)
+	}
+	day := date.Format(time.DateOnly)
+
+	etag := computedETag("timezones", day)
+	if notModified(w, r, etag, cacheControl) {
+		return
+	}
+	noon := time.Date(date.Year(), date.Month(), date.Day(), 12, 0, 0, 0, time.UTC)
+	_ = json.NewEncoder(w).Encode(timezonesResponse{Date: day, Zones: zoneList(noon)})
+}
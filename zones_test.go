@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test the zone list covers the embedded tzdata without aliases
+func TestZoneNames(t *testing.T) {
+	if len(zoneNames) < 400 {
+		t.Fatalf("only %d zones", len(zoneNames))
+	}
+	seen := map[string]bool{}
+	for _, name := range zoneNames {
+		if seen[name] || !validZone(name) {
+			t.Errorf("%s: duplicate or not loadable", name)
+		}
+		seen[name] = true
+	}
+	for _, name := range []string{"Australia/Melbourne", "Europe/Kyiv", "Asia/Kolkata", "Etc/UTC", "Etc/GMT-10"} {
+		if !seen[name] {
+			t.Errorf("missing %s", name)
+		}
+	}
+	for _, alias := range []string{"Europe/Kiev", "Asia/Calcutta", "Etc/UCT", "US/Eastern"} {
+		if seen[alias] {
+			t.Errorf("alias %s listed", alias)
+		}
+	}
+	// Every zone the gazetteer or the coordinate lookup can produce is offered.
+	for _, p := range places {
+		if !seen[p.Zone] {
+			t.Errorf("%s: zone %s not listed", p.Name, p.Zone)
+			break
+		}
+	}
+	for _, zone := range zoneAliases {
+		if !seen[zone] {
+			t.Errorf("alias target %s not listed", zone)
+		}
+	}
+}
+
+// Test zones are described by offset, DST status and label for a date
+func TestZoneList(t *testing.T) {
+	find := func(zones []zoneInfo, name string) zoneInfo {
+		for _, z := range zones {
+			if z.Name == name {
+				return z
+			}
+		}
+		t.Fatalf("%s not listed", name)
+		return zoneInfo{}
+	}
+
+	jan := zoneList(time.Date(2026, 1, 15, 12, 0, 0, 0, time.UTC))
+	for i := 1; i < len(jan); i++ {
+		if jan[i].Offset < jan[i-1].Offset {
+			t.Fatalf("%s out of order", jan[i].Name)
+		}
+	}
+	if z := find(jan, "Australia/Sydney"); z.Offset != 11 || !z.DST || z.Abbr != "AEDT" || !strings.HasPrefix(z.Label, "(UTC+11:00) Sydney") {
+		t.Errorf("Sydney in January = %+v", z)
+	}
+	if z := find(jan, "Asia/Kathmandu"); z.Offset != 5.75 || z.DST || z.Abbr != "+0545" {
+		t.Errorf("Kathmandu = %+v", z)
+	}
+	jul := zoneList(time.Date(2026, 7, 15, 12, 0, 0, 0, time.UTC))
+	if z := find(jul, "Australia/Sydney"); z.Offset != 10 || z.DST {
+		t.Errorf("Sydney in July = %+v", z)
+	}
+	if z := find(jul, "Etc/GMT-10"); z.Label != "(UTC+10:00) Etc/GMT-10" {
+		t.Errorf("Etc label = %q", z.Label)
+	}
+
+	for name, want := range map[string]string{"America/Argentina/La_Rioja": "La Rioja", "Etc/UTC": "Etc/UTC"} {
+		if got := zoneLabel(name); !strings.Contains(got, want) {
+			t.Errorf("zoneLabel(%s) = %q", name, got)
+		}
+	}
+	for secs, want := range map[int]string{0: "UTC+00:00", 20700: "UTC+05:45", -12600: "UTC-03:30", -36000: "UTC-10:00"} {
+		if got := formatOffset(secs); got != want {
+			t.Errorf("formatOffset(%d) = %q, want %q", secs, got, want)
+		}
+	}
+}
+
+// Test /api/timezones answers for a date and rejects malformed ones
+func TestHandleTimezones(t *testing.T) {
+	rr := httptest.NewRecorder()
+	handleTimezones(rr, httptest.NewRequest("GET", "/api/timezones?date=2026-01-15", nil))
+	var resp timezonesResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if rr.Code != http.StatusOK || resp.Date != "2026-01-15" || len(resp.Zones) != len(zoneNames) {
+		t.Fatalf("status %d, date %q, %d zones", rr.Code, resp.Date, len(resp.Zones))
+	}
+	if rr.Header().Get("ETag") == "" || !strings.Contains(rr.Header().Get("Cache-Control"), "max-age=86400") {
+		t.Errorf("headers = %v", rr.Header())
+	}
+
+	rr = httptest.NewRecorder()
+	handleTimezones(rr, httptest.NewRequest("GET", "/api/timezones", nil))
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil || resp.Date != time.Now().UTC().Format(time.DateOnly) {
+		t.Errorf("default date = %q, %v", resp.Date, err)
+	}
+
+	rr = httptest.NewRecorder()
+	handleTimezones(rr, httptest.NewRequest("GET", "/api/timezones?date=15/01/2026", nil))
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "invalid date") {
+		t.Errorf("bad date: status %d %s", rr.Code, rr.Body)
+	}
+}